@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	ansibleCfgBlockStart = "# BEGIN gascan managed keys, changes here are replaced on the next extract"
+	ansibleCfgBlockEnd   = "# END gascan managed keys"
+	ansibleCfgReplaced   = "# replaced by gascan: "
+)
+
+var (
+	// ansibleCfgModes controls how ~/.ansible.cfg is updated when extracting the bundle
+	ansibleCfgModes = []string{"merge", "print", "replace", "skip"}
+
+	// ansibleCfgManagedKeys are merged from default.cfg, as gascan relies upon them; anything
+	// else in ~/.ansible.cfg is left as it is
+	ansibleCfgManagedKeys = map[string][]string{
+		"defaults": {
+			"callback_plugins",
+			"callback_whitelist",
+			"callbacks_enabled",
+			"filter_plugins",
+			"inventory",
+			"inventory_plugins",
+			"library",
+			"lookup_plugins",
+			"module_utils",
+			"roles_path",
+			"stdout_callback",
+			"strategy",
+			"strategy_plugins",
+		},
+		"inventory": {
+			"enable_plugins",
+		},
+	}
+)
+
+// AnsibleCfgSection holds the lines of a section, with the lines before the first section
+// kept in a section without a name
+type AnsibleCfgSection struct {
+	Lines []string
+	Name  string
+}
+
+// AnsibleCfg is an INI config for Ansible, which keeps comments and ordering
+type AnsibleCfg struct {
+	Sections []*AnsibleCfgSection
+}
+
+// splitAnsibleCfgKey finds the key and value for an option, using either = or : as Python does
+func splitAnsibleCfgKey(line string) (string, string, bool) {
+	// Indented lines continue the value of the previous key
+	trimmed := strings.TrimSpace(line)
+	if trimmed == "" || line != strings.TrimLeft(line, " \t") {
+		return "", "", false
+	}
+
+	if strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, ";") {
+		return "", "", false
+	}
+
+	idx := strings.IndexAny(trimmed, "=:")
+	if idx < 0 {
+		return strings.ToLower(trimmed), "", true
+	}
+
+	return strings.ToLower(strings.TrimSpace(trimmed[:idx])), strings.TrimSpace(trimmed[idx+1:]), true
+}
+
+func parseAnsibleCfg(data []byte) *AnsibleCfg {
+	cfg := &AnsibleCfg{Sections: []*AnsibleCfgSection{{}}}
+	current := cfg.Sections[0]
+
+	for _, ln := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+		trimmed := strings.TrimSpace(ln)
+
+		if strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") {
+			current = &AnsibleCfgSection{Name: strings.TrimSpace(trimmed[1 : len(trimmed)-1])}
+			cfg.Sections = append(cfg.Sections, current)
+
+			continue
+		}
+
+		current.Lines = append(current.Lines, ln)
+	}
+
+	if len(cfg.Sections[0].Lines) == 1 && cfg.Sections[0].Lines[0] == "" {
+		cfg.Sections[0].Lines = nil
+	}
+
+	return cfg
+}
+
+// Section finds a section by name, which is nil when it is missing
+func (c *AnsibleCfg) Section(name string) *AnsibleCfgSection {
+	idx := slices.IndexFunc(c.Sections, func(s *AnsibleCfgSection) bool { return s.Name == name })
+	if idx < 0 || name == "" {
+		return nil
+	}
+
+	return c.Sections[idx]
+}
+
+// Get finds the value for a key in a section, with continuation lines separated by newlines
+func (c *AnsibleCfg) Get(section string, key string) (string, bool) {
+	s := c.Section(section)
+	if s == nil {
+		return "", false
+	}
+
+	i, n := s.keyLines(key)
+	if i < 0 {
+		return "", false
+	}
+
+	_, v, _ := splitAnsibleCfgKey(s.Lines[i])
+
+	for _, next := range s.Lines[i+1 : i+n] {
+		v += "\n" + strings.TrimSpace(next)
+	}
+
+	return v, true
+}
+
+// keyLines finds the line for a key in a section, along with the number of lines used
+// by the value, or -1 when the key is missing
+func (s *AnsibleCfgSection) keyLines(key string) (int, int) {
+	for i, ln := range s.Lines {
+		if k, _, ok := splitAnsibleCfgKey(ln); !ok || k != key {
+			continue
+		}
+
+		n := 1
+		for _, next := range s.Lines[i+1:] {
+			if strings.TrimSpace(next) == "" || next == strings.TrimLeft(next, " \t") {
+				break
+			}
+
+			n++
+		}
+
+		return i, n
+	}
+
+	return -1, 0
+}
+
+// Set replaces the value for a key, or adds it to the end of the section
+func (c *AnsibleCfg) Set(section string, key string, value string) {
+	s := c.Section(section)
+	if s == nil {
+		if last := c.Sections[len(c.Sections)-1]; len(last.Lines) > 0 && last.Lines[len(last.Lines)-1] != "" {
+			last.Lines = append(last.Lines, "")
+		}
+
+		s = &AnsibleCfgSection{Name: section}
+		c.Sections = append(c.Sections, s)
+	}
+
+	ln := fmt.Sprintf("%s = %s", key, value)
+
+	if i, n := s.keyLines(key); i >= 0 {
+		s.Lines = slices.Replace(s.Lines, i, i+n, ln)
+		return
+	}
+
+	end := len(s.Lines)
+	for end > 0 && s.Lines[end-1] == "" {
+		end--
+	}
+
+	s.Lines = slices.Insert(s.Lines, end, ln)
+}
+
+// Unset removes a key, along with any continuation lines
+func (c *AnsibleCfg) Unset(section string, key string) {
+	if s := c.Section(section); s != nil {
+		if i, n := s.keyLines(key); i >= 0 {
+			s.Lines = slices.Delete(s.Lines, i, i+n)
+		}
+	}
+}
+
+func (c *AnsibleCfg) Bytes() []byte {
+	buf := bytes.Buffer{}
+
+	for _, s := range c.Sections {
+		if s.Name != "" {
+			fmt.Fprintf(&buf, "[%s]\n", s.Name)
+		}
+
+		for _, ln := range s.Lines {
+			buf.WriteString(ln + "\n")
+		}
+	}
+
+	return buf.Bytes()
+}
+
+// removeManagedBlock drops the keys from a previous merge
+func removeManagedBlock(lines []string) []string {
+	kept := []string{}
+	inBlock := false
+
+	for _, ln := range lines {
+		switch strings.TrimSpace(ln) {
+		case ansibleCfgBlockStart:
+			inBlock = true
+		case ansibleCfgBlockEnd:
+			inBlock = false
+		default:
+			if !inBlock {
+				kept = append(kept, ln)
+			}
+		}
+	}
+
+	return kept
+}
+
+// commentOutKeys comments the keys, along with any continuation lines, as Ansible rejects
+// duplicate keys within a section
+func commentOutKeys(lines []string, keys []string) []string {
+	out := []string{}
+	continuation := false
+
+	for _, ln := range lines {
+		k, _, ok := splitAnsibleCfgKey(ln)
+
+		switch {
+		case ok && slices.Contains(keys, k):
+			continuation = true
+			out = append(out, ansibleCfgReplaced+ln)
+		case continuation && ln != strings.TrimLeft(ln, " \t") && strings.TrimSpace(ln) != "":
+			out = append(out, ansibleCfgReplaced+ln)
+		default:
+			continuation = false
+			out = append(out, ln)
+		}
+	}
+
+	return out
+}
+
+// mergeAnsibleCfg merges the managed keys from the source into a block within each section,
+// commenting out any existing values for those keys
+func mergeAnsibleCfg(existing *AnsibleCfg, src *AnsibleCfg, managed map[string][]string) *AnsibleCfg {
+	for _, name := range sortedKeys(managed) {
+		block := []string{}
+		keys := []string{}
+
+		for _, k := range managed[name] {
+			if v, ok := src.Get(name, k); ok {
+				block = append(block, fmt.Sprintf("%s = %s", k, strings.ReplaceAll(v, "\n", "\n    ")))
+				keys = append(keys, k)
+			}
+		}
+
+		s := existing.Section(name)
+		if s == nil && len(block) == 0 {
+			continue
+		}
+
+		if s == nil {
+			if last := existing.Sections[len(existing.Sections)-1]; len(last.Lines) > 0 && last.Lines[len(last.Lines)-1] != "" {
+				last.Lines = append(last.Lines, "")
+			}
+
+			s = &AnsibleCfgSection{Name: name}
+			existing.Sections = append(existing.Sections, s)
+		}
+
+		s.Lines = commentOutKeys(removeManagedBlock(s.Lines), keys)
+
+		// Keep the blank lines that separate the sections after the block
+		trailing := 0
+		for trailing < len(s.Lines) && s.Lines[len(s.Lines)-1-trailing] == "" {
+			trailing++
+		}
+
+		body := s.Lines[:len(s.Lines)-trailing]
+
+		if len(block) > 0 {
+			body = append(body, ansibleCfgBlockStart)
+			body = append(body, strings.Split(strings.Join(block, "\n"), "\n")...)
+			body = append(body, ansibleCfgBlockEnd)
+		}
+
+		s.Lines = append(body, make([]string, trailing)...)
+	}
+
+	return existing
+}
+
+func ansibleCfgBackupPath(path string) string {
+	return fmt.Sprintf("%s.%s.bak", path, time.Now().Format("20060102-150405"))
+}
+
+// updateAnsibleCfg applies default.cfg to the Ansible config, keeping a timestamped backup of
+// the existing config whenever it changes
+func updateAnsibleCfg(path string, src []byte, mode string) error {
+	existing, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	result := src
+
+	switch mode {
+	case "skip":
+		return nil
+	case "merge", "print":
+		if existing != nil {
+			result = mergeAnsibleCfg(parseAnsibleCfg(existing), parseAnsibleCfg(src), ansibleCfgManagedKeys).Bytes()
+		}
+	case "replace":
+	default:
+		return fmt.Errorf("unsupported mode '%s', expected one of: %s", mode, strings.Join(ansibleCfgModes, ", "))
+	}
+
+	if mode == "print" {
+		fmt.Print(string(result))
+		return nil
+	}
+
+	if bytes.Equal(existing, result) {
+		Logger.Debug("'%s' is unchanged", path)
+		return nil
+	}
+
+	if existing != nil {
+		backup := ansibleCfgBackupPath(path)
+		if err := writeFileAtomic(backup, existing, 0o640); err != nil {
+			return fmt.Errorf("unable to keep a backup of '%s': %w", path, err)
+		}
+
+		fmt.Printf("Saved a backup of '%s' as '%s'\n", path, backup)
+	}
+
+	// A symlinked config, e.g. from a dotfiles repository, is updated in place to keep the link
+	target, err := resolveSymlink(path)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(target, result, 0o640)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const ansibleCfgDummy = `[defaults]
+inventory = /opt/gascan/inventory,
+    /opt/gascan/extra
+inventory_plugins = /opt/gascan/plugins/inventory
+callback_plugins = /opt/gascan/plugins/callback
+host_key_checking = false
+log_path = /tmp/ansible.log
+
+[ssh_connection]
+pipelining = True
+`
+
+func TestAnsibleCfg(t *testing.T) {
+	existing := `# My settings
+[defaults]
+forks = 50
+inventory = ~/hosts
+   ~/more-hosts
+callback_plugins: ~/callbacks
+
+[privilege_escalation]
+become = true
+`
+	file := filepath.Join(t.TempDir(), "ansible.cfg")
+	if err := os.WriteFile(file, []byte(existing), 0o640); err != nil {
+		t.Fatalf("unable to write '%s': %v", file, err)
+	}
+
+	cfg := parseAnsibleCfg([]byte(ansibleCfgDummy))
+	if v, ok := cfg.Get("ssh_connection", "pipelining"); !ok || v != "True" {
+		t.Fatalf("expected pipelining to be True, got %q", v)
+	}
+
+	if string(parseAnsibleCfg([]byte(existing)).Bytes()) != existing {
+		t.Fatalf("expected the config to be unchanged after parsing")
+	}
+
+	if err := updateAnsibleCfg(file, []byte(ansibleCfgDummy), "merge"); err != nil {
+		t.Fatalf("failed to updateAnsibleCfg: %v", err)
+	}
+
+	data, _ := os.ReadFile(file)
+	merged := parseAnsibleCfg(data)
+
+	expected := map[string]string{
+		"forks":             "50",
+		"inventory":         "/opt/gascan/inventory,\n/opt/gascan/extra",
+		"inventory_plugins": "/opt/gascan/plugins/inventory",
+		"callback_plugins":  "/opt/gascan/plugins/callback",
+	}
+
+	for k, v := range expected {
+		if got, _ := merged.Get("defaults", k); got != v {
+			t.Fatalf("expected %s = %q, got %q in:\n%s", k, v, got, data)
+		}
+	}
+
+	for _, s := range []string{"# My settings\n", ansibleCfgReplaced + "inventory = ~/hosts\n", ansibleCfgReplaced + "   ~/more-hosts\n", "[privilege_escalation]\nbecome = true\n"} {
+		if !strings.Contains(string(data), s) {
+			t.Fatalf("expected %q in:\n%s", s, data)
+		}
+	}
+
+	if _, ok := merged.Get("defaults", "host_key_checking"); ok {
+		t.Fatalf("expected only the managed keys to be merged:\n%s", data)
+	}
+
+	backups, _ := filepath.Glob(file + ".*.bak")
+	if len(backups) != 1 {
+		t.Fatalf("expected a backup, got %v", backups)
+	}
+
+	if b, _ := os.ReadFile(backups[0]); string(b) != existing {
+		t.Fatalf("expected the backup to match the original, got:\n%s", b)
+	}
+
+	// Merging again is a no-op, so no further backups are needed
+	os.Remove(backups[0])
+
+	if err := updateAnsibleCfg(file, []byte(ansibleCfgDummy), "merge"); err != nil {
+		t.Fatalf("failed to updateAnsibleCfg: %v", err)
+	}
+
+	if again, _ := os.ReadFile(file); string(again) != string(data) {
+		t.Fatalf("expected the merge to be idempotent, got:\n%s", again)
+	}
+
+	if backups, _ := filepath.Glob(file + ".*.bak"); len(backups) != 0 {
+		t.Fatalf("expected no backup for an unchanged config, got %v", backups)
+	}
+
+	if err := updateAnsibleCfg(file, []byte(ansibleCfgDummy), "replace"); err != nil {
+		t.Fatalf("failed to updateAnsibleCfg: %v", err)
+	}
+
+	if replaced, _ := os.ReadFile(file); string(replaced) != ansibleCfgDummy {
+		t.Fatalf("expected the config to be replaced, got:\n%s", replaced)
+	}
+
+	if err := updateAnsibleCfg(file, nil, "invalid"); err == nil {
+		t.Fatalf("expected an error for an unsupported mode")
+	}
+}
+
+func TestUpdateAnsibleCfgSymlink(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "dotfiles", "ansible.cfg")
+	file := filepath.Join(dir, ".ansible.cfg")
+
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		t.Fatalf("unable to create '%s': %v", filepath.Dir(target), err)
+	}
+
+	if err := os.WriteFile(target, []byte("[defaults]\nforks = 5\n"), 0o640); err != nil {
+		t.Fatalf("unable to write '%s': %v", target, err)
+	}
+
+	if err := os.Symlink(target, file); err != nil {
+		t.Fatalf("unable to link '%s': %v", file, err)
+	}
+
+	if err := updateAnsibleCfg(file, []byte(ansibleCfgDummy), "merge"); err != nil {
+		t.Fatalf("failed to updateAnsibleCfg: %v", err)
+	}
+
+	if fi, err := os.Lstat(file); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Fatalf("expected '%s' to remain a symlink, got %v (%v)", file, fi, err)
+	}
+
+	data, _ := os.ReadFile(target)
+	if v, _ := parseAnsibleCfg(data).Get("defaults", "callback_plugins"); v != "/opt/gascan/plugins/callback" {
+		t.Fatalf("expected the target to be merged, got:\n%s", data)
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	// ansibleDefaultSSHArgs are used by Ansible when ssh_args is not set
+	ansibleDefaultSSHArgs = "-C " + multiplexSSHArgs
+	ansibleSettingsFile   = "ansible.cfg"
+
+	// multiplexControlDir holds the sockets for ControlPersist in the workspace
+	multiplexControlDir = "cp"
+	multiplexSSHArgs    = "-o ControlMaster=auto -o ControlPersist=60s"
+)
+
+// ansibleSettingKeys maps the flags for tuning Ansible to their section and key in ansible.cfg
+var ansibleSettingKeys = map[string][2]string{
+	"forks":             {"defaults", "forks"},
+	"host-key-checking": {"defaults", "host_key_checking"},
+	"log-path":          {"defaults", "log_path"},
+	"pipelining":        {"ssh_connection", "pipelining"},
+	"ssh-args":          {"ssh_connection", "ssh_args"},
+	"timeout":           {"defaults", "timeout"},
+}
+
+// AnsibleSettings are the options for tuning Ansible, seeded by default.cfg. Only the options
+// that are set in default.cfg, or overridden, are written so that Ansible keeps its defaults.
+type AnsibleSettings struct {
+	Forks           int
+	HostKeyChecking bool
+	LogPath         string
+	Pipelining      bool
+	SSHArgs         string
+	Timeout         int
+
+	set map[string]bool
+}
+
+// ansibleConfigTemplate provides the values for templates/defaultConfig.j2
+type ansibleConfigTemplate struct {
+	Inventory string
+	LogPath   string
+}
+
+func (a *ansibleConfigTemplate) render(tmpl *template.Template) []byte {
+	content, err := renderTemplate(a, tmpl)
+	if err != nil {
+		Logger.Fatal("failed to render template '%s': %v", tmpl.Name(), err)
+	}
+
+	return content
+}
+
+// parseAnsibleBool accepts the same values as Ansible
+func parseAnsibleBool(v string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "1", "on", "t", "true", "y", "yes":
+		return true, nil
+	case "0", "off", "f", "false", "n", "no":
+		return false, nil
+	}
+
+	return false, fmt.Errorf("expected a boolean, got '%s'", v)
+}
+
+// newAnsibleSettings reads the settings from the config, using the defaults from Ansible
+// for anything that is missing
+func newAnsibleSettings(cfg *AnsibleCfg) (*AnsibleSettings, error) {
+	s := &AnsibleSettings{Forks: 5, HostKeyChecking: true, Timeout: 10, set: map[string]bool{}}
+	errs := []error{}
+
+	for _, name := range sortedKeys(ansibleSettingKeys) {
+		k := ansibleSettingKeys[name]
+
+		if v, ok := cfg.Get(k[0], k[1]); ok {
+			errs = append(errs, s.Set(name, v))
+		}
+	}
+
+	return s, errors.Join(errs...)
+}
+
+// Set parses the value for a flag, e.g. forks
+func (s *AnsibleSettings) Set(name string, value string) error {
+	var err error
+
+	switch name {
+	case "forks":
+		s.Forks, err = strconv.Atoi(strings.TrimSpace(value))
+	case "host-key-checking":
+		s.HostKeyChecking, err = parseAnsibleBool(value)
+	case "log-path":
+		s.LogPath = strings.TrimSpace(value)
+	case "pipelining":
+		s.Pipelining, err = parseAnsibleBool(value)
+	case "ssh-args":
+		s.SSHArgs = strings.TrimSpace(value)
+	case "timeout":
+		s.Timeout, err = strconv.Atoi(strings.TrimSpace(value))
+	default:
+		return fmt.Errorf("unknown setting '%s'", name)
+	}
+
+	if err != nil {
+		return fmt.Errorf("invalid value for %s: %w", name, err)
+	}
+
+	s.set[name] = true
+
+	return nil
+}
+
+// Apply sets the overrides from flags, creating a log for this run when the log path is a
+// directory, i.e. it ends with / or already exists as a directory
+func (s *AnsibleSettings) Apply(overrides map[string]string, now time.Time) error {
+	errs := []error{}
+
+	for _, name := range sortedKeys(overrides) {
+		errs = append(errs, s.Set(name, overrides[name]))
+	}
+
+	if s.LogPath != "" {
+		s.LogPath = expandHome(s.LogPath)
+
+		if fi, err := os.Stat(s.LogPath); strings.HasSuffix(s.LogPath, "/") || (err == nil && fi.IsDir()) {
+			s.LogPath = filepath.Join(s.LogPath, "ansible-"+now.Format("20060102-150405")+".log")
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Validate checks the settings before Ansible starts, creating the directory for the log
+func (s *AnsibleSettings) Validate() error {
+	errs := []error{}
+
+	if s.Forks < 1 {
+		errs = append(errs, fmt.Errorf("forks must be at least 1, got %d", s.Forks))
+	}
+
+	if s.Timeout < 1 {
+		errs = append(errs, fmt.Errorf("timeout must be at least 1 second, got %d", s.Timeout))
+	}
+
+	if _, err := splitShellWords(s.SSHArgs); err != nil {
+		errs = append(errs, fmt.Errorf("invalid ssh-args: %w", err))
+	}
+
+	if s.LogPath != "" {
+		if err := os.MkdirAll(filepath.Dir(s.LogPath), 0o750); err != nil {
+			errs = append(errs, fmt.Errorf("unable to create the directory for log-path: %w", err))
+		} else if f, err := os.OpenFile(s.LogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o640); err != nil {
+			errs = append(errs, fmt.Errorf("unable to write to log-path: %w", err))
+		} else {
+			f.Close()
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// PinHostKeys turns on host key checking against the known_hosts file that is managed by
+// gascan, adding the options to ssh_args unless a known_hosts file is already set there
+func (s *AnsibleSettings) PinHostKeys(path string) error {
+	args := s.SSHArgs
+	if !s.set["ssh-args"] {
+		args = ansibleDefaultSSHArgs
+	}
+
+	if !strings.Contains(args, "UserKnownHostsFile") {
+		args = strings.TrimSpace(args + " " + joinShellWords(hostKeySSHArgs(path)))
+	}
+
+	s.HostKeyChecking, s.SSHArgs = true, args
+	s.set["host-key-checking"], s.set["ssh-args"] = true, true
+
+	return os.MkdirAll(filepath.Dir(path), 0o700)
+}
+
+// Multiplex reuses the SSH connections to each host for the run, keeping the sockets in the
+// workspace, unless ControlPersist is already set in ssh_args
+func (s *AnsibleSettings) Multiplex(cfg *AnsibleCfg, workspace string) error {
+	if s.set["ssh-args"] && !strings.Contains(s.SSHArgs, "ControlPersist") {
+		s.SSHArgs = strings.TrimSpace(s.SSHArgs + " " + multiplexSSHArgs)
+	}
+
+	if _, ok := cfg.Get("ssh_connection", "control_path_dir"); ok {
+		return nil
+	}
+
+	dir := filepath.Join(workspace, multiplexControlDir)
+	cfg.Set("ssh_connection", "control_path_dir", dir)
+
+	return os.MkdirAll(dir, 0o700)
+}
+
+// Store writes the settings that were set into the config
+func (s *AnsibleSettings) Store(cfg *AnsibleCfg) {
+	values := map[string]string{
+		"forks":             strconv.Itoa(s.Forks),
+		"host-key-checking": strconv.FormatBool(s.HostKeyChecking),
+		"log-path":          s.LogPath,
+		"pipelining":        strconv.FormatBool(s.Pipelining),
+		"ssh-args":          s.SSHArgs,
+		"timeout":           strconv.Itoa(s.Timeout),
+	}
+
+	for _, name := range sortedKeys(ansibleSettingKeys) {
+		k := ansibleSettingKeys[name]
+
+		switch {
+		case !s.set[name]:
+		case values[name] == "":
+			cfg.Unset(k[0], k[1])
+		default:
+			cfg.Set(k[0], k[1], values[name])
+		}
+	}
+}
+
+// seedAnsibleCfg reads default.cfg from the workspace, or renders the template for it when
+// the bundle does not include one
+func seedAnsibleCfg(workspace string, inventory string) (*AnsibleCfg, error) {
+	if data, err := os.ReadFile(filepath.Join(workspace, "default.cfg")); err == nil {
+		return parseAnsibleCfg(data), nil
+	}
+
+	j2, err := os.ReadFile(filepath.Join(workspace, defaultConfig))
+	if errors.Is(err, os.ErrNotExist) {
+		// Templates are skipped in adhoc mode, leaving Ansible to use its defaults
+		Logger.Debug("unable to locate default.cfg or '%s'", defaultConfig)
+		return parseAnsibleCfg(nil), nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl, err := template.New(defaultConfig).Parse(string(j2))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template '%s': %w", defaultConfig, err)
+	}
+
+	data, err := renderTemplate(&ansibleConfigTemplate{Inventory: inventory}, tmpl)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render template '%s': %w", defaultConfig, err)
+	}
+
+	cfg := parseAnsibleCfg(data)
+
+	// An empty value is not the same as a missing one
+	for _, k := range [][2]string{{"defaults", "inventory"}, {"defaults", "log_path"}} {
+		if v, ok := cfg.Get(k[0], k[1]); ok && v == "" {
+			cfg.Unset(k[0], k[1])
+		}
+	}
+
+	return cfg, nil
+}
+
+// configureAnsible writes the ansible.cfg for the workspace, applying the overrides from flags
+func configureAnsible(workspace string, inventory string, overrides map[string]string) (string, error) {
+	cfg, err := seedAnsibleCfg(workspace, inventory)
+	if err != nil {
+		return "", err
+	}
+
+	s, err := newAnsibleSettings(cfg)
+	if err != nil {
+		return "", fmt.Errorf("invalid settings in default.cfg: %w", err)
+	}
+
+	if err := s.Apply(overrides, time.Now()); err != nil {
+		return "", err
+	}
+
+	// Checking is only turned off by the flag, since default.cfg has turned it off in the past
+	if _, ok := overrides["host-key-checking"]; !ok || s.HostKeyChecking {
+		if err := s.PinHostKeys(knownHostsPath()); err != nil {
+			return "", fmt.Errorf("unable to create the directory for the known hosts: %w", err)
+		}
+	}
+
+	if err := s.Validate(); err != nil {
+		return "", err
+	}
+
+	if err := s.Multiplex(cfg, workspace); err != nil {
+		return "", fmt.Errorf("unable to create the directory for ControlPersist: %w", err)
+	}
+
+	s.Store(cfg)
+
+	path := filepath.Join(workspace, ansibleSettingsFile)
+	if err := writeFileAtomic(path, cfg.Bytes(), 0o440); err != nil {
+		return "", err
+	}
+
+	if s.LogPath != "" {
+		Logger.Info("Ansible will log to '%s'", s.LogPath)
+	}
+
+	return path, nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAnsibleSettings(t *testing.T) {
+	workspace := t.TempDir()
+	logDir := filepath.Join(t.TempDir(), "logs") + "/"
+
+	if err := os.WriteFile(filepath.Join(workspace, "default.cfg"), []byte(ansibleCfgDummy), 0o640); err != nil {
+		t.Fatalf("unable to write default.cfg: %v", err)
+	}
+
+	cfg, err := seedAnsibleCfg(workspace, "")
+	if err != nil {
+		t.Fatalf("failed to seedAnsibleCfg: %v", err)
+	}
+
+	s, err := newAnsibleSettings(cfg)
+	if err != nil {
+		t.Fatalf("failed to newAnsibleSettings: %v", err)
+	}
+
+	if s.Forks != 5 || s.HostKeyChecking || !s.Pipelining || s.LogPath != "/tmp/ansible.log" {
+		t.Fatalf("unexpected settings from default.cfg: %+v", s)
+	}
+
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.Local)
+	if err := s.Apply(map[string]string{"forks": "20", "log-path": logDir, "pipelining": "no", "ssh-args": "-o ControlMaster=auto"}, now); err != nil {
+		t.Fatalf("failed to Apply: %v", err)
+	}
+
+	if err := s.Validate(); err != nil {
+		t.Fatalf("failed to Validate: %v", err)
+	}
+
+	if s.LogPath != filepath.Join(logDir, "ansible-20240102-030405.log") {
+		t.Fatalf("expected a log for this run, got '%s'", s.LogPath)
+	}
+
+	s.Store(cfg)
+	out := string(cfg.Bytes())
+
+	for _, e := range []string{"forks = 20\n", "log_path = " + s.LogPath + "\n", "pipelining = false\n", "ssh_args = -o ControlMaster=auto\n", "host_key_checking = false\n", "callback_plugins = /opt/gascan/plugins/callback\n"} {
+		if !strings.Contains(out, e) {
+			t.Fatalf("expected %q in:\n%s", e, out)
+		}
+	}
+
+	// Options that were not set are left to Ansible
+	if strings.Contains(out, "timeout") {
+		t.Fatalf("expected timeout to be omitted:\n%s", out)
+	}
+
+	if err := s.Apply(map[string]string{"forks": "many", "pipelining": "maybe"}, now); err == nil {
+		t.Fatalf("expected an error for invalid values")
+	}
+
+	invalid := &AnsibleSettings{Forks: 0, Timeout: 10, SSHArgs: `-o "unterminated`, set: map[string]bool{}}
+	if err := invalid.Validate(); err == nil || !strings.Contains(err.Error(), "forks") || !strings.Contains(err.Error(), "ssh-args") {
+		t.Fatalf("expected errors for forks and ssh-args, got %v", err)
+	}
+
+	t.Setenv("HOME", t.TempDir())
+
+	path, err := configureAnsible(workspace, "", map[string]string{"log-path": logDir, "timeout": "30"})
+	if err != nil {
+		t.Fatalf("failed to configureAnsible: %v", err)
+	}
+
+	// Host keys are checked against the file managed by gascan, despite default.cfg
+	pinned := "ssh_args = " + ansibleDefaultSSHArgs + " -o UserKnownHostsFile=" + knownHostsPath() + " -o StrictHostKeyChecking=accept-new"
+	// Connections are reused for the run, with the sockets in the workspace
+	controlPath := "control_path_dir = " + filepath.Join(workspace, multiplexControlDir) + "\n"
+	for _, e := range []string{"[defaults]\n", "timeout = 30\n", "host_key_checking = true\n", pinned, controlPath} {
+		if data, _ := os.ReadFile(path); !strings.Contains(string(data), e) {
+			t.Fatalf("expected %q in:\n%s", e, data)
+		}
+	}
+
+	path, err = configureAnsible(workspace, "", map[string]string{"host-key-checking": "false", "log-path": logDir})
+	if err != nil {
+		t.Fatalf("failed to configureAnsible: %v", err)
+	}
+
+	if data, _ := os.ReadFile(path); !strings.Contains(string(data), "host_key_checking = false\n") || strings.Contains(string(data), "UserKnownHostsFile") {
+		t.Fatalf("expected checking to be turned off by the flag:\n%s", data)
+	}
+
+	path, err = configureAnsible(workspace, "", map[string]string{"log-path": logDir, "ssh-args": "-o ServerAliveInterval=30"})
+	if err != nil {
+		t.Fatalf("failed to configureAnsible: %v", err)
+	}
+
+	if data, _ := os.ReadFile(path); !strings.Contains(string(data), "ssh_args = -o ServerAliveInterval=30 ") || !strings.Contains(string(data), multiplexSSHArgs+"\n") {
+		t.Fatalf("expected multiplexing to be added to the custom ssh_args:\n%s", data)
+	}
+}
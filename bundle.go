@@ -0,0 +1,476 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const bundleUsage = `ACTION [ARGS]
+
+Actions:
+  ls [-l] [PREFIX]   List the files in the bundle
+  cat FILE...        Print files from the bundle
+  export DIR         Export the bundle to a directory
+  diff OTHER         Compare with a bundle tarball, or the bundle in another gascan binary
+`
+
+var gzipMagic = []byte{0x1f, 0x8b, 0x08}
+
+// BundleFile is an entry read from a bundle tarball
+type BundleFile struct {
+	Name     string
+	Linkname string
+	Mode     int64
+	Size     int64
+	Type     byte
+	Content  []byte
+}
+
+// BundleDiff summarises the changes between two bundles
+type BundleDiff struct {
+	Playbooks   []BundleChange
+	Roles       []BundleChange
+	Defaults    []BundleChange
+	Other       []BundleChange
+	Differences int
+}
+
+// BundleChange describes an added (+), removed (-) or changed (~) item
+type BundleChange struct {
+	Action string
+	Name   string
+	Detail string
+}
+
+func readBundle(tgz []byte) ([]BundleFile, error) {
+	var files []BundleFile
+
+	gbuf, err := gzip.NewReader(bytes.NewBuffer(tgz))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read with gzip: %w", err)
+	}
+
+	gbuf.Multistream(false)
+	tr := tar.NewReader(gbuf)
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tarball: %w", err)
+		}
+
+		if strings.Contains(hdr.Name, "..") || strings.Contains(hdr.Linkname, "..") {
+			Logger.Warning("unexpected path found in bundle: %v", hdr.Name)
+			continue
+		}
+
+		name := strings.TrimSuffix(strings.Replace(hdr.Name, "automation/", "", 1), "/")
+		if name == "" || name == "automation" || name == "pax_global_header" {
+			continue
+		}
+
+		f := BundleFile{
+			Name:     name,
+			Linkname: strings.Replace(hdr.Linkname, "automation/", "", 1),
+			Mode:     hdr.Mode,
+			Size:     hdr.Size,
+			Type:     hdr.Typeflag,
+		}
+
+		if hdr.Typeflag == tar.TypeReg {
+			if f.Content, err = io.ReadAll(tr); err != nil {
+				return nil, fmt.Errorf("failed to read '%s': %w", hdr.Name, err)
+			}
+		}
+
+		files = append(files, f)
+	}
+
+	return files, nil
+}
+
+// exportBundle writes the files to a directory, replacing those from a previous export, with
+// the files left writable, unlike when extracting for a run
+func exportBundle(files []BundleFile, dir string) error {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		pth := filepath.Join(dir, f.Name)
+
+		if rel, err := filepath.Rel(dir, pth); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("unexpected path '%s' in the bundle", f.Name)
+		}
+
+		if f.Type != tar.TypeDir {
+			if err := os.MkdirAll(filepath.Dir(pth), 0o750); err != nil {
+				return err
+			}
+		}
+
+		switch f.Type {
+		case tar.TypeDir:
+			if err := os.MkdirAll(pth, 0o750); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := writeFileAtomic(pth, f.Content, 0o640); err != nil {
+				return fmt.Errorf("failed to write '%s': %w", pth, err)
+			}
+		case tar.TypeSymlink:
+			if err := os.Remove(pth); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+
+			if err := os.Symlink(f.Linkname, pth); err != nil {
+				return fmt.Errorf("failed to create symlink '%s': %w", pth, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func findEmbeddedBundle(data []byte) ([]byte, error) {
+	for offset := 0; offset < len(data); {
+		i := bytes.Index(data[offset:], gzipMagic)
+		if i < 0 {
+			break
+		}
+
+		start := offset + i
+		offset = start + 1
+
+		files, err := readBundle(data[start:])
+		if err != nil || len(files) == 0 {
+			continue
+		}
+
+		for _, f := range files {
+			if f.Type == tar.TypeReg && !strings.Contains(f.Name, "/") && strings.HasSuffix(f.Name, ".yaml") {
+				return data[start:], nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("unable to locate a bundle")
+}
+
+func loadBundle(pth string) ([]BundleFile, error) {
+	data, err := os.ReadFile(pth)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.HasPrefix(data, gzipMagic) {
+		Logger.Debug("searching for an embedded bundle in '%s'", pth)
+
+		if data, err = findEmbeddedBundle(data); err != nil {
+			return nil, fmt.Errorf("%v in '%s'", err, pth)
+		}
+	}
+
+	return readBundle(data)
+}
+
+func bundleRole(name string) string {
+	if !strings.HasPrefix(name, "roles/") {
+		return ""
+	}
+
+	return strings.SplitN(name, "/", 3)[1]
+}
+
+func bundleDefaults(f BundleFile) (map[string]interface{}, error) {
+	defaults := map[string]interface{}{}
+
+	if err := yaml.Unmarshal(f.Content, &defaults); err != nil {
+		return nil, fmt.Errorf("unable to parse '%s': %w", f.Name, err)
+	}
+
+	return defaults, nil
+}
+
+func formatBundleValue(v interface{}) string {
+	b, err := yaml.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+
+	return strings.ReplaceAll(strings.TrimSpace(string(b)), "\n", " ")
+}
+
+func diffBundleDefaults(role string, a BundleFile, b BundleFile) ([]BundleChange, error) {
+	var changes []BundleChange
+
+	da, err := bundleDefaults(a)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := bundleDefaults(b)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []string{}
+	for k := range da {
+		keys = append(keys, k)
+	}
+
+	for k := range db {
+		if _, ok := da[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+
+	slices.Sort(keys)
+
+	for _, k := range keys {
+		va, inA := da[k]
+		vb, inB := db[k]
+		name := role + "/" + k
+
+		switch {
+		case !inA:
+			changes = append(changes, BundleChange{"+", name, formatBundleValue(vb)})
+		case !inB:
+			changes = append(changes, BundleChange{"-", name, formatBundleValue(va)})
+		case !reflect.DeepEqual(va, vb):
+			changes = append(changes, BundleChange{"~", name, formatBundleValue(va) + " -> " + formatBundleValue(vb)})
+		}
+	}
+
+	return changes, nil
+}
+
+func diffBundles(a []BundleFile, b []BundleFile) (BundleDiff, error) {
+	var diff BundleDiff
+
+	filesA := map[string]BundleFile{}
+	filesB := map[string]BundleFile{}
+	names := []string{}
+
+	for _, f := range a {
+		filesA[f.Name] = f
+		names = append(names, f.Name)
+	}
+
+	for _, f := range b {
+		filesB[f.Name] = f
+		if _, ok := filesA[f.Name]; !ok {
+			names = append(names, f.Name)
+		}
+	}
+
+	slices.Sort(names)
+
+	roles := map[string]string{}
+	roleNames := []string{}
+
+	for _, name := range names {
+		fa, inA := filesA[name]
+		fb, inB := filesB[name]
+		role := bundleRole(name)
+
+		action := ""
+		switch {
+		case !inA:
+			action = "+"
+		case !inB:
+			action = "-"
+		case fa.Type != fb.Type || fa.Linkname != fb.Linkname || !bytes.Equal(fa.Content, fb.Content):
+			action = "~"
+		}
+
+		if action == "" {
+			continue
+		}
+
+		diff.Differences++
+
+		switch {
+		case role != "" && name == path.Join("roles", role):
+			roles[role] = action
+			roleNames = append(roleNames, role)
+		case role != "":
+			if _, ok := roles[role]; !ok {
+				roles[role] = "~"
+				roleNames = append(roleNames, role)
+			}
+
+			if roles[role] == "~" && name == path.Join("roles", role, "defaults", "main.yaml") {
+				changes, err := diffBundleDefaults(role, fa, fb)
+				if err != nil {
+					return diff, err
+				}
+
+				diff.Defaults = append(diff.Defaults, changes...)
+			}
+		case !strings.Contains(name, "/") && strings.HasSuffix(name, ".yaml"):
+			diff.Playbooks = append(diff.Playbooks, BundleChange{Action: action, Name: name})
+		default:
+			diff.Other = append(diff.Other, BundleChange{Action: action, Name: name})
+		}
+	}
+
+	slices.Sort(roleNames)
+	for _, role := range slices.Compact(roleNames) {
+		diff.Roles = append(diff.Roles, BundleChange{Action: roles[role], Name: role})
+	}
+
+	return diff, nil
+}
+
+func printBundleDiff(diff BundleDiff) {
+	sections := []struct {
+		Title   string
+		Changes []BundleChange
+	}{
+		{"Playbooks", diff.Playbooks},
+		{"Roles", diff.Roles},
+		{"Default variables", diff.Defaults},
+		{"Other files", diff.Other},
+	}
+
+	if diff.Differences == 0 {
+		fmt.Println("No differences found")
+		return
+	}
+
+	for _, s := range sections {
+		if len(s.Changes) == 0 {
+			continue
+		}
+
+		fmt.Printf("%s:\n", s.Title)
+
+		for _, c := range s.Changes {
+			if c.Detail == "" {
+				fmt.Printf("  %s %s\n", c.Action, c.Name)
+			} else {
+				fmt.Printf("  %s %s: %s\n", c.Action, c.Name, c.Detail)
+			}
+		}
+	}
+}
+
+func bundleCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "Usage of bundle:\n  bundle %s", bundleUsage)
+		return 1
+	}
+
+	files, err := readBundle(bundle)
+	if err != nil {
+		Logger.Error("unable to read the bundle: %v", err)
+		return 1
+	}
+
+	switch args[0] {
+	case "ls":
+		fs := subcommandFlags("bundle ls", "[-l] [PREFIX]")
+		long := fs.Bool("l", false, "Use a long listing format")
+		args = parseSubcommandFlags(fs, args[1:])
+
+		for _, f := range files {
+			if len(args) > 0 && !strings.HasPrefix(f.Name, args[0]) {
+				continue
+			}
+
+			if !*long {
+				fmt.Println(f.Name)
+				continue
+			}
+
+			name := f.Name
+			if f.Type == tar.TypeSymlink {
+				name += " -> " + f.Linkname
+			}
+
+			fmt.Printf("%c %04o %8d %s\n", bundleTypeFlag(f.Type), f.Mode, f.Size, name)
+		}
+	case "cat":
+		args = parseSubcommandFlags(subcommandFlags("bundle cat", "FILE..."), args[1:])
+		if len(args) == 0 {
+			Logger.Error("please specify at least one file")
+			return 1
+		}
+
+		for _, name := range args {
+			idx := slices.IndexFunc(files, func(f BundleFile) bool { return f.Name == name && f.Type == tar.TypeReg })
+			if idx < 0 {
+				Logger.Error("unable to locate '%s' in the bundle", name)
+				return 1
+			}
+
+			os.Stdout.Write(files[idx].Content)
+		}
+	case "export":
+		args = parseSubcommandFlags(subcommandFlags("bundle export", "DIR"), args[1:])
+		if len(args) != 1 {
+			Logger.Error("please specify the directory to export to")
+			return 1
+		}
+
+		if err := exportBundle(files, args[0]); err != nil {
+			Logger.Error("unable to export the bundle to '%s': %v", args[0], err)
+			return 1
+		}
+
+		fmt.Println("Exported bundle to:", args[0])
+	case "diff":
+		args = parseSubcommandFlags(subcommandFlags("bundle diff", "OTHER"), args[1:])
+		if len(args) != 1 {
+			Logger.Error("please specify a bundle tarball, or gascan binary, to compare with")
+			return 1
+		}
+
+		other, err := loadBundle(args[0])
+		if err != nil {
+			Logger.Error("unable to load bundle: %v", err)
+			return 1
+		}
+
+		diff, err := diffBundles(files, other)
+		if err != nil {
+			Logger.Error("unable to compare bundles: %v", err)
+			return 1
+		}
+
+		fmt.Printf("Comparing bundle %s with '%s'\n", BundleVersion, args[0])
+		printBundleDiff(diff)
+	default:
+		Logger.Error("unknown action '%s'", args[0])
+		fmt.Fprintf(os.Stderr, "Usage of bundle:\n  bundle %s", bundleUsage)
+		return 1
+	}
+
+	return 0
+}
+
+func bundleTypeFlag(t byte) byte {
+	switch t {
+	case tar.TypeDir:
+		return 'd'
+	case tar.TypeSymlink:
+		return 'l'
+	default:
+		return '-'
+	}
+}
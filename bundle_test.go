@@ -0,0 +1,156 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func generateBundle(files []BundleFile) ([]byte, error) {
+	var buf bytes.Buffer
+
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+
+	for _, f := range files {
+		hdr := &tar.Header{
+			Name:     "automation/" + f.Name,
+			Linkname: f.Linkname,
+			Mode:     f.Mode,
+			Size:     int64(len(f.Content)),
+			Typeflag: f.Type,
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return nil, err
+		}
+
+		if _, err := tw.Write(f.Content); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func TestBundleDiff(t *testing.T) {
+	files, err := readBundle(bundle)
+	if err != nil {
+		t.Fatalf("failed to readBundle: %v", err)
+	}
+
+	if !slices.ContainsFunc(files, func(f BundleFile) bool { return f.Name == "ping.yaml" }) {
+		t.Fatalf("expected ping.yaml in the bundle")
+	}
+
+	other := []BundleFile{}
+	for _, f := range files {
+		switch f.Name {
+		case "tools.yaml":
+			continue
+		case "ping.yaml":
+			f.Content = append(f.Content, []byte("# changed\n")...)
+		case "roles/pmm/defaults/main.yaml":
+			f.Content = []byte(strings.Replace(string(f.Content), "pmm_version: 2.31.0", "pmm_version: 2.41.0\npmm_extra: true", 1))
+		}
+
+		other = append(other, f)
+	}
+
+	other = append(other,
+		BundleFile{Name: "custom.yaml", Mode: 0o644, Type: tar.TypeReg, Content: []byte("---\n...\n")},
+		BundleFile{Name: "roles/custom", Mode: 0o755, Type: tar.TypeDir},
+		BundleFile{Name: "roles/custom/tasks", Mode: 0o755, Type: tar.TypeDir},
+		BundleFile{Name: "roles/custom/tasks/main.yaml", Mode: 0o644, Type: tar.TypeReg, Content: []byte("---\n...\n")},
+	)
+
+	tgz, err := generateBundle(other)
+	if err != nil {
+		t.Fatalf("failed to generateBundle: %v", err)
+	}
+
+	// Mimic a binary by surrounding the bundle with other data
+	binary := append([]byte("ELF\x1f\x8b\x08junk"), tgz...)
+	binary = append(binary, []byte("trailing data")...)
+
+	data, err := findEmbeddedBundle(binary)
+	if err != nil {
+		t.Fatalf("failed to findEmbeddedBundle: %v", err)
+	}
+
+	if other, err = readBundle(data); err != nil {
+		t.Fatalf("failed to readBundle: %v", err)
+	}
+
+	diff, err := diffBundles(files, other)
+	if err != nil {
+		t.Fatalf("failed to diffBundles: %v", err)
+	}
+
+	expected := map[string][]BundleChange{
+		"Playbooks": {{Action: "+", Name: "custom.yaml"}, {Action: "~", Name: "ping.yaml"}, {Action: "-", Name: "tools.yaml"}},
+		"Roles":     {{Action: "+", Name: "custom"}, {Action: "~", Name: "pmm"}},
+		"Defaults":  {{Action: "+", Name: "pmm/pmm_extra", Detail: "true"}, {Action: "~", Name: "pmm/pmm_version", Detail: "2.31.0 -> 2.41.0"}},
+	}
+	got := map[string][]BundleChange{"Playbooks": diff.Playbooks, "Roles": diff.Roles, "Defaults": diff.Defaults}
+
+	for k, v := range expected {
+		if !slices.Equal(got[k], v) {
+			t.Fatalf("%s: expected %v, got %v", k, v, got[k])
+		}
+	}
+
+	if diff, err = diffBundles(files, files); err != nil || diff.Differences != 0 {
+		t.Fatalf("expected no differences, got %v (%v)", diff, err)
+	}
+
+	if _, err := findEmbeddedBundle([]byte("ELF\x1f\x8b\x08junk")); err == nil {
+		t.Fatalf("expected an error when no bundle is present")
+	}
+}
+
+func TestExportBundle(t *testing.T) {
+	files, err := readBundle(bundle)
+	if err != nil {
+		t.Fatalf("failed to readBundle: %v", err)
+	}
+
+	wd, _ := os.Getwd()
+	dir, err := filepath.Rel(wd, filepath.Join(t.TempDir(), "out"))
+	if err != nil {
+		t.Fatalf("unable to use a relative directory: %v", err)
+	}
+
+	// Exporting again replaces the files from the previous export
+	for i := 0; i < 2; i++ {
+		if err := exportBundle(files, "./"+dir); err != nil {
+			t.Fatalf("failed to exportBundle %d: %v", i+1, err)
+		}
+	}
+
+	fi, err := os.Stat(filepath.Join(dir, "ping.yaml"))
+	if err != nil || fi.Mode().Perm() != 0o640 {
+		t.Fatalf("expected ping.yaml to be writable, got %v (%v)", fi, err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "pax_global_header")); !os.IsNotExist(err) {
+		t.Fatalf("expected no pax_global_header, got %v", err)
+	}
+
+	if err := exportBundle([]BundleFile{{Name: "../escape.yaml", Type: tar.TypeReg}}, dir); err == nil {
+		t.Fatalf("expected an error for a path outside of the directory")
+	}
+}
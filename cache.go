@@ -0,0 +1,401 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	dynamicCacheSource = "dynamic-inventory"
+
+	cacheUsage = `ACTION [--source NAME]
+
+Actions:
+  status              List each cache entry with its age, TTL, size and encryption state
+  clear               Remove the cache entries, so that the inventory is requested on the next run
+  refresh             Request the dynamic inventory to warm its cache, other caches are cleared
+`
+)
+
+// EnvCachePaths is a comma-separated set of path names, which can be configured at build time
+var EnvCachePaths string = "/tmp/.gascan/inventory.cache,~/.config/gascan/cache/gas_inventory_dynamic_inventory_plugin*"
+
+// CacheSource is the set of paths that one of the inventory providers uses for its cache,
+// with each path allowing for glob patterns
+type CacheSource struct {
+	Name  string
+	Paths []string
+	TTL   time.Duration
+}
+
+// CacheEntry is a file, or directory, that matches a path for a cache source
+type CacheEntry struct {
+	Encrypted bool
+	ModTime   time.Time
+	Path      string
+	Size      int64
+	Source    string
+	TTL       time.Duration
+}
+
+// Age of the entry, based on when it was last written
+func (e CacheEntry) Age() time.Duration {
+	return time.Since(e.ModTime).Truncate(time.Second)
+}
+
+// Expired checks the age of the entry against the TTL for its source
+func (e CacheEntry) Expired() bool {
+	return e.TTL > 0 && e.Age() > e.TTL
+}
+
+// inventoryCacheDir is shared by the native dynamic inventory and get_inventory.py
+func inventoryCacheDir() string {
+	if dir := os.Getenv("GASCAN_CACHE_DIR"); dir != "" {
+		return dir
+	}
+
+	return filepath.Join(os.TempDir(), ".gascan")
+}
+
+func inventoryCacheTTL() time.Duration {
+	ttl := defaultInventoryCacheTTL
+	if v, err := strconv.Atoi(os.Getenv("GASCAN_CACHE_TTL")); err == nil {
+		ttl = v
+	}
+
+	return time.Duration(ttl) * time.Second
+}
+
+func expandCachePath(path string) (string, error) {
+	if path == "~" || path == "~/" {
+		return "", fmt.Errorf("EnvCachePaths is using ~/ as a path")
+	}
+
+	return expandHome(path), nil
+}
+
+// cacheSourceName uses the base name of the path, without any glob or extension
+func cacheSourceName(path string) string {
+	name := filepath.Base(strings.TrimRight(path, "*?"))
+
+	return strings.TrimRight(strings.TrimSuffix(name, filepath.Ext(name)), "_-.")
+}
+
+// cacheSources returns the cache used by the dynamic inventory, along with each of the
+// EnvCachePaths, e.g. for the Ansible plugin, which shares the default TTL of 1h
+func cacheSources() ([]CacheSource, error) {
+	dir := inventoryCacheDir()
+	ttl := inventoryCacheTTL()
+
+	sources := []CacheSource{{
+		Name:  dynamicCacheSource,
+		Paths: []string{filepath.Join(dir, inventoryCacheFile), filepath.Join(dir, "inventory.json")},
+		TTL:   ttl,
+	}}
+
+	for _, p := range strings.Split(EnvCachePaths, ",") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+
+		path, err := expandCachePath(p)
+		if err != nil {
+			return nil, err
+		}
+
+		if sameFile(path, sources[0].Paths[0]) {
+			continue
+		}
+
+		name := cacheSourceName(path)
+		found := false
+
+		for i := range sources {
+			if sources[i].Name == name {
+				sources[i].Paths = append(sources[i].Paths, path)
+				found = true
+			}
+		}
+
+		if !found {
+			sources = append(sources, CacheSource{Name: name, Paths: []string{path}, TTL: ttl})
+		}
+	}
+
+	return sources, nil
+}
+
+// selectCacheSources limits the sources to the one named, or returns them all
+func selectCacheSources(name string) ([]CacheSource, error) {
+	sources, err := cacheSources()
+	if err != nil || name == "" {
+		return sources, err
+	}
+
+	names := []string{}
+
+	for _, s := range sources {
+		if s.Name == name {
+			return []CacheSource{s}, nil
+		}
+
+		names = append(names, s.Name)
+	}
+
+	return nil, fmt.Errorf("unknown cache source '%s', expected one of %s", name, strings.Join(names, ", "))
+}
+
+func isEncryptedFile(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	header := make([]byte, len(vaultHeader)+64)
+	n, _ := io.ReadFull(f, header)
+
+	return isVaultEncrypted(header[:n])
+}
+
+// Entries returns every match for the paths, with directories summarised as a single entry
+func (s CacheSource) Entries() ([]CacheEntry, error) {
+	entries := []CacheEntry{}
+
+	for _, p := range s.Paths {
+		matches, err := filepath.Glob(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cache path '%s': %w", p, err)
+		}
+
+		for _, m := range matches {
+			info, err := os.Lstat(m)
+			if err != nil {
+				continue
+			}
+
+			e := CacheEntry{Encrypted: true, ModTime: info.ModTime(), Path: m, Source: s.Name, TTL: s.TTL}
+
+			if !info.IsDir() {
+				e.Encrypted = isEncryptedFile(m)
+				e.Size = info.Size()
+				entries = append(entries, e)
+				continue
+			}
+
+			files := 0
+			filepath.WalkDir(m, func(path string, d fs.DirEntry, err error) error {
+				if err != nil || d.IsDir() {
+					return nil
+				}
+
+				if fi, err := d.Info(); err == nil {
+					files++
+					e.Size += fi.Size()
+					e.Encrypted = e.Encrypted && isEncryptedFile(path)
+
+					if fi.ModTime().After(e.ModTime) {
+						e.ModTime = fi.ModTime()
+					}
+				}
+
+				return nil
+			})
+
+			e.Encrypted = e.Encrypted && files > 0
+			entries = append(entries, e)
+		}
+	}
+
+	return entries, nil
+}
+
+// Clear removes every entry, continuing after any failures
+func (s CacheSource) Clear() ([]string, error) {
+	entries, err := s.Entries()
+	if err != nil {
+		return nil, err
+	}
+
+	removed := []string{}
+	errs := []error{}
+
+	for _, e := range entries {
+		Logger.Debug("removing cache entry %q", e.Path)
+
+		if err := os.RemoveAll(e.Path); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		removed = append(removed, e.Path)
+	}
+
+	return removed, errors.Join(errs...)
+}
+
+func clearInventoryCache() error {
+	sources, err := cacheSources()
+	if err != nil {
+		return err
+	}
+
+	errs := []error{}
+
+	for _, s := range sources {
+		if _, err := s.Clear(); err != nil {
+			Logger.Warning("unable to clear the cache for %s: %v", s.Name, err)
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func formatSize(size int64) string {
+	units := []string{"B", "KiB", "MiB", "GiB"}
+	value := float64(size)
+
+	for _, u := range units[:len(units)-1] {
+		if value < 1024 {
+			if u == "B" {
+				return fmt.Sprintf("%d%s", size, u)
+			}
+
+			return fmt.Sprintf("%.1f%s", value, u)
+		}
+
+		value /= 1024
+	}
+
+	return fmt.Sprintf("%.1f%s", value, units[len(units)-1])
+}
+
+func writeCacheStatus(w io.Writer, entries []CacheEntry) {
+	if len(entries) == 0 {
+		fmt.Fprintln(w, "No cache entries found")
+		return
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SOURCE\tPATH\tAGE\tTTL\tSIZE\tENCRYPTED")
+
+	for _, e := range entries {
+		age := e.Age().String()
+		if e.Expired() {
+			age += " (expired)"
+		}
+
+		encrypted := "no"
+		if e.Encrypted {
+			encrypted = "yes"
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", e.Source, e.Path, age, e.TTL, formatSize(e.Size), encrypted)
+	}
+
+	tw.Flush()
+}
+
+// refreshCache requests the dynamic inventory, while other caches are cleared so that
+// they are populated the next time that Ansible runs
+func refreshCache(sources []CacheSource, configFile string) error {
+	errs := []error{}
+
+	for _, s := range sources {
+		if s.Name != dynamicCacheSource {
+			if _, err := s.Clear(); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", s.Name, err))
+			}
+
+			continue
+		}
+
+		cfg, err := loadInventoryConfig(configFile)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: unable to load the inventory config: %w", s.Name, err))
+			continue
+		}
+
+		if _, err := newDynamicInventory(cfg).Fetch(true); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", s.Name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func cacheCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "Usage of cache:\n  cache %s", cacheUsage)
+		return 1
+	}
+
+	fs := subcommandFlags("cache "+args[0], "[--source NAME]")
+	configFile := fs.String("config-file", inventoryConfigPath(), "Path to the JSON config for the dynamic inventory [GASCAN_INVENTORY_CONFIG_FILE]")
+	source := fs.String("source", "", "Limit the action to a cache source, e.g. "+dynamicCacheSource)
+	rest := parseSubcommandFlags(fs, args[1:])
+
+	if len(rest) > 0 {
+		fs.Usage()
+		return 1
+	}
+
+	sources, err := selectCacheSources(*source)
+	if err != nil {
+		Logger.Error("%v", err)
+		return 1
+	}
+
+	switch args[0] {
+	case "status":
+		entries := []CacheEntry{}
+
+		for _, s := range sources {
+			e, err := s.Entries()
+			if err != nil {
+				Logger.Error("%v", err)
+				return 1
+			}
+
+			entries = append(entries, e...)
+		}
+
+		writeCacheStatus(os.Stdout, entries)
+	case "clear":
+		exitCode := 0
+
+		for _, s := range sources {
+			removed, err := s.Clear()
+			for _, r := range removed {
+				fmt.Printf("Removed %s\n", r)
+			}
+
+			if err != nil {
+				Logger.Error("unable to clear the cache for %s: %v", s.Name, err)
+				exitCode = 1
+			}
+		}
+
+		return exitCode
+	case "refresh":
+		if err := refreshCache(sources, *configFile); err != nil {
+			Logger.Error("unable to refresh the cache: %v", err)
+			return 1
+		}
+	default:
+		Logger.Error("unknown action '%s'", args[0])
+		fmt.Fprintf(os.Stderr, "Usage of cache:\n  cache %s", cacheUsage)
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCacheSources(t *testing.T) {
+	defer func(paths string) { EnvCachePaths = paths }(EnvCachePaths)
+
+	cacheDir := writeInventoryFiles(t, map[string]string{
+		inventoryCacheFile:        vaultHeader + ";1.1;AES256\n3630373835\n",
+		"inventory.json":          "{}",
+		"plugin/gas_plugin_a1b2":  "{}",
+		"plugin/gas_plugin_c3d4":  "{}",
+		"plugin/other_plugin_e5f": "{}",
+	})
+	t.Setenv("GASCAN_CACHE_DIR", cacheDir)
+	t.Setenv("GASCAN_CACHE_TTL", "60")
+
+	EnvCachePaths = filepath.Join(cacheDir, inventoryCacheFile) + "," + filepath.Join(cacheDir, "plugin", "gas_plugin*")
+
+	sources, err := cacheSources()
+	if err != nil || len(sources) != 2 {
+		t.Fatalf("expected 2 sources, got %v (%v)", sources, err)
+	}
+
+	if sources[0].Name != dynamicCacheSource || sources[1].Name != "gas_plugin" || sources[1].TTL != time.Minute {
+		t.Fatalf("unexpected sources: %+v", sources)
+	}
+
+	old := time.Now().Add(-2 * time.Minute)
+	os.Chtimes(filepath.Join(cacheDir, "inventory.json"), old, old)
+
+	entries, err := sources[0].Entries()
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %v (%v)", entries, err)
+	}
+
+	if !entries[0].Encrypted || entries[0].Expired() || entries[1].Encrypted || !entries[1].Expired() {
+		t.Fatalf("unexpected state for the entries: %+v", entries)
+	}
+
+	out := bytes.Buffer{}
+	writeCacheStatus(&out, entries)
+
+	if !strings.Contains(out.String(), "(expired)") || !strings.Contains(out.String(), "1m0s") {
+		t.Fatalf("unexpected status:\n%s", out.String())
+	}
+
+	if _, err := selectCacheSources("missing"); err == nil {
+		t.Fatalf("expected an error for an unknown source")
+	}
+
+	// Every match is removed, rather than the first
+	plugin, _ := selectCacheSources("gas_plugin")
+	if removed, err := plugin[0].Clear(); err != nil || len(removed) != 2 {
+		t.Fatalf("expected 2 entries to be removed, got %v (%v)", removed, err)
+	}
+
+	if _, err := os.Stat(filepath.Join(cacheDir, "plugin", "other_plugin_e5f")); err != nil {
+		t.Fatalf("expected other files to be kept: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(cacheDir, inventoryCacheFile)); err != nil {
+		t.Fatalf("expected other sources to be kept: %v", err)
+	}
+}
+
+func TestClearCache(t *testing.T) {
+	defer func(paths string) { EnvCachePaths = paths }(EnvCachePaths)
+
+	t.Setenv("GASCAN_CACHE_DIR", t.TempDir())
+
+	EnvCachePaths = "~,~/"
+
+	if err := clearInventoryCache(); err == nil {
+		t.Fatalf("expected an error for EnvCachePaths %q", EnvCachePaths)
+	}
+
+	cacheDir := writeInventoryFiles(t, map[string]string{
+		"cache1/inventory.json": "{}",
+		"foobar1":               "{}",
+		"foobar2":               "{}",
+	})
+
+	EnvCachePaths = strings.Join([]string{
+		filepath.Join(cacheDir, "cache1"),
+		filepath.Join(cacheDir, "foobar*"),
+		filepath.Join(cacheDir, "missing"),
+	}, ",")
+
+	if err := clearInventoryCache(); err != nil {
+		t.Fatalf("unexpected error for EnvCachePaths %q: %v", EnvCachePaths, err)
+	}
+
+	if entries, _ := os.ReadDir(cacheDir); len(entries) != 0 {
+		t.Fatalf("expected every entry to be removed, found %d", len(entries))
+	}
+}
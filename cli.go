@@ -1,41 +1,171 @@
 package main
 
 import (
+	"bufio"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
 	"runtime"
+	"slices"
+	"strconv"
 	"strings"
 )
 
 // Flags provides configuration options
 type Flags struct {
-	ClearCache     bool
-	Editor         string
-	EnableGodMode  bool
-	ExtraArguments []string
-	ExtractPath    string
-	ExtraVars      map[string]interface{}
-	GetInventory   bool
-	Inventory      string
-	LimitHosts     string
-	LogLevel       string
-	Mode           uint
-	Monitor        string
-	NoSudoPassword bool
-	Playbook       string
-	SkipTags       string
-	Tags           string
-	Test           bool
+	AnsibleCfg      string
+	AnsibleSettings map[string]string
+	ChangeThreshold int
+	ClearCache      bool
+	Editor          string
+	EnableGodMode   bool
+	ExtraArguments  []string
+	ExtractPath     string
+	ExtraVars       map[string]interface{}
+	GetInventory    bool
+	Inventory       string
+	JumpHost        string
+	LimitHosts      string
+	LogLevel        string
+	Mode            uint
+	Monitor         string
+	NoSudoPassword  bool
+	Overlays        []string
+	Playbook        string
+	SkipTags        string
+	SkipValidation  bool
+	SkipVerify      bool
+	Tags            string
+	Test            bool
+	Yes             bool
+}
+
+// Subcommand provides an action that runs instead of a deployment, e.g. gascan bundle ls
+type Subcommand struct {
+	Description string
+	Run         func(args []string) int
 }
 
 // EntryPointPlaybook defines the playbook that is executed at runtime
 var EntryPointPlaybook = "pmm-full.yaml"
 
+var subcommands = map[string]Subcommand{
+	"bundle":      {Description: "Inspect, export and compare the embedded bundle", Run: bundleCommand},
+	"cache":       {Description: "Show, clear or refresh the inventory caches", Run: cacheCommand},
+	"connect":     {Description: "Connect to the nodes and databases that are registered with PMM Server", Run: connectCommand},
+	"env":         {Description: "Print the shell exports for gascan, or add them to the rc file for the shell", Run: envCommand},
+	"hostkeys":    {Description: "Accept, list or revoke the host keys that are trusted for the managed hosts", Run: hostkeysCommand},
+	"install":     {Description: "Install the Ansible PEX, connection tool and dynamic inventories into ~/bin", Run: installCommand},
+	"inventory":   {Description: "Validate, edit, import, export, explain or create the inventory, or use with --list or --host as a dynamic inventory", Run: inventoryCommand},
+	"preflight":   {Description: "Check that the hosts in the inventory can be reached", Run: preflightCommand},
+	"self-update": {Description: "Update gascan from a local mirror, or roll back to the previous version", Run: selfUpdateCommand},
+	"ssh-config":  {Description: "Generate an SSH client config from the inventory", Run: sshConfigCommand},
+	"status":      {Description: "Show the health and version of PMM Server, along with the agents for each service", Run: statusCommand},
+	"uninstall":   {Description: "Remove the helpers that were installed by gascan", Run: uninstallCommand},
+	"upgrade":     {Description: "Upgrade the installed helpers to the versions in this build", Run: upgradeCommand},
+	"verify":      {Description: "Compare the nodes and services that are registered with PMM Server against the inventory", Run: verifyCommand},
+}
+
+func printUsage() {
+	names := []string{}
+	for name := range subcommands {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage of %s:\n", os.Args[0])
+	flag.PrintDefaults()
+	fmt.Fprintf(out, "\nSubcommands:\n")
+
+	for _, name := range names {
+		fmt.Fprintf(out, "  %s\n        %s\n", name, subcommands[name].Description)
+	}
+}
+
+func subcommandFlags(name string, usage string) *flag.FlagSet {
+	envLogLevel := os.Getenv("GASCAN_FLAG_LOG_LEVEL")
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+
+	defaultLogLevel := "error"
+	if envLogLevel != "" {
+		defaultLogLevel = envLogLevel
+	}
+
+	fs.StringVar(&Config.LogLevel, "log-level", defaultLogLevel, "Set the level of logging verbosity [GASCAN_FLAG_LOG_LEVEL]")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage of %s:\n  %s %s\n", name, name, usage)
+		fs.PrintDefaults()
+	}
+
+	return fs
+}
+
+// parseSubcommandFlags allows flags to follow positional arguments, up until "--"
+func parseSubcommandFlags(fs *flag.FlagSet, args []string) []string {
+	positional := []string{}
+
+	for {
+		if err := fs.Parse(args); err != nil {
+			Logger.Fatal("unable to parse arguments: %v", err)
+		}
+
+		rest := fs.Args()
+		consumed := len(args) - len(rest)
+
+		if len(rest) == 0 || (consumed > 0 && args[consumed-1] == "--") {
+			positional = append(positional, rest...)
+			break
+		}
+
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+
+	setLogLevel(Config.LogLevel)
+
+	return positional
+}
+
+// confirm asks a yes or no question, defaulting to no
+func confirm(question string, answers *bufio.Reader) bool {
+	fmt.Printf("%s [y/N]: ", question)
+
+	answer, _ := answers.ReadString('\n')
+
+	return slices.Contains([]string{"y", "yes"}, strings.ToLower(strings.TrimSpace(answer)))
+}
+
+func runSubcommand(args []string) (int, bool) {
+	if len(args) == 0 {
+		return 0, false
+	}
+
+	cmd, ok := subcommands[args[0]]
+	if !ok {
+		return 0, false
+	}
+
+	return cmd.Run(args[1:]), true
+}
+
+// overlayEntryPoint uses the playbook from GASCAN_FLAG_PLAYBOOK when it is only provided by an
+// overlay, as those from --overlay are unknown until the flags are parsed
+func overlayEntryPoint(playbook string, envPlaybook string) string {
+	if envPlaybook == "" || playbook == envPlaybook || !slices.Contains(overlayPlaybooks(Config.Overlays), envPlaybook) {
+		return playbook
+	}
+
+	EntryPointPlaybook = envPlaybook
+
+	return envPlaybook
+}
+
 func checkPlaybook(play string) bool {
 	exists := false
-	for _, p := range strings.Split(PlaybookList, ",") {
+	for _, p := range availablePlaybooks() {
 		if play == p {
 			exists = true
 			break
@@ -45,6 +175,26 @@ func checkPlaybook(play string) bool {
 	return exists
 }
 
+func setLogLevel(level string) {
+	switch strings.ToLower(level) {
+	case "debug":
+		Logger.Level = debugLevel
+		Logger.Prefix = "DEBUG"
+	case "info":
+		Logger.Level = infoLevel
+		Logger.Prefix = "INFO"
+	case "warning", "warn":
+		Logger.Level = warningLevel
+		Logger.Prefix = "WARNING"
+	case "fatal":
+		Logger.Level = fatalLevel
+		Logger.Prefix = "FATAL"
+	default:
+		Logger.Level = errorLevel
+		Logger.Prefix = "ERROR"
+	}
+}
+
 func printVersion() {
 	fmt.Println("Version:", Version)
 	fmt.Println("Go Version:", runtime.Version())
@@ -52,21 +202,33 @@ func printVersion() {
 	fmt.Println("Ansible Version:", AnsibleVersion)
 	fmt.Println("Bundle Version:", BundleVersion)
 	fmt.Println("Python Version:", PythonVersion)
+
+	for _, v := range pexVariants() {
+		fmt.Printf("Embedded PEX: python%s/%s\n", v.Python, v.Arch)
+	}
 }
 
 func flags() {
 	Config.Mode = 0
 
+	envAnsibleCfg := os.Getenv("GASCAN_FLAG_ANSIBLE_CFG")
 	envInventory := os.Getenv("ANSIBLE_INVENTORY")
+	envJumpHost := os.Getenv("GASCAN_FLAG_JUMP_HOST")
 	envBecomePass := os.Getenv("ANSIBLE_BECOME_PASS")
 	envBecomePassFile := os.Getenv("ANSIBLE_BECOME_PASSWORD_FILE")
+	envChangeThreshold := os.Getenv("GASCAN_FLAG_CHANGE_THRESHOLD")
 
 	envEditor := os.Getenv("EDITOR")
+	envVisual := os.Getenv("VISUAL")
 	envLogLevel := os.Getenv("GASCAN_FLAG_LOG_LEVEL")
+	envOverlay := os.Getenv("GASCAN_FLAG_OVERLAY")
 	envPasswordlessSudo := os.Getenv("GASCAN_FLAG_PASSWORDLESS_SUDO")
 	envPlaybook := os.Getenv("GASCAN_FLAG_PLAYBOOK")
 	envSkipTags := os.Getenv("GASCAN_FLAG_SKIP_TAGS")
+	envSkipValidation := os.Getenv("GASCAN_FLAG_SKIP_VALIDATION")
+	envSkipVerify := os.Getenv("GASCAN_FLAG_SKIP_VERIFY")
 	envTags := os.Getenv("GASCAN_FLAG_TAGS")
+	envYes := os.Getenv("GASCAN_FLAG_YES")
 
 	// Set default values for flags using optional environment settings
 	needsBecomePass := true
@@ -75,7 +237,9 @@ func flags() {
 	}
 
 	defaultEditor := "vi"
-	if envEditor != "" {
+	if envVisual != "" {
+		defaultEditor = envVisual
+	} else if envEditor != "" {
 		defaultEditor = envEditor
 	}
 
@@ -84,6 +248,25 @@ func flags() {
 		defaultLogLevel = envLogLevel
 	}
 
+	defaultAnsibleCfg := "skip"
+	if envAnsibleCfg != "" {
+		defaultAnsibleCfg = envAnsibleCfg
+	} else if ExtractAnsibleConfig {
+		defaultAnsibleCfg = "merge"
+	}
+
+	changeThreshold := defaultChangeThreshold
+	if v, err := strconv.Atoi(envChangeThreshold); err == nil {
+		changeThreshold = v
+	}
+
+	flag.Usage = printUsage
+
+	Config.Overlays = []string{}
+	if envOverlay != "" {
+		Config.Overlays = strings.Split(envOverlay, ",")
+	}
+
 	if PlaybookList == envPlaybook || strings.HasPrefix(PlaybookList, envPlaybook+",") || strings.Contains(PlaybookList, ","+envPlaybook+",") || strings.HasSuffix(PlaybookList, ","+envPlaybook) {
 		EntryPointPlaybook = envPlaybook
 	}
@@ -99,11 +282,18 @@ func flags() {
 
 	flag.BoolVar(&Config.ClearCache, "refresh", false, "Clear inventory caches to allow for a refresh")
 	flag.BoolVar(&Config.GetInventory, "get-inventory", false, "Request the Ansible inventory")
+	flag.BoolVar(&Config.SkipValidation, "skip-validation", optInDefaultOff[envSkipValidation], "Skip validating the inventory before running playbooks [GASCAN_FLAG_SKIP_VALIDATION]")
+	flag.BoolVar(&Config.SkipVerify, "skip-verify", optInDefaultOff[envSkipVerify], "Skip comparing PMM Server with the inventory after deploying the clients [GASCAN_FLAG_SKIP_VERIFY]")
 	flag.BoolVar(&Config.NoSudoPassword, "passwordless-sudo", !needsBecomePass, "The use of sudo does not require a password [GASCAN_FLAG_PASSWORDLESS_SUDO]")
+	flag.BoolVar(&Config.Yes, "yes", optInDefaultOff[envYes], "Deploy without asking for confirmation when the inventory has changed [GASCAN_FLAG_YES]")
 
-	flag.StringVar(&Config.Editor, "editor", defaultEditor, "Path to preferred editor [EDITOR]")
+	flag.IntVar(&Config.ChangeThreshold, "change-threshold", changeThreshold, "Ask for confirmation when more than this percentage of hosts have changed since the last deploy [GASCAN_FLAG_CHANGE_THRESHOLD]")
+
+	flag.StringVar(&Config.AnsibleCfg, "ansible-cfg", defaultAnsibleCfg, "Update ~/.ansible.cfg with --extract-bundle, using one of: "+strings.Join(ansibleCfgModes, ", ")+" [GASCAN_FLAG_ANSIBLE_CFG]")
+	flag.StringVar(&Config.Editor, "editor", defaultEditor, "Preferred editor, including any arguments [VISUAL, EDITOR]")
 	flag.StringVar(&Config.ExtractPath, "extract-path", os.TempDir(), "Extract the bundle to this path, use with --extract-bundle, when TMPDIR cannot execute, etc")
 	flag.StringVar(&Config.Inventory, "inventory", envInventory, "Set a custom inventory [ANSIBLE_INVENTORY]. A default inventory is used when empty, which can be disabled [GASCAN_DEFAULT_INVENTORY]")
+	flag.StringVar(&Config.JumpHost, "jump-host", envJumpHost, "Reach the managed hosts via this jump host, i.e. [user@]host[:port], unless "+jumpHostVar+" is set for the host or its group [GASCAN_FLAG_JUMP_HOST]")
 	flag.StringVar(&Config.LimitHosts, "limit", "", "Limit execution to the specified hosts")
 	flag.StringVar(&Config.LogLevel, "log-level", defaultLogLevel, "Set the level of logging verbosity [GASCAN_FLAG_LOG_LEVEL]")
 	flag.StringVar(&Config.Monitor, "monitor", "monitor", "Monitor alias")
@@ -113,6 +303,42 @@ func flags() {
 
 	Config.ExtraVars = make(map[string]interface{})
 
+	// Tuning for Ansible, which is validated along with default.cfg before Ansible starts
+	Config.AnsibleSettings = map[string]string{}
+	for _, s := range []struct {
+		Bool  bool
+		Name  string
+		Usage string
+	}{
+		{false, "forks", "Number of parallel processes for Ansible"},
+		{true, "host-key-checking", "Check the host keys of managed hosts against ~/.config/gascan/known_hosts, use --host-key-checking=false to disable"},
+		{false, "log-path", "Log the output from Ansible to this file, or a file for each run when this is a directory"},
+		{true, "pipelining", "Reduce the number of SSH operations, use --pipelining=false to disable"},
+		{false, "ssh-args", "Arguments for ssh, replacing the defaults from Ansible, e.g. '-o ControlMaster=auto'"},
+		{false, "timeout", "Timeout in seconds for connections"},
+	} {
+		env := "GASCAN_FLAG_" + strings.ToUpper(strings.ReplaceAll(s.Name, "-", "_"))
+		if v := os.Getenv(env); v != "" {
+			Config.AnsibleSettings[s.Name] = v
+		}
+
+		set := func(v string) error {
+			Config.AnsibleSettings[s.Name] = v
+			return nil
+		}
+
+		if s.Bool {
+			flag.BoolFunc(s.Name, s.Usage+" ["+env+"]", set)
+		} else {
+			flag.Func(s.Name, s.Usage+" ["+env+"]", set)
+		}
+	}
+
+	flag.Func("overlay", "Overlay a directory on top of the bundle, can be repeated [GASCAN_FLAG_OVERLAY]", func(s string) error {
+		Config.Overlays = append(Config.Overlays, s)
+		return nil
+	})
+
 	flag.Func("override", "Overrides to pass to Ansible as --extra-vars", func(s string) error {
 		vals := strings.SplitN(s, "=", 2)
 
@@ -138,33 +364,43 @@ func flags() {
 
 	Config.ExtraArguments = flag.Args()
 
-	switch strings.ToLower(Config.LogLevel) {
-	case "debug":
-		Logger.Level = debugLevel
-		Logger.Prefix = "DEBUG"
-	case "info":
-		Logger.Level = infoLevel
-		Logger.Prefix = "INFO"
-	case "warning", "warn":
-		Logger.Level = warningLevel
-		Logger.Prefix = "WARNING"
-	case "fatal":
-		Logger.Level = fatalLevel
-		Logger.Prefix = "FATAL"
-	default:
-		Logger.Level = errorLevel
-		Logger.Prefix = "ERROR"
-	}
+	setLogLevel(Config.LogLevel)
 
 	Logger.Debug("Passing %v as --extra-vars", Config.ExtraVars)
 
+	for i, o := range Config.Overlays {
+		abs, err := checkOverlay(o)
+		if err != nil {
+			Logger.Fatal("unable to use overlay '%s': %v", o, err)
+		}
+
+		Config.Overlays[i] = abs
+	}
+
+	playbookFlag := false
+	flag.Visit(func(f *flag.Flag) { playbookFlag = playbookFlag || f.Name == "playbook" })
+
+	if !playbookFlag {
+		Config.Playbook = overlayEntryPoint(Config.Playbook, envPlaybook)
+	}
+
+	if !slices.Contains(ansibleCfgModes, Config.AnsibleCfg) {
+		Logger.Fatal("unsupported value '%s' for --ansible-cfg, expected one of: %s", Config.AnsibleCfg, strings.Join(ansibleCfgModes, ", "))
+	}
+
+	if Config.JumpHost != "" {
+		if _, err := parseJumpHost(Config.JumpHost); err != nil {
+			Logger.Fatal("unsupported value for --jump-host: %v", err)
+		}
+	}
+
 	if *versionFlag {
 		printVersion()
 		os.Exit(0)
 	}
 
 	if *listPlaysFlag {
-		fmt.Println(strings.ReplaceAll(PlaybookList, ",", "\n"))
+		fmt.Println(strings.Join(availablePlaybooks(), "\n"))
 		os.Exit(0)
 	}
 
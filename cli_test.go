@@ -1,17 +1,26 @@
 package main
 
 import (
+	"fmt"
 	"os"
+	"slices"
 	"testing"
 )
 
 var envOverrideVars = map[string]string{
 	"EDITOR":                        "nano",
+	"GASCAN_FLAG_ANSIBLE_CFG":       "print",
+	"GASCAN_FLAG_CHANGE_THRESHOLD":  "50",
+	"GASCAN_FLAG_FORKS":             "20",
+	"GASCAN_FLAG_JUMP_HOST":         "ops@bastion:2200",
 	"GASCAN_FLAG_LOG_LEVEL":         "debug",
 	"GASCAN_FLAG_PASSWORDLESS_SUDO": "1",
 	"GASCAN_FLAG_PLAYBOOK":          "ping.yaml",
 	"GASCAN_FLAG_SKIP_TAGS":         "sudo",
+	"GASCAN_FLAG_SKIP_VALIDATION":   "1",
+	"GASCAN_FLAG_SKIP_VERIFY":       "1",
 	"GASCAN_FLAG_TAGS":              "sudo",
+	"GASCAN_FLAG_YES":               "1",
 }
 
 func TestConfiguration(t *testing.T) {
@@ -33,6 +42,18 @@ func TestConfiguration(t *testing.T) {
 		case "EDITOR":
 			data["cfg"] = Config.Editor
 			data["exp"] = v
+		case "GASCAN_FLAG_ANSIBLE_CFG":
+			data["cfg"] = Config.AnsibleCfg
+			data["exp"] = v
+		case "GASCAN_FLAG_CHANGE_THRESHOLD":
+			data["cfg"] = fmt.Sprint(Config.ChangeThreshold)
+			data["exp"] = v
+		case "GASCAN_FLAG_FORKS":
+			data["cfg"] = Config.AnsibleSettings["forks"]
+			data["exp"] = v
+		case "GASCAN_FLAG_JUMP_HOST":
+			data["cfg"] = Config.JumpHost
+			data["exp"] = v
 		case "GASCAN_FLAG_LOG_LEVEL":
 			data["cfg"] = Config.LogLevel
 			data["exp"] = v
@@ -45,9 +66,18 @@ func TestConfiguration(t *testing.T) {
 		case "GASCAN_FLAG_SKIP_TAGS":
 			data["cfg"] = Config.SkipTags
 			data["exp"] = v
+		case "GASCAN_FLAG_SKIP_VALIDATION":
+			data["cfg"] = Config.SkipValidation
+			data["exp"] = optInDefaultOff[v]
+		case "GASCAN_FLAG_SKIP_VERIFY":
+			data["cfg"] = Config.SkipVerify
+			data["exp"] = optInDefaultOff[v]
 		case "GASCAN_FLAG_TAGS":
 			data["cfg"] = Config.Tags
 			data["exp"] = v
+		case "GASCAN_FLAG_YES":
+			data["cfg"] = Config.Yes
+			data["exp"] = optInDefaultOff[v]
 		}
 
 		if data["cfg"] != data["exp"] {
@@ -55,3 +85,35 @@ func TestConfiguration(t *testing.T) {
 		}
 	}
 }
+
+func TestParseSubcommandFlags(t *testing.T) {
+	fs := subcommandFlags("test", "")
+	group := fs.String("group", "", "")
+
+	args := parseSubcommandFlags(fs, []string{"db7", "--group", "mysql", "db8", "--", "--group"})
+	if !slices.Equal(args, []string{"db7", "db8", "--group"}) || *group != "mysql" {
+		t.Fatalf("expected db7, db8 and --group with group=mysql, got %v with group=%s", args, *group)
+	}
+}
+
+func TestOverlayEntryPoint(t *testing.T) {
+	defer func(overlays []string, entryPoint string) {
+		Config.Overlays, EntryPointPlaybook = overlays, entryPoint
+	}(Config.Overlays, EntryPointPlaybook)
+
+	Config.Overlays = []string{createOverlay(t, map[string]string{"custom.yaml": "---\n...\n"})}
+
+	for _, c := range [][3]string{
+		{"pmm-full.yaml", "", "pmm-full.yaml"},
+		{"pmm-full.yaml", "custom.yaml", "custom.yaml"},
+		{"pmm-full.yaml", "missing.yaml", "pmm-full.yaml"},
+	} {
+		if p := overlayEntryPoint(c[0], c[1]); p != c[2] {
+			t.Fatalf("expected %s for GASCAN_FLAG_PLAYBOOK=%s, got %s", c[2], c[1], p)
+		}
+	}
+
+	if !checkPlaybook("custom.yaml") {
+		t.Fatalf("expected the playbook from the overlay to be available")
+	}
+}
@@ -0,0 +1,646 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/syslog"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+const (
+	connectConfigFile    = "connect-py.json"
+	defaultServerAddress = "https://localhost:8443"
+)
+
+// connectClients are the default commands for each type of connection, which can be changed
+// using inventory.default_commands in connect-py.json
+var connectClients = map[string]string{
+	"mongodb":    "mongosh",
+	"mysql":      "mysql",
+	"postgresql": "psql",
+	"ssh":        "ssh",
+}
+
+// connectLinks maps the names of the symlinks to the connection tool to the type of connection
+var connectLinks = map[string]string{
+	"db_connect":  "db",
+	"ssh_connect": "ssh",
+}
+
+// ConnectConfig is the config for the connection tool, as stored in connect-py.json
+type ConnectConfig struct {
+	Inventory     ConnectInventory `json:"inventory"`
+	JumpHost      string           `json:"jump_host"`
+	LogLevel      string           `json:"log_level"`
+	NetrcFile     string           `json:"netrc_file"`
+	ServerAddress string           `json:"server_address"`
+	Standardise   bool             `json:"standardise"`
+	TLSInsecure   bool             `json:"tls_insecure"`
+}
+
+// ConnectInventory provides overrides for the nodes and services that are registered with PMM
+// Server, keyed by node name and then by ssh or the type of service
+type ConnectInventory struct {
+	DefaultCommands map[string]string                            `json:"default_commands"`
+	DefaultPorts    map[string]int                               `json:"default_ports"`
+	Hosts           map[string]map[string]map[string]interface{} `json:"hosts"`
+	Source          string                                       `json:"source"`
+}
+
+// Connection is a node that can be reached using SSH, or a service using its database client
+type Connection struct {
+	Address     string
+	Cluster     string
+	Command     []string
+	Distro      string
+	Environment string
+	JumpHost    string
+	Name        string
+	NodeName    string
+	Port        int
+	ServiceName string
+	Type        string
+}
+
+// NetrcEntry holds the credentials for a machine in a netrc file
+type NetrcEntry struct {
+	Login    string
+	Password string
+}
+
+// readConnectConfig loads connect-py.json, using the defaults when it is missing
+func readConnectConfig(path string) (*ConnectConfig, error) {
+	cfg := &ConnectConfig{ServerAddress: defaultServerAddress}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("unable to parse '%s': %w", path, err)
+	}
+
+	return cfg, nil
+}
+
+// parseNetrc reads the machine entries from a netrc file, with the default entry stored as
+// "default"; macros are skipped
+func parseNetrc(data []byte) map[string]NetrcEntry {
+	entries := map[string]NetrcEntry{}
+	machine := ""
+	entry := NetrcEntry{}
+	inMacro := false
+
+	for _, ln := range strings.Split(string(data), "\n") {
+		if inMacro {
+			inMacro = strings.TrimSpace(ln) != ""
+			continue
+		}
+
+		fields := strings.Fields(ln)
+		if len(fields) > 0 && strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		for i := 0; i < len(fields); i++ {
+			value := ""
+			if i+1 < len(fields) {
+				value = fields[i+1]
+			}
+
+			switch fields[i] {
+			case "machine", "default":
+				if machine != "" {
+					entries[machine] = entry
+				}
+
+				machine, entry = "default", NetrcEntry{}
+				if fields[i] == "machine" {
+					machine = value
+					i++
+				}
+			case "login":
+				entry.Login = value
+				i++
+			case "password":
+				entry.Password = value
+				i++
+			case "account":
+				i++
+			case "macdef":
+				inMacro = true
+				i = len(fields)
+			}
+		}
+	}
+
+	if machine != "" {
+		entries[machine] = entry
+	}
+
+	return entries
+}
+
+// netrcCredentials finds the credentials for the host, as expected by newPMMClient, where
+// a login of api_key means that the password is a token
+func netrcCredentials(path string, host string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	entries := parseNetrc(data)
+
+	entry, ok := entries[host]
+	if !ok {
+		if entry, ok = entries["default"]; !ok {
+			return "", nil
+		}
+	}
+
+	if entry.Login == "" || entry.Login == pmmTokenUsername {
+		return entry.Password, nil
+	}
+
+	return entry.Login + ":" + entry.Password, nil
+}
+
+// newConnectClient creates the client for PMM Server, using the credentials from netrc and
+// falling back to GASCAN_PMM_CREDENTIALS
+func newConnectClient(cfg *ConnectConfig) (*PMMClient, error) {
+	u, err := url.Parse(cfg.ServerAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials, err := netrcCredentials(cfg.NetrcFile, u.Hostname())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("unable to read '%s': %w", cfg.NetrcFile, err)
+	}
+
+	if credentials == "" && u.User == nil && os.Getenv("GASCAN_PMM_CREDENTIALS") == "" {
+		return nil, fmt.Errorf("no credentials for '%s' in '%s'", u.Hostname(), cfg.NetrcFile)
+	}
+
+	return newPMMClient(cfg.ServerAddress, credentials, cfg.TLSInsecure)
+}
+
+// override finds a value from the inventory for a node, e.g. the port for mysql
+func (i *ConnectInventory) override(node string, kind string, name string) string {
+	return stringVar(i.Hosts[node][kind], name)
+}
+
+// command uses the override from connect_cmd, or the default command for the type
+func (i *ConnectInventory) command(node string, kind string) ([]string, error) {
+	cmd := i.override(node, kind, "connect_cmd")
+
+	if c, ok := i.DefaultCommands[kind]; ok && cmd == "" {
+		cmd = c
+	}
+
+	if cmd == "" {
+		cmd = connectClients[kind]
+	}
+
+	return splitShellWords(cmd)
+}
+
+// port uses the override for the node, then the port from PMM, then the default port
+func (i *ConnectInventory) port(node string, kind string, port int) int {
+	if p, err := strconv.Atoi(i.override(node, kind, "port")); err == nil {
+		return p
+	}
+
+	if port > 0 {
+		return port
+	}
+
+	if p, ok := i.DefaultPorts[kind]; ok {
+		return p
+	}
+
+	if kind == "ssh" {
+		return defaultSSHPort
+	}
+
+	return preflightServicePorts[kind]
+}
+
+// jumpHost uses the override for the node, falling back to the jump host for every node
+func (i *ConnectInventory) jumpHost(node string, fallback string) string {
+	if j := i.override(node, "ssh", "jump_host"); j != "" {
+		return j
+	}
+
+	return fallback
+}
+
+// Args are the command and arguments for the client to connect to the address, which is
+// a local port when a tunnel via the jump host is needed
+func (c Connection) Args(address string, port int) []string {
+	args := slices.Clone(c.Command)
+	host, p := "--host="+address, "--port="+strconv.Itoa(port)
+
+	switch c.Type {
+	case "mongodb":
+		args = append(args, "--authenticationDatabase=admin", host, p)
+	case "mysql":
+		args = append(args, host, p, "--skip-auto-rehash", "--comments", "--safe-updates", "--connect-timeout=10", `--prompt=`+cmp.Or(c.NodeName, c.Name)+` \d> `)
+	case "postgresql":
+		args = append(args, host, p)
+	case "ssh":
+		if c.JumpHost != "" {
+			args = append(args, "-J", c.JumpHost)
+		}
+
+		args = append(args, address, "-p", strconv.Itoa(port))
+	}
+
+	return args
+}
+
+// sshConnections lists the generic nodes, apart from PMM Server, where the port and environment
+// are taken from the custom labels
+func sshConnections(nodes []PMMNode, inv *ConnectInventory, jumpHost string) ([]Connection, error) {
+	conns := []Connection{}
+
+	for _, n := range nodes {
+		if n.NodeType != "generic" || n.NodeID == pmmServerNodeID || n.NodeName == pmmServerNodeID {
+			Logger.Debug("ignoring node %s, type %s", n.NodeName, n.NodeType)
+			continue
+		}
+
+		labels := map[string]interface{}{}
+		for k, v := range n.CustomLabels {
+			labels[k] = v
+		}
+
+		if o, ok := inv.Hosts[n.NodeName]["ssh"]["custom_labels"].(map[string]interface{}); ok {
+			for k, v := range o {
+				labels[k] = v
+			}
+		}
+
+		c := Connection{
+			Address:     n.Address,
+			Distro:      n.Distro,
+			Environment: stringVar(labels, "environment"),
+			JumpHost:    inv.jumpHost(n.NodeName, jumpHost),
+			Name:        n.NodeName,
+			NodeName:    n.NodeName,
+			Type:        "ssh",
+		}
+
+		if a := inv.override(n.NodeName, "ssh", "address"); a != "" {
+			c.Address = a
+		}
+
+		if p, err := strconv.Atoi(stringVar(labels, "port")); err == nil {
+			c.Port = p
+		} else {
+			c.Port = inv.port(n.NodeName, "ssh", 0)
+		}
+
+		cmd, err := inv.command(n.NodeName, "ssh")
+		if err != nil {
+			return nil, fmt.Errorf("invalid command for %s: %w", c.Name, err)
+		}
+
+		c.Command = cmd
+		conns = append(conns, c)
+	}
+
+	return conns, nil
+}
+
+// dbConnections lists the services that have a database client, apart from those for PMM
+// Server, naming them after the node when standardise is set
+func dbConnections(nodes []PMMNode, services []PMMService, inv *ConnectInventory, standardise bool, jumpHost string) ([]Connection, error) {
+	conns := []Connection{}
+	byID := map[string]PMMNode{}
+
+	for _, n := range nodes {
+		byID[n.NodeID] = n
+	}
+
+	for _, s := range services {
+		if _, ok := connectClients[s.ServiceType]; !ok || s.ServiceType == "ssh" || strings.HasPrefix(s.ServiceName, pmmServerNodeID) {
+			Logger.Debug("ignoring service %s, type %s", s.ServiceName, s.ServiceType)
+			continue
+		}
+
+		n := byID[s.NodeID]
+		c := Connection{
+			Address:     n.Address,
+			Cluster:     s.Cluster,
+			Distro:      n.Distro,
+			Environment: s.Environment,
+			JumpHost:    inv.jumpHost(n.NodeName, jumpHost),
+			Name:        s.ServiceName,
+			NodeName:    n.NodeName,
+			Port:        inv.port(n.NodeName, s.ServiceType, s.Port),
+			ServiceName: s.ServiceName,
+			Type:        s.ServiceType,
+		}
+
+		if standardise && n.NodeName != "" {
+			c.Name = n.NodeName
+		}
+
+		// The service address is often local to the node, e.g. 127.0.0.1, so the node address
+		// is used as per connect.py, unless the inventory overrides it
+		if a := inv.override(n.NodeName, s.ServiceType, "address"); a != "" {
+			c.Address = a
+		} else if c.Address == "" {
+			c.Address = s.Address
+		}
+
+		if c.Environment == "" {
+			c.Environment = n.CustomLabels["environment"]
+		}
+
+		cmd, err := inv.command(n.NodeName, s.ServiceType)
+		if err != nil {
+			return nil, fmt.Errorf("invalid command for %s: %w", c.Name, err)
+		}
+
+		c.Command = cmd
+		conns = append(conns, c)
+	}
+
+	return conns, nil
+}
+
+// fuzzyScore ranks how well the filter matches a name: a prefix ranks above a substring, which
+// ranks above the characters of the filter appearing in order; zero means no match
+func fuzzyScore(filter string, name string) int {
+	filter, name = strings.ToLower(filter), strings.ToLower(name)
+
+	switch {
+	case strings.HasPrefix(name, filter):
+		return 3
+	case strings.Contains(name, filter):
+		return 2
+	}
+
+	rest := name
+	for _, r := range filter {
+		idx := strings.IndexRune(rest, r)
+		if idx < 0 {
+			return 0
+		}
+
+		rest = rest[idx+len(string(r)):]
+	}
+
+	return 1
+}
+
+// matchConnections returns the connections whose name, node or service matches the filter
+// exactly, or else those that match fuzzily, with the best matches first
+func matchConnections(conns []Connection, filter string) []Connection {
+	if filter == "" {
+		return conns
+	}
+
+	exact := slices.DeleteFunc(slices.Clone(conns), func(c Connection) bool {
+		return !slices.Contains([]string{c.Name, c.NodeName, c.ServiceName}, filter)
+	})
+
+	if len(exact) > 0 {
+		return exact
+	}
+
+	type scored struct {
+		conn  Connection
+		score int
+	}
+
+	found := []scored{}
+
+	for _, c := range conns {
+		best := 0
+		for _, n := range []string{c.Name, c.NodeName, c.ServiceName} {
+			if n != "" {
+				best = max(best, fuzzyScore(filter, n))
+			}
+		}
+
+		if best > 0 {
+			found = append(found, scored{conn: c, score: best})
+		}
+	}
+
+	slices.SortStableFunc(found, func(a, b scored) int { return b.score - a.score })
+
+	matches := make([]Connection, len(found))
+	for i, f := range found {
+		matches[i] = f.conn
+	}
+
+	return matches
+}
+
+func writeConnections(w io.Writer, conns []Connection) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tTYPE\tADDRESS\tPORT\tDISTRO\tENVIRONMENT\tCLUSTER")
+
+	dash := func(v string) string {
+		if v == "" {
+			return "-"
+		}
+
+		return v
+	}
+
+	for _, c := range conns {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%s\t%s\n", c.Name, c.Type, c.Address, c.Port, dash(c.Distro), dash(c.Environment), dash(c.Cluster))
+	}
+
+	tw.Flush()
+}
+
+// auditConnection records who connected to what in syslog
+func auditConnection(message string) {
+	w, err := syslog.New(syslog.LOG_INFO|syslog.LOG_USER, "gascan")
+	if err != nil {
+		Logger.Debug("unable to write to syslog: %v", err)
+		return
+	}
+	defer w.Close()
+
+	w.Info(message)
+}
+
+// runConnection starts the client for the connection, forwarding the extra arguments; the
+// database clients connect via a tunnel when there is a jump host
+func runConnection(c Connection, extra []string) int {
+	user := os.Getenv("SUDO_USER")
+	if user == "" {
+		user = os.Getenv("USER")
+	}
+
+	if user == "" {
+		user = "unknown"
+	}
+
+	address, port := c.Address, c.Port
+
+	if c.JumpHost != "" && c.Type != "ssh" {
+		closeTunnel, local, err := openTunnel(c.JumpHost, c.Address, c.Port)
+		if err != nil {
+			Logger.Error("unable to connect to %s: %v", c.Name, err)
+			return 1
+		}
+		defer closeTunnel()
+
+		address, port = "127.0.0.1", local
+	}
+
+	args := slices.Concat(c.Args(address, port), extra)
+	Logger.Debug("connecting to %s: %s", c.Name, strings.Join(args, " "))
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+
+	auditConnection(fmt.Sprintf("User %s connecting to %s (%s)", user, c.Name, c.Type))
+	defer auditConnection(fmt.Sprintf("User %s disconnected from %s (%s)", user, c.Name, c.Type))
+
+	var exitErr *exec.ExitError
+
+	if err := cmd.Run(); errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	} else if err != nil {
+		Logger.Error("unable to connect to %s: %v", c.Name, err)
+		return 1
+	}
+
+	return 0
+}
+
+func connectCommand(args []string) int {
+	configDir := filepath.Join(os.Getenv("HOME"), ".config", "gascan")
+
+	fs := subcommandFlags("connect", "ssh|db [--list] [--jump-host HOST] [FILTER [-- ARGS...]]")
+	configFile := fs.String("config", filepath.Join(configDir, connectConfigFile), "Load the settings from a config file")
+	jumpHost := fs.String("jump-host", os.Getenv("GASCAN_FLAG_JUMP_HOST"), "Reach the nodes via this jump host, i.e. [user@]host[:port], unless jump_host is set for the node [GASCAN_FLAG_JUMP_HOST]")
+	list := fs.Bool("list", false, "List the connections that match the filter, instead of connecting")
+	netrcFile := fs.String("netrc-file", filepath.Join(os.Getenv("HOME"), ".netrc"), "The netrc file with the credentials for PMM Server")
+	serverAddress := fs.String("server-address", defaultServerAddress, "The address for PMM Server")
+	standardise := fs.Bool("standardise", false, "Name the database connections after the node")
+	tlsInsecure := fs.Bool("tls-insecure", false, "Disable the TLS checks for PMM Server")
+
+	positional := parseSubcommandFlags(fs, args)
+	if len(positional) == 0 || !slices.Contains([]string{"db", "ssh"}, positional[0]) {
+		fs.Usage()
+		return 1
+	}
+
+	cfg, err := readConnectConfig(*configFile)
+	if err != nil {
+		Logger.Error("%v", err)
+		return 1
+	}
+
+	if cfg.NetrcFile == "" {
+		cfg.NetrcFile = *netrcFile
+	}
+
+	if cfg.JumpHost == "" {
+		cfg.JumpHost = *jumpHost
+	}
+
+	// Flags take precedence over the config
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "log-level":
+			// Already applied by parseSubcommandFlags
+			cfg.LogLevel = ""
+		case "jump-host":
+			cfg.JumpHost = *jumpHost
+		case "netrc-file":
+			cfg.NetrcFile = *netrcFile
+		case "server-address":
+			cfg.ServerAddress = *serverAddress
+		case "standardise":
+			cfg.Standardise = *standardise
+		case "tls-insecure":
+			cfg.TLSInsecure = *tlsInsecure
+		}
+	})
+
+	if cfg.LogLevel != "" {
+		setLogLevel(cfg.LogLevel)
+	}
+
+	cfg.NetrcFile = expandHome(cfg.NetrcFile)
+
+	client, err := newConnectClient(cfg)
+	if err != nil {
+		Logger.Error("%v", err)
+		return 1
+	}
+
+	nodes, err := client.ListNodes()
+	if err != nil {
+		Logger.Error("failed to connect to PMM at '%s': %v", cfg.ServerAddress, err)
+		return 1
+	}
+
+	var conns []Connection
+
+	if positional[0] == "ssh" {
+		conns, err = sshConnections(nodes, &cfg.Inventory, cfg.JumpHost)
+	} else {
+		services, serr := client.ListServices()
+		if serr != nil {
+			Logger.Error("failed to connect to PMM at '%s': %v", cfg.ServerAddress, serr)
+			return 1
+		}
+
+		conns, err = dbConnections(nodes, services, &cfg.Inventory, cfg.Standardise, cfg.JumpHost)
+	}
+
+	if err != nil {
+		Logger.Error("%v", err)
+		return 1
+	}
+
+	filter := ""
+	if len(positional) > 1 {
+		filter = positional[1]
+	}
+
+	matches := matchConnections(conns, filter)
+
+	switch {
+	case len(matches) == 0:
+		Logger.Error("no connections match '%s'", filter)
+		return 1
+	case *list || filter == "":
+		writeConnections(os.Stdout, matches)
+		return 0
+	case len(matches) > 1:
+		writeConnections(os.Stdout, matches)
+		Logger.Error("%d connections match '%s', use a more specific filter", len(matches), filter)
+		return 1
+	}
+
+	return runConnection(matches[0], positional[2:])
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseNetrc(t *testing.T) {
+	netrc := `# PMM
+machine monitor login admin password s3cret
+machine token.example.com
+  login api_key
+  password eyJrIjoiYWJjIiwiaWQiOjF9
+macdef init
+  cd /tmp
+
+default login anonymous password guest
+`
+	entries := parseNetrc([]byte(netrc))
+
+	expected := map[string]NetrcEntry{
+		"default":           {Login: "anonymous", Password: "guest"},
+		"monitor":           {Login: "admin", Password: "s3cret"},
+		"token.example.com": {Login: pmmTokenUsername, Password: "eyJrIjoiYWJjIiwiaWQiOjF9"},
+	}
+
+	if len(entries) != len(expected) {
+		t.Fatalf("expected %d entries, got %+v", len(expected), entries)
+	}
+
+	for m, e := range expected {
+		if entries[m] != e {
+			t.Fatalf("expected %+v for %s, got %+v", e, m, entries[m])
+		}
+	}
+
+	file := filepath.Join(t.TempDir(), ".netrc")
+	if err := os.WriteFile(file, []byte(netrc), 0o600); err != nil {
+		t.Fatalf("unable to write '%s': %v", file, err)
+	}
+
+	for host, e := range map[string]string{"monitor": "admin:s3cret", "token.example.com": "eyJrIjoiYWJjIiwiaWQiOjF9", "other": "anonymous:guest"} {
+		if got, err := netrcCredentials(file, host); err != nil || got != e {
+			t.Fatalf("expected %q for %s, got %q (%v)", e, host, got, err)
+		}
+	}
+}
+
+func TestMatchConnections(t *testing.T) {
+	conns := []Connection{
+		{Name: "db1-mysql", NodeName: "db1", ServiceName: "db1-mysql"},
+		{Name: "db10-mysql", NodeName: "db10", ServiceName: "db10-mysql"},
+		{Name: "web-db1-postgresql", NodeName: "web", ServiceName: "web-db1-postgresql"},
+	}
+
+	names := func(cs []Connection) []string {
+		out := []string{}
+		for _, c := range cs {
+			out = append(out, c.Name)
+		}
+
+		return out
+	}
+
+	for filter, expected := range map[string][]string{
+		"":      {"db1-mysql", "db10-mysql", "web-db1-postgresql"},
+		"db1":   {"db1-mysql"},
+		"DB10":  {"db10-mysql"},
+		"sql":   {"db1-mysql", "db10-mysql", "web-db1-postgresql"},
+		"wdb":   {"web-db1-postgresql"},
+		"pgsql": {"web-db1-postgresql"},
+		"db1-":  {"db1-mysql", "web-db1-postgresql", "db10-mysql"},
+		"xyz":   {},
+	} {
+		if got := names(matchConnections(conns, filter)); !slices.Equal(got, expected) {
+			t.Fatalf("expected %v for %q, got %v", expected, filter, got)
+		}
+	}
+}
+
+func TestConnections(t *testing.T) {
+	srv := newPMMDummyServer(t)
+	defer srv.Close()
+
+	netrc := filepath.Join(t.TempDir(), ".netrc")
+	if err := os.WriteFile(netrc, []byte("machine 127.0.0.1 login admin password s3cret\n"), 0o600); err != nil {
+		t.Fatalf("unable to write '%s': %v", netrc, err)
+	}
+
+	t.Setenv("GASCAN_PMM_CREDENTIALS", "")
+
+	cfg := &ConnectConfig{NetrcFile: netrc + ".missing", ServerAddress: srv.URL}
+	if _, err := newConnectClient(cfg); err == nil {
+		t.Fatalf("expected an error without credentials")
+	}
+
+	cfg.NetrcFile = netrc
+	cfg.Inventory = ConnectInventory{
+		DefaultCommands: map[string]string{"mysql": "/opt/mysql/bin/mysql"},
+		Hosts: map[string]map[string]map[string]interface{}{
+			"db1": {
+				"ssh":   {"address": "db1.example.com", "custom_labels": map[string]interface{}{"port": float64(2222)}},
+				"mysql": {"connect_cmd": "sudo -u dba mysql", "port": float64(3307)},
+			},
+		},
+	}
+
+	client, err := newConnectClient(cfg)
+	if err != nil {
+		t.Fatalf("failed to newConnectClient: %v", err)
+	}
+
+	nodes, err := client.ListNodes()
+	if err != nil {
+		t.Fatalf("failed to ListNodes: %v", err)
+	}
+
+	services, _ := client.ListServices()
+
+	ssh, err := sshConnections(nodes, &cfg.Inventory, "")
+	if err != nil || len(ssh) != 1 {
+		t.Fatalf("expected only db1 for ssh, got %+v (%v)", ssh, err)
+	}
+
+	if cmd := strings.Join(ssh[0].Args(ssh[0].Address, ssh[0].Port), " "); cmd != "ssh db1.example.com -p 2222" {
+		t.Fatalf("unexpected command for ssh: %s", cmd)
+	}
+
+	if jumped, _ := sshConnections(nodes, &cfg.Inventory, "ops@bastion:2200"); strings.Join(jumped[0].Args("db1.example.com", 22), " ") != "ssh -J ops@bastion:2200 db1.example.com -p 22" {
+		t.Fatalf("expected the jump host to be used, got %v", jumped[0].Args("db1.example.com", 22))
+	}
+
+	db, err := dbConnections(nodes, services, &cfg.Inventory, true, "")
+	if err != nil || len(db) != 2 {
+		t.Fatalf("expected the mysql services, got %+v (%v)", db, err)
+	}
+
+	if cmd := strings.Join(db[0].Args(db[0].Address, db[0].Port), " "); db[0].Name != "db1" || !strings.HasPrefix(cmd, "sudo -u dba mysql --host=10.0.0.1 --port=3307 ") {
+		t.Fatalf("unexpected connection for db1: %s (%s)", db[0].Name, cmd)
+	}
+
+	if cmd := strings.Join(db[1].Args(db[1].Address, db[1].Port), " "); !strings.HasPrefix(cmd, "/opt/mysql/bin/mysql --host=rds1.abc.eu-west-1.rds.amazonaws.com --port=3306 ") {
+		t.Fatalf("unexpected command for rds1: %s", cmd)
+	}
+
+	// An address for the service in connect-py.json wins over the node address
+	cfg.Inventory.Hosts["db1"]["mysql"]["address"] = "db1-vip.example.com"
+
+	if db, _ = dbConnections(nodes, services, &cfg.Inventory, true, ""); db[0].Address != "db1-vip.example.com" {
+		t.Fatalf("expected the address from the inventory, got %s", db[0].Address)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const diffContext = 3
+
+// diffLine is a line from either side of a diff, marked with ' ', '-' or '+'
+type diffLine struct {
+	Kind byte
+	Text string
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// diffLines compares the lines using the longest common subsequence
+func diffLines(a []string, b []string) []diffLine {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	lines := []diffLine{}
+	i, j := 0, 0
+
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, diffLine{Kind: ' ', Text: a[i]})
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, diffLine{Kind: '-', Text: a[i]})
+			i++
+		default:
+			lines = append(lines, diffLine{Kind: '+', Text: b[j]})
+			j++
+		}
+	}
+
+	return lines
+}
+
+// unifiedDiff returns the changes from a to b in the unified format, or an empty string
+func unifiedDiff(nameA string, nameB string, a string, b string) string {
+	lines := diffLines(splitLines(a), splitLines(b))
+	out := strings.Builder{}
+
+	for start := 0; start < len(lines); {
+		if lines[start].Kind == ' ' {
+			start++
+			continue
+		}
+
+		// Extend the hunk until there is more than twice the context without changes
+		first := max(0, start-diffContext)
+		end := start
+
+		for unchanged := 0; end < len(lines) && unchanged <= 2*diffContext; end++ {
+			if lines[end].Kind == ' ' {
+				unchanged++
+			} else {
+				unchanged = 0
+			}
+		}
+
+		last := end
+		for last > start && lines[last-1].Kind == ' ' {
+			last--
+		}
+
+		last = min(len(lines), last+diffContext)
+
+		lineA, lineB := 1, 1
+		for _, l := range lines[:first] {
+			if l.Kind != '+' {
+				lineA++
+			}
+
+			if l.Kind != '-' {
+				lineB++
+			}
+		}
+
+		countA, countB := 0, 0
+		for _, l := range lines[first:last] {
+			if l.Kind != '+' {
+				countA++
+			}
+
+			if l.Kind != '-' {
+				countB++
+			}
+		}
+
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- %s\n+++ %s\n", nameA, nameB)
+		}
+
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", lineA, countA, lineB, countB)
+
+		for _, l := range lines[first:last] {
+			fmt.Fprintf(&out, "%c%s\n", l.Kind, l.Text)
+		}
+
+		start = last
+	}
+
+	return out.String()
+}
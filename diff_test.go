@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	a := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n14\n15\n"
+	b := "1\n2\nthree\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n14\n15\n16\n"
+
+	expected := `--- a
++++ b
+@@ -1,6 +1,6 @@
+ 1
+ 2
+-3
++three
+ 4
+ 5
+ 6
+@@ -13,3 +13,4 @@
+ 13
+ 14
+ 15
++16
+`
+
+	if diff := unifiedDiff("a", "b", a, b); diff != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, diff)
+	}
+
+	if diff := unifiedDiff("a", "b", a, a); diff != "" {
+		t.Fatalf("expected no differences, got:\n%s", diff)
+	}
+
+	if diff := unifiedDiff("a", "b", "", "1\n"); diff != "--- a\n+++ b\n@@ -1,0 +1,1 @@\n+1\n" {
+		t.Fatalf("expected an addition, got:\n%s", diff)
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	inventoryCacheFile  = "inventory.cache"
+	inventoryScriptName = "gascan-inventory"
+
+	defaultInventoryCacheTTL       = 3600
+	defaultInventoryRequestTimeout = 10
+
+	inventoryUsage = `[--list | --host HOST] | ACTION [ARGS]
+
+Dynamic inventory:
+  --list [--refresh]  Show the inventory, for use as an Ansible inventory script
+  --host HOST         Show the variables for a host
+
+Actions:
+  add-host            Add a host to a group, e.g. add-host --group mysql db7 --var ansible_host=10.0.0.7
+  explain             Show where each variable for a host is set, e.g. explain db1 ansible_host
+  export              Show the resolved inventory as yaml, ini, json, csv, ssh-config or connect-json
+  import              Import hosts from csv:FILE, ssh-config:FILE or pmm:URL, showing the changes first
+  init                Create an inventory from flags, e.g. init --monitor-host 10.0.0.5 --mysql db1,db2
+  move                Move a host to another group, keeping its variables
+  remove-host         Remove a host from a group, or every group
+  set-var             Set variables for a host, or a group
+  unset-var           Remove variables from a host, or a group
+  validate            Check the inventory against the groups and variables used by the playbooks
+`
+)
+
+// DynamicInventory requests the inventory from the endpoint in the inventory config
+type DynamicInventory struct {
+	CacheDir string
+	CacheTTL time.Duration
+	Client   *http.Client
+	Config   SampleInventoryConfig
+}
+
+func inventoryConfigPath() string {
+	if p := os.Getenv("GASCAN_INVENTORY_CONFIG_FILE"); p != "" {
+		return p
+	}
+
+	return filepath.Join(os.Getenv("HOME"), ".config", "gascan", "inventory-config.json")
+}
+
+func loadInventoryConfig(path string) (SampleInventoryConfig, error) {
+	cfg := SampleInventoryConfig{
+		Headers:          map[string]string{"Content-type": "application/json"},
+		RequestTimeout:   defaultInventoryRequestTimeout,
+		RetryAttempts:    3,
+		RetryWaitSeconds: 10,
+		URI:              APIEndpoint,
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("the config file '%s' does not appear to be valid JSON: %w", path, err)
+	}
+
+	if cfg.KeyFile == "" {
+		return cfg, fmt.Errorf("key_file should be set to the path for the key in '%s'", path)
+	}
+
+	return cfg, nil
+}
+
+func newDynamicInventory(cfg SampleInventoryConfig) *DynamicInventory {
+	timeout := cfg.RequestTimeout
+	if timeout == 0 {
+		timeout = defaultInventoryRequestTimeout
+	}
+
+	return &DynamicInventory{
+		CacheDir: inventoryCacheDir(),
+		CacheTTL: inventoryCacheTTL(),
+		Client:   &http.Client{Timeout: time.Duration(timeout) * time.Second},
+		Config:   cfg,
+	}
+}
+
+func (d *DynamicInventory) cachePath() string {
+	return filepath.Join(d.CacheDir, inventoryCacheFile)
+}
+
+func (d *DynamicInventory) readCache(allowExpired bool) ([]byte, error) {
+	info, err := os.Stat(d.cachePath())
+	if err != nil {
+		return nil, err
+	}
+
+	if !allowExpired && time.Since(info.ModTime()) > d.CacheTTL {
+		return nil, fmt.Errorf("cache ttl (%v) exceeded for '%s'", d.CacheTTL, d.cachePath())
+	}
+
+	return os.ReadFile(d.cachePath())
+}
+
+func (d *DynamicInventory) writeCache(data []byte, password []byte) error {
+	if !isVaultEncrypted(data) {
+		encrypted, err := vaultEncrypt(data, password)
+		if err != nil {
+			return err
+		}
+
+		data = encrypted
+	}
+
+	if err := os.MkdirAll(d.CacheDir, 0o700); err != nil {
+		return err
+	}
+
+	return writeFileAtomic(d.cachePath(), data, 0o600)
+}
+
+func (d *DynamicInventory) request(key []byte) ([]byte, error) {
+	var lastErr error
+
+	payload, err := json.Marshal(map[string]string{"key": string(key)})
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := uint(0); attempt < max(d.Config.RetryAttempts, 1); attempt++ {
+		if attempt > 0 {
+			Logger.Debug("attempts = %d", attempt)
+			time.Sleep(time.Duration(d.Config.RetryWaitSeconds) * time.Second)
+		}
+
+		req, err := http.NewRequest(http.MethodPost, d.Config.URI, bytes.NewBuffer(payload))
+		if err != nil {
+			return nil, err
+		}
+
+		for header, value := range d.Config.Headers {
+			req.Header.Set(header, value)
+		}
+
+		resp, err := d.Client.Do(req)
+		if err != nil {
+			lastErr = err
+			Logger.Warning("failed attempt = %d: %v", attempt, err)
+			continue
+		}
+
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if err == nil && resp.StatusCode == http.StatusOK {
+			return data, nil
+		}
+
+		lastErr = fmt.Errorf("failed to request inventory: %s", resp.Status)
+		Logger.Warning("failed attempt = %d: %v", attempt, lastErr)
+	}
+
+	return nil, lastErr
+}
+
+// Fetch the inventory, using the cache unless it has expired or refresh is requested
+func (d *DynamicInventory) Fetch(refresh bool) ([]byte, error) {
+	key, err := os.ReadFile(d.Config.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find, or read the key '%s': %w", d.Config.KeyFile, err)
+	}
+
+	password := bytes.TrimSpace(key)
+
+	data, err := d.readCache(false)
+	if refresh || err != nil {
+		Logger.Debug("requesting inventory from '%s'", d.Config.URI)
+
+		if data, err = d.request(key); err == nil {
+			if err := d.writeCache(data, password); err != nil {
+				Logger.Warning("failed to write the cache '%s': %v", d.cachePath(), err)
+			}
+		} else if data, err = d.readCache(true); err == nil {
+			Logger.Warning("using an expired cache '%s' as the inventory request failed", d.cachePath())
+		} else {
+			return nil, fmt.Errorf("unable to request the inventory and no cache is available")
+		}
+	}
+
+	if isVaultEncrypted(data) {
+		if data, err = vaultDecrypt(data, password); err != nil {
+			return nil, fmt.Errorf("failed to read the inventory: %w", err)
+		}
+	}
+
+	if !json.Valid(data) {
+		return nil, fmt.Errorf("the inventory does not appear to be valid JSON")
+	}
+
+	return data, nil
+}
+
+func dynamicHostVars(data []byte, host string) ([]byte, error) {
+	var inventory struct {
+		Meta struct {
+			HostVars map[string]json.RawMessage `json:"hostvars"`
+		} `json:"_meta"`
+	}
+
+	if err := json.Unmarshal(data, &inventory); err != nil {
+		return nil, err
+	}
+
+	if vars, ok := inventory.Meta.HostVars[host]; ok {
+		return vars, nil
+	}
+
+	return []byte("{}"), nil
+}
+
+func inventoryScriptCommand(args []string) int {
+	fs := subcommandFlags("inventory", inventoryUsage)
+	configFile := fs.String("config-file", inventoryConfigPath(), "Path to the JSON config file [GASCAN_INVENTORY_CONFIG_FILE]")
+	host := fs.String("host", "", "Show the variables for a host")
+	list := fs.Bool("list", false, "Show the inventory")
+	refresh := fs.Bool("refresh", false, "Ignore the cache and request the inventory")
+	parseSubcommandFlags(fs, args)
+
+	if !*list && *host == "" {
+		fs.Usage()
+		return 1
+	}
+
+	cfg, err := loadInventoryConfig(*configFile)
+	if err != nil {
+		Logger.Error("unable to load the inventory config: %v", err)
+		return 1
+	}
+
+	data, err := newDynamicInventory(cfg).Fetch(*refresh)
+	if err != nil {
+		Logger.Error("%v", err)
+		return 1
+	}
+
+	if *host != "" {
+		if data, err = dynamicHostVars(data, *host); err != nil {
+			Logger.Error("unable to parse the inventory: %v", err)
+			return 1
+		}
+	}
+
+	fmt.Println(string(data))
+
+	return 0
+}
+
+func inventoryCommand(args []string) int {
+	if len(args) == 0 || args[0][0] == '-' {
+		return inventoryScriptCommand(args)
+	}
+
+	switch args[0] {
+	case "add-host", "move", "remove-host", "set-var", "unset-var":
+		return inventoryEditCommand(args[0], args[1:])
+	case "explain":
+		return inventoryExplainCommand(args[1:])
+	case "export":
+		return inventoryExportCommand(args[1:])
+	case "import":
+		return inventoryImportCommand(args[1:])
+	case "init":
+		return inventoryInitCommand(args[1:])
+	case "validate":
+		return inventoryValidateCommand(args[1:])
+	default:
+		Logger.Error("unknown action '%s'", args[0])
+		fmt.Fprintf(os.Stderr, "Usage of inventory:\n  inventory %s", inventoryUsage)
+		return 1
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const dynamicInventoryDummy = `{"all": {"children": ["mysql"]}, "mysql": {"hosts": ["db1"]}, "_meta": {"hostvars": {"db1": {"ansible_host": "10.0.0.1"}}}}`
+
+func TestDynamicInventory(t *testing.T) {
+	requests := 0
+	workDir, err := os.MkdirTemp(os.TempDir(), "dynamic")
+	if err != nil {
+		t.Fatalf("unable to create directory: %v", err)
+	}
+	defer os.RemoveAll(workDir)
+
+	keyFile := filepath.Join(workDir, ".vault-key")
+	if err := os.WriteFile(keyFile, []byte("s3cret\n"), 0o400); err != nil {
+		t.Fatalf("unable to write the key: %v", err)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+
+		body, _ := io.ReadAll(r.Body)
+		payload := map[string]string{}
+
+		if r.Method != http.MethodPost || json.Unmarshal(body, &payload) != nil || payload["key"] != "s3cret\n" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if r.Header.Get("Auth-Id") != "identifier" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		// Fail the first request to exercise the retries
+		if requests == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		data, _ := vaultEncrypt([]byte(dynamicInventoryDummy), []byte("s3cret"))
+		w.Write(data)
+	}))
+
+	cfgFile := filepath.Join(workDir, "inventory-config.json")
+	cfg := SampleInventoryConfig{
+		Headers:          map[string]string{"Content-type": "application/json", "Auth-Id": "identifier"},
+		KeyFile:          keyFile,
+		RetryAttempts:    2,
+		RetryWaitSeconds: 0,
+		URI:              srv.URL,
+	}
+	data, _ := json.Marshal(cfg)
+
+	if err := os.WriteFile(cfgFile, data, 0o600); err != nil {
+		t.Fatalf("unable to write the config: %v", err)
+	}
+
+	if cfg, err = loadInventoryConfig(cfgFile); err != nil {
+		t.Fatalf("failed to loadInventoryConfig: %v", err)
+	}
+
+	d := newDynamicInventory(cfg)
+	d.CacheDir = filepath.Join(workDir, "cache")
+
+	inventory, err := d.Fetch(false)
+	if err != nil || string(inventory) != dynamicInventoryDummy {
+		t.Fatalf("expected the inventory, got %q (%v)", inventory, err)
+	}
+
+	if requests != 2 {
+		t.Fatalf("expected 2 requests, got %d", requests)
+	}
+
+	if cached, err := os.ReadFile(d.cachePath()); err != nil || !isVaultEncrypted(cached) {
+		t.Fatalf("expected an encrypted cache, got %v", err)
+	}
+
+	if _, err := d.Fetch(false); err != nil || requests != 2 {
+		t.Fatalf("expected the cache to be used, got %d requests (%v)", requests, err)
+	}
+
+	if _, err := d.Fetch(true); err != nil || requests != 3 {
+		t.Fatalf("expected a refresh, got %d requests (%v)", requests, err)
+	}
+
+	// Use the expired cache when the endpoint is unavailable
+	srv.Close()
+	old := time.Now().Add(-2 * d.CacheTTL)
+	os.Chtimes(d.cachePath(), old, old)
+
+	if inventory, err := d.Fetch(false); err != nil || string(inventory) != dynamicInventoryDummy {
+		t.Fatalf("expected the expired cache, got %q (%v)", inventory, err)
+	}
+
+	os.Remove(d.cachePath())
+
+	if _, err := d.Fetch(false); err == nil {
+		t.Fatalf("expected an error without a cache or endpoint")
+	}
+
+	vars, err := dynamicHostVars([]byte(dynamicInventoryDummy), "db1")
+	if err != nil || string(vars) != `{"ansible_host": "10.0.0.1"}` {
+		t.Fatalf("expected hostvars for db1, got %s (%v)", vars, err)
+	}
+
+	if vars, _ := dynamicHostVars([]byte(dynamicInventoryDummy), "db2"); string(vars) != "{}" {
+		t.Fatalf("expected empty hostvars for db2, got %s", vars)
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const (
+	envBlockStart = "# BEGIN gascan environment, managed by gascan env --install"
+	envBlockEnd   = "# END gascan environment"
+)
+
+// envShells are the shells that gascan env supports
+var envShells = []string{"bash", "fish", "zsh"}
+
+// EnvVar is an environment variable for the shell profile
+type EnvVar struct {
+	Name  string
+	Value string
+}
+
+// gascanEnv lists the variables that --extract-bundle asks to be added to the shell profile
+func gascanEnv(configDir string) []EnvVar {
+	return []EnvVar{
+		{Name: "ANSIBLE_VAULT_PASSWORD_FILE", Value: filepath.Join(configDir, ".vault-key")},
+		{Name: "GASCAN_DEFAULT_INVENTORY", Value: "0"},
+		{Name: "GASCAN_INVENTORY_CONFIG_FILE", Value: filepath.Join(configDir, "inventory-config.json")},
+	}
+}
+
+// detectShell uses SHELL, falling back to bash
+func detectShell() string {
+	if sh := filepath.Base(os.Getenv("SHELL")); slices.Contains(envShells, sh) {
+		return sh
+	}
+
+	return "bash"
+}
+
+func shellQuote(shell string, v string) string {
+	if shell == "fish" {
+		return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(v) + "'"
+	}
+
+	return "'" + strings.ReplaceAll(v, "'", `'\''`) + "'"
+}
+
+// shellExports renders the variables for eval in the shell
+func shellExports(shell string, vars []EnvVar) string {
+	out := strings.Builder{}
+
+	for _, v := range vars {
+		if shell == "fish" {
+			fmt.Fprintf(&out, "set -gx %s %s;\n", v.Name, shellQuote(shell, v.Value))
+		} else {
+			fmt.Fprintf(&out, "export %s=%s\n", v.Name, shellQuote(shell, v.Value))
+		}
+	}
+
+	return out.String()
+}
+
+// shellRCFile is the file that is read by interactive shells
+func shellRCFile(shell string) string {
+	home := os.Getenv("HOME")
+
+	switch shell {
+	case "fish":
+		return filepath.Join(home, ".config", "fish", "config.fish")
+	case "zsh":
+		if d := os.Getenv("ZDOTDIR"); d != "" {
+			return filepath.Join(d, ".zshrc")
+		}
+
+		return filepath.Join(home, ".zshrc")
+	default:
+		return filepath.Join(home, ".bashrc")
+	}
+}
+
+// removeEnvBlock drops the block from a previous install, along with the blank line before it
+func removeEnvBlock(content string) (string, bool) {
+	lines := strings.Split(content, "\n")
+	kept := []string{}
+	inBlock, found := false, false
+
+	for _, ln := range lines {
+		switch {
+		case ln == envBlockStart:
+			inBlock, found = true, true
+
+			if len(kept) > 0 && kept[len(kept)-1] == "" {
+				kept = kept[:len(kept)-1]
+			}
+		case ln == envBlockEnd && inBlock:
+			inBlock = false
+		case !inBlock:
+			kept = append(kept, ln)
+		}
+	}
+
+	return strings.Join(kept, "\n"), found
+}
+
+// updateRCFile adds, or replaces, the block with the exports, or removes it when exports is
+// empty, returning whether the file changed
+func updateRCFile(path string, exports string) (bool, error) {
+	path, err := resolveSymlink(path)
+	if err != nil {
+		return false, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+
+	mode := os.FileMode(0o644)
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode().Perm()
+	}
+
+	content, _ := removeEnvBlock(string(data))
+
+	if exports != "" {
+		if content != "" && !strings.HasSuffix(content, "\n") {
+			content += "\n"
+		}
+
+		if content != "" {
+			content += "\n"
+		}
+
+		content += envBlockStart + "\n" + exports + envBlockEnd + "\n"
+	}
+
+	if content == string(data) {
+		return false, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return false, err
+	}
+
+	return true, writeFileAtomic(path, []byte(content), mode)
+}
+
+func envCommand(args []string) int {
+	fs := subcommandFlags("env", "[--shell bash|zsh|fish] [--install | --remove] [--rc-file FILE]")
+	install := fs.Bool("install", false, "Add the exports to the rc file for the shell, replacing any from a previous install")
+	rcFile := fs.String("rc-file", "", "The rc file to update, instead of the one for the shell")
+	remove := fs.Bool("remove", false, "Remove the exports from the rc file for the shell")
+	shell := fs.String("shell", detectShell(), "Shell to use, one of: "+strings.Join(envShells, ", ")+" [SHELL]")
+
+	if len(parseSubcommandFlags(fs, args)) > 0 || (*install && *remove) {
+		fs.Usage()
+		return 1
+	}
+
+	if !slices.Contains(envShells, *shell) {
+		Logger.Error("unsupported shell '%s', expected one of: %s", *shell, strings.Join(envShells, ", "))
+		return 1
+	}
+
+	exports := shellExports(*shell, gascanEnv(filepath.Join(os.Getenv("HOME"), ".config", "gascan")))
+
+	if !*install && !*remove {
+		fmt.Print(exports)
+		return 0
+	}
+
+	if *rcFile == "" {
+		*rcFile = shellRCFile(*shell)
+	}
+
+	if *remove {
+		exports = ""
+	}
+
+	changed, err := updateRCFile(*rcFile, exports)
+	if err != nil {
+		Logger.Error("unable to update '%s': %v", *rcFile, err)
+		return 1
+	}
+
+	switch {
+	case !changed:
+		fmt.Printf("'%s' is already up to date\n", *rcFile)
+	case *remove:
+		fmt.Printf("Removed the gascan environment from '%s'\n", *rcFile)
+	default:
+		fmt.Printf("Added the gascan environment to '%s', which applies to new shells\n", *rcFile)
+	}
+
+	return 0
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestShellExports(t *testing.T) {
+	vars := []EnvVar{{Name: "A", Value: "/home/o'brien/.config"}, {Name: "B", Value: `C:\x`}}
+
+	expected := map[string]string{
+		"bash": "export A='/home/o'\\''brien/.config'\nexport B='C:\\x'\n",
+		"fish": "set -gx A '/home/o\\'brien/.config';\nset -gx B 'C:\\\\x';\n",
+	}
+
+	for shell, e := range expected {
+		if got := shellExports(shell, vars); got != e {
+			t.Fatalf("expected %q for %s, got %q", e, shell, got)
+		}
+	}
+
+	t.Setenv("SHELL", "/usr/bin/fish")
+	if sh := detectShell(); sh != "fish" {
+		t.Fatalf("expected fish, got %s", sh)
+	}
+
+	t.Setenv("SHELL", "/bin/tcsh")
+	if sh := detectShell(); sh != "bash" {
+		t.Fatalf("expected bash as the fallback, got %s", sh)
+	}
+}
+
+func TestUpdateRCFile(t *testing.T) {
+	rc := filepath.Join(t.TempDir(), ".bashrc")
+	original := "alias ll='ls -l'\n"
+
+	if err := os.WriteFile(rc, []byte(original), 0o600); err != nil {
+		t.Fatalf("unable to write '%s': %v", rc, err)
+	}
+
+	exports := shellExports("bash", gascanEnv("/home/user/.config/gascan"))
+
+	for i, expected := range []bool{true, false} {
+		if changed, err := updateRCFile(rc, exports); err != nil || changed != expected {
+			t.Fatalf("expected changed to be %v for install %d, got %v (%v)", expected, i+1, changed, err)
+		}
+	}
+
+	data, _ := os.ReadFile(rc)
+	if strings.Count(string(data), envBlockStart) != 1 || !strings.HasPrefix(string(data), original+"\n"+envBlockStart) {
+		t.Fatalf("expected a single block after the original content, got:\n%s", data)
+	}
+
+	if fi, _ := os.Stat(rc); fi.Mode().Perm() != 0o600 {
+		t.Fatalf("expected the mode to be kept, got %v", fi.Mode().Perm())
+	}
+
+	// Changes to the exports replace the block
+	if changed, _ := updateRCFile(rc, shellExports("bash", gascanEnv("/opt/gascan"))); !changed {
+		t.Fatalf("expected the block to be replaced")
+	}
+
+	if data, _ := os.ReadFile(rc); strings.Contains(string(data), "/home/user") || strings.Count(string(data), envBlockStart) != 1 {
+		t.Fatalf("expected the previous exports to be replaced, got:\n%s", data)
+	}
+
+	if changed, err := updateRCFile(rc, ""); err != nil || !changed {
+		t.Fatalf("expected the block to be removed, got %v (%v)", changed, err)
+	}
+
+	if data, _ := os.ReadFile(rc); string(data) != original {
+		t.Fatalf("expected the original content, got:\n%s", data)
+	}
+}
+
+func TestUpdateRCFileSymlink(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "dotfiles", "bashrc")
+	rc := filepath.Join(dir, ".bashrc")
+
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		t.Fatalf("unable to create '%s': %v", filepath.Dir(target), err)
+	}
+
+	if err := os.WriteFile(target, []byte("alias ll='ls -l'\n"), 0o600); err != nil {
+		t.Fatalf("unable to write '%s': %v", target, err)
+	}
+
+	if err := os.Symlink(filepath.Join("dotfiles", "bashrc"), rc); err != nil {
+		t.Fatalf("unable to link '%s': %v", rc, err)
+	}
+
+	if changed, err := updateRCFile(rc, shellExports("bash", gascanEnv("/opt/gascan"))); err != nil || !changed {
+		t.Fatalf("expected the exports to be installed, got %v (%v)", changed, err)
+	}
+
+	if fi, err := os.Lstat(rc); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Fatalf("expected '%s' to remain a symlink, got %v (%v)", rc, fi, err)
+	}
+
+	if data, _ := os.ReadFile(target); !strings.Contains(string(data), envBlockStart) {
+		t.Fatalf("expected the exports in the target, got:\n%s", data)
+	}
+
+	// A dangling link is written through to create the target
+	os.Remove(target)
+
+	if changed, err := updateRCFile(rc, shellExports("bash", gascanEnv("/opt/gascan"))); err != nil || !changed {
+		t.Fatalf("expected the exports to be installed, got %v (%v)", changed, err)
+	}
+
+	if fi, err := os.Lstat(rc); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Fatalf("expected '%s' to remain a symlink, got %v (%v)", rc, fi, err)
+	}
+
+	if _, err := os.Stat(target); err != nil {
+		t.Fatalf("expected the target to be created, got %v", err)
+	}
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	defaultHostKeyTimeout = 5 * time.Second
+	hostkeysUsage         = `ACTION [HOST] [--inventory PATHS]
+
+Actions:
+  accept HOST         Fetch the keys for a host and trust them, replacing any that were trusted before
+  list [HOST]         List the trusted keys, along with the hosts in the inventory that use them
+  revoke HOST         Remove the trusted keys for a host, so that they are accepted again on first use
+`
+	knownHostsFile = "known_hosts"
+)
+
+// sshKeyscanCommand is used to fetch the keys for hosts
+var sshKeyscanCommand = "ssh-keyscan"
+
+// KnownHost is an entry in a known_hosts file
+type KnownHost struct {
+	Key    string
+	Marker string
+	Names  []string
+	Type   string
+}
+
+// knownHostsPath is the file with the keys that gascan trusts, which is kept apart from
+// ~/.ssh/known_hosts
+func knownHostsPath() string {
+	return filepath.Join(os.Getenv("HOME"), ".config", "gascan", knownHostsFile)
+}
+
+// hostKeySSHArgs has ssh trust the keys for new hosts on first use and fail when they change,
+// keeping the names readable so that the keys can be managed with gascan hostkeys
+func hostKeySSHArgs(path string) []string {
+	return []string{
+		"-o", "UserKnownHostsFile=" + path,
+		"-o", "StrictHostKeyChecking=accept-new",
+		"-o", "HashKnownHosts=no",
+	}
+}
+
+// knownHostName is how ssh records the host, i.e. with the port unless it is the default
+func knownHostName(address string, port int) string {
+	if port == defaultSSHPort || port == 0 {
+		return address
+	}
+
+	return fmt.Sprintf("[%s]:%d", address, port)
+}
+
+// parseKnownHost reads an entry, returning false for comments and blank or invalid lines
+func parseKnownHost(line string) (KnownHost, bool) {
+	fields := strings.Fields(line)
+	k := KnownHost{}
+
+	if len(fields) > 0 && strings.HasPrefix(fields[0], "@") {
+		k.Marker, fields = fields[0], fields[1:]
+	}
+
+	if len(fields) < 3 || strings.HasPrefix(fields[0], "#") {
+		return k, false
+	}
+
+	k.Names, k.Type, k.Key = strings.Split(fields[0], ","), fields[1], fields[2]
+
+	return k, true
+}
+
+// String formats the entry as a line for known_hosts
+func (k KnownHost) String() string {
+	return strings.TrimSpace(strings.Join([]string{k.Marker, strings.Join(k.Names, ","), k.Type, k.Key}, " "))
+}
+
+// Fingerprint is the SHA256 fingerprint of the key, as shown by ssh
+func (k KnownHost) Fingerprint() string {
+	blob, err := base64.StdEncoding.DecodeString(k.Key)
+	if err != nil {
+		return "invalid key"
+	}
+
+	sum := sha256.Sum256(blob)
+
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+// Matches checks the names for the entry, including those that were hashed by ssh
+func (k KnownHost) Matches(name string) bool {
+	for _, n := range k.Names {
+		if n == name {
+			return true
+		}
+
+		// Hashed names are |1|salt|hash, where the hash is HMAC-SHA1 of the name
+		parts := strings.Split(n, "|")
+		if len(parts) != 4 || parts[1] != "1" {
+			continue
+		}
+
+		salt, err := base64.StdEncoding.DecodeString(parts[2])
+		if err != nil {
+			continue
+		}
+
+		mac := hmac.New(sha1.New, salt)
+		mac.Write([]byte(name))
+
+		if base64.StdEncoding.EncodeToString(mac.Sum(nil)) == parts[3] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// readKnownHosts returns the lines of the file, which is empty when it is missing
+func readKnownHosts(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return []string{}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return splitLines(string(data)), nil
+}
+
+// removeKnownHost drops the entries for the name, keeping everything else as it was
+func removeKnownHost(lines []string, name string) ([]string, []KnownHost) {
+	kept := []string{}
+	removed := []KnownHost{}
+
+	for _, ln := range lines {
+		if k, ok := parseKnownHost(ln); ok && k.Matches(name) {
+			removed = append(removed, k)
+			continue
+		}
+
+		kept = append(kept, ln)
+	}
+
+	return kept, removed
+}
+
+func writeKnownHosts(path string, lines []string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	content := strings.Join(lines, "\n")
+	if content != "" {
+		content += "\n"
+	}
+
+	return writeFileAtomic(path, []byte(content), 0o600)
+}
+
+// scanHostKeys fetches the keys for a host using ssh-keyscan
+func scanHostKeys(address string, port int, timeout time.Duration) ([]KnownHost, error) {
+	args := []string{"-T", strconv.Itoa(max(1, int(timeout.Seconds()))), "-p", strconv.Itoa(port), address}
+	Logger.Debug("running %s %s", sshKeyscanCommand, strings.Join(args, " "))
+
+	stdout, stderr := bytes.Buffer{}, bytes.Buffer{}
+	cmd := exec.Command(sshKeyscanCommand, args...)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s failed: %w: %s", sshKeyscanCommand, err, strings.TrimSpace(stderr.String()))
+	}
+
+	keys := []KnownHost{}
+	for _, ln := range splitLines(stdout.String()) {
+		if k, ok := parseKnownHost(ln); ok {
+			k.Names = []string{knownHostName(address, port)}
+			keys = append(keys, k)
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no keys were returned for '%s'", knownHostName(address, port))
+	}
+
+	return keys, nil
+}
+
+// hostKeyTarget finds the address and port that ssh uses for a host in the inventory, falling
+// back to HOST[:PORT] for hosts that are not in it
+func hostKeyTarget(inv *Inventory, host string) PreflightTarget {
+	if inv != nil {
+		targets := preflightTargets(inv, os.Getenv("GASCAN_FLAG_JUMP_HOST"))
+		if idx := slices.IndexFunc(targets, func(t PreflightTarget) bool { return t.Host == host }); idx >= 0 {
+			return targets[idx]
+		}
+	}
+
+	t := PreflightTarget{Address: host, Host: host, SSHPort: defaultSSHPort}
+
+	if a, p, err := net.SplitHostPort(host); err == nil {
+		if port, err := strconv.Atoi(p); err == nil {
+			t.Address, t.SSHPort = a, port
+		}
+	}
+
+	return t
+}
+
+// acceptHostKeys trusts the keys for the target, replacing those that were trusted before
+func acceptHostKeys(path string, t PreflightTarget, timeout time.Duration) ([]KnownHost, []KnownHost, error) {
+	if t.JumpHost != "" {
+		return nil, nil, fmt.Errorf("'%s' is reached via '%s', its keys are accepted on the first connection", t.Host, t.JumpHost)
+	}
+
+	keys, err := scanHostKeys(t.Address, t.SSHPort, timeout)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	lines, err := readKnownHosts(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	lines, replaced := removeKnownHost(lines, knownHostName(t.Address, t.SSHPort))
+	for _, k := range keys {
+		lines = append(lines, k.String())
+	}
+
+	return keys, replaced, writeKnownHosts(path, lines)
+}
+
+// writeKnownHostsList shows the trusted keys, along with the hosts from the inventory that use them
+func writeKnownHostsList(w io.Writer, lines []string, targets []PreflightTarget, filter string) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "HOST\tENTRY\tTYPE\tFINGERPRINT")
+
+	for _, ln := range lines {
+		k, ok := parseKnownHost(ln)
+		if !ok {
+			continue
+		}
+
+		hosts := []string{}
+		for _, t := range targets {
+			if k.Matches(knownHostName(t.Address, t.SSHPort)) {
+				hosts = append(hosts, t.Host)
+			}
+		}
+
+		if filter != "" && !slices.Contains(hosts, filter) && !k.Matches(filter) {
+			continue
+		}
+
+		host := strings.Join(hosts, ",")
+		if host == "" {
+			host = "-"
+		}
+
+		entry := strings.Join(k.Names, ",")
+		if strings.HasPrefix(entry, "|1|") {
+			entry = "(hashed)"
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", host, strings.TrimSpace(k.Marker+" "+entry), k.Type, k.Fingerprint())
+	}
+
+	tw.Flush()
+}
+
+func hostkeysCommand(args []string) int {
+	if len(args) == 0 || !slices.Contains([]string{"accept", "list", "revoke"}, args[0]) {
+		if len(args) > 0 {
+			Logger.Error("unknown action '%s'", args[0])
+		}
+
+		fmt.Fprintf(os.Stderr, "Usage of hostkeys:\n  hostkeys %s", hostkeysUsage)
+		return 1
+	}
+
+	fs := subcommandFlags("hostkeys "+args[0], "[HOST] [--inventory PATHS]")
+	file := fs.String("known-hosts", knownHostsPath(), "The known_hosts file that is managed by gascan")
+	inventory := fs.String("inventory", "", "Comma-separated inventory sources, otherwise as per Ansible [ANSIBLE_INVENTORY, ~/.ansible.cfg]")
+	timeout := fs.Duration("timeout", defaultHostKeyTimeout, "Timeout for fetching the keys")
+	positional := parseSubcommandFlags(fs, args[1:])
+
+	if len(positional) > 1 || (args[0] != "list" && len(positional) != 1) {
+		fs.Usage()
+		return 1
+	}
+
+	var inv *Inventory
+	if sources := inventorySources(*inventory, filepath.Join(os.Getenv("HOME"), ".ansible.cfg")); sources != "" {
+		i, err := loadInventory(sources)
+		if err != nil {
+			Logger.Error("unable to load the inventory: %v", err)
+			return 1
+		}
+
+		inv = i
+	}
+
+	lines, err := readKnownHosts(*file)
+	if err != nil {
+		Logger.Error("unable to read '%s': %v", *file, err)
+		return 1
+	}
+
+	if args[0] == "list" {
+		targets := []PreflightTarget{}
+		if inv != nil {
+			targets = preflightTargets(inv, os.Getenv("GASCAN_FLAG_JUMP_HOST"))
+		}
+
+		writeKnownHostsList(os.Stdout, lines, targets, strings.Join(positional, ""))
+		return 0
+	}
+
+	t := hostKeyTarget(inv, positional[0])
+	name := knownHostName(t.Address, t.SSHPort)
+
+	if args[0] == "revoke" {
+		kept, removed := removeKnownHost(lines, name)
+		if len(removed) == 0 {
+			Logger.Error("no keys are trusted for '%s'", name)
+			return 1
+		}
+
+		if err := writeKnownHosts(*file, kept); err != nil {
+			Logger.Error("unable to update '%s': %v", *file, err)
+			return 1
+		}
+
+		fmt.Printf("Revoked %d key(s) for '%s'\n", len(removed), name)
+		return 0
+	}
+
+	keys, replaced, err := acceptHostKeys(*file, t, *timeout)
+	if err != nil {
+		Logger.Error("unable to accept the keys for '%s': %v", t.Host, err)
+		return 1
+	}
+
+	for _, k := range replaced {
+		if !slices.ContainsFunc(keys, func(n KnownHost) bool { return n.Type == k.Type && n.Key == k.Key }) {
+			fmt.Printf("Replaced %s key for '%s': %s\n", k.Type, name, k.Fingerprint())
+		}
+	}
+
+	for _, k := range keys {
+		fmt.Printf("Accepted %s key for '%s': %s\n", k.Type, name, k.Fingerprint())
+	}
+
+	return 0
+}
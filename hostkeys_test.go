@@ -0,0 +1,105 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	hostKeyDummy            = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIPlsVK/yuuzn3t/WSbQ8T2PidLKiyiizBhBtHgOj2SeB"
+	hostKeyDummyFingerprint = "SHA256:zWBOqPDiKg2FUM8Fad6Ck50gEGfJJFg1et0YLi0U4RQ"
+)
+
+func TestKnownHost(t *testing.T) {
+	for _, ln := range []string{"", "# comment", "db1 ssh-ed25519"} {
+		if _, ok := parseKnownHost(ln); ok {
+			t.Fatalf("expected %q to be skipped", ln)
+		}
+	}
+
+	k, ok := parseKnownHost("@revoked db1,[10.0.0.1]:2222 " + hostKeyDummy)
+	if !ok || k.Marker != "@revoked" || len(k.Names) != 2 || k.Type != "ssh-ed25519" {
+		t.Fatalf("unexpected entry: %+v", k)
+	}
+
+	if k.Fingerprint() != hostKeyDummyFingerprint {
+		t.Fatalf("expected %s, got %s", hostKeyDummyFingerprint, k.Fingerprint())
+	}
+
+	if !k.Matches(knownHostName("10.0.0.1", 2222)) || k.Matches(knownHostName("10.0.0.1", 22)) {
+		t.Fatalf("expected only the entry with the port to match")
+	}
+
+	// As written by ssh-keygen -H
+	hashed, _ := parseKnownHost("|1|KG61R2K4b1fyoJf+0l82y8sWSvs=|VK1aIpuFX/IzVLyMO+1qYfjVIJQ= " + hostKeyDummy)
+	if !hashed.Matches("db1") || hashed.Matches("db2") {
+		t.Fatalf("expected the hashed name to match db1 only")
+	}
+
+	if got := hashed.String(); !strings.HasPrefix(got, "|1|") || !strings.HasSuffix(got, hostKeyDummy) {
+		t.Fatalf("expected the entry to be unchanged, got %q", got)
+	}
+}
+
+func TestAcceptHostKeys(t *testing.T) {
+	defer func(cmd string) { sshKeyscanCommand = cmd }(sshKeyscanCommand)
+
+	sshKeyscanCommand = filepath.Join(t.TempDir(), "ssh-keyscan")
+	if err := os.WriteFile(sshKeyscanCommand, []byte("#!/bin/sh\necho '# 10.0.0.1:2222 SSH-2.0-OpenSSH_9.2'\necho '[10.0.0.1]:2222 "+hostKeyDummy+"'\n"), 0o700); err != nil {
+		t.Fatalf("unable to write '%s': %v", sshKeyscanCommand, err)
+	}
+
+	file := filepath.Join(t.TempDir(), "gascan", knownHostsFile)
+	existing := "# Managed by gascan\n[10.0.0.1]:2222 ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABAQ==\n10.0.0.2 " + hostKeyDummy + "\n"
+
+	if err := os.MkdirAll(filepath.Dir(file), 0o700); err != nil {
+		t.Fatalf("unable to create the directory for '%s': %v", file, err)
+	}
+
+	if err := os.WriteFile(file, []byte(existing), 0o600); err != nil {
+		t.Fatalf("unable to write '%s': %v", file, err)
+	}
+
+	dir := writeInventoryFiles(t, map[string]string{"hosts.yaml": inventoryExportDummy})
+
+	inv, err := loadInventory(filepath.Join(dir, "hosts.yaml"))
+	if err != nil {
+		t.Fatalf("failed to loadInventory: %v", err)
+	}
+
+	// db1 is reached via a jump host
+	if _, _, err := acceptHostKeys(file, hostKeyTarget(inv, "db1"), time.Second); err == nil {
+		t.Fatalf("expected an error for a host behind a jump host")
+	}
+
+	target := hostKeyTarget(nil, "10.0.0.1:2222")
+	if target.Address != "10.0.0.1" || target.SSHPort != 2222 {
+		t.Fatalf("unexpected target: %+v", target)
+	}
+
+	keys, replaced, err := acceptHostKeys(file, target, time.Second)
+	if err != nil || len(keys) != 1 || len(replaced) != 1 || replaced[0].Type != "ssh-rsa" {
+		t.Fatalf("expected the rsa key to be replaced, got %+v, %+v (%v)", keys, replaced, err)
+	}
+
+	expected := "# Managed by gascan\n10.0.0.2 " + hostKeyDummy + "\n[10.0.0.1]:2222 " + hostKeyDummy + "\n"
+	if data, _ := os.ReadFile(file); string(data) != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, data)
+	}
+
+	lines, _ := readKnownHosts(file)
+	out := strings.Builder{}
+	writeKnownHostsList(&out, lines, []PreflightTarget{{Address: "10.0.0.2", Host: "db2", SSHPort: 22}}, "db2")
+
+	if !strings.Contains(out.String(), "db2   10.0.0.2  ssh-ed25519  "+hostKeyDummyFingerprint) || strings.Contains(out.String(), "2222") {
+		t.Fatalf("unexpected list:\n%s", out.String())
+	}
+
+	kept, removed := removeKnownHost(lines, "10.0.0.2")
+	if len(removed) != 1 || len(kept) != 2 {
+		t.Fatalf("expected the key for 10.0.0.2 to be revoked, got %v", kept)
+	}
+}
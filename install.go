@@ -0,0 +1,544 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	installManifestName = "install-manifest.json"
+	installPythonVar    = `ANSIBLE_PYTHON=""`
+)
+
+// HelperFile is a file, or symlink, that gascan installs into the bin directory
+type HelperFile struct {
+	Content []byte
+	Link    string
+	Mode    fs.FileMode
+	Name    string
+}
+
+// Digest identifies the content, or the target of a symlink
+func (h HelperFile) Digest() string {
+	if h.Link != "" {
+		return "link:" + h.Link
+	}
+
+	sum := sha256.Sum256(h.Content)
+
+	return hex.EncodeToString(sum[:])
+}
+
+// InstalledFile records a file that was installed, so that changes can be detected
+type InstalledFile struct {
+	Digest  string `json:"digest"`
+	Path    string `json:"path"`
+	Version string `json:"version"`
+}
+
+// InstallManifest lists the files that gascan installed into the bin directory
+type InstallManifest struct {
+	BinDir        string          `json:"bin_dir"`
+	BundleVersion string          `json:"bundle_version"`
+	Files         []InstalledFile `json:"files"`
+	Python        string          `json:"python,omitempty"`
+	Updated       time.Time       `json:"updated"`
+	Version       string          `json:"version"`
+}
+
+// InstallAction is a change to a single file, where "modified" files were changed after
+// they were installed, or were not installed by gascan, and are left alone
+type InstallAction struct {
+	Action   string
+	Current  string
+	File     HelperFile
+	Path     string
+	Recorded string
+}
+
+func installManifestPath() string {
+	return filepath.Join(os.Getenv("HOME"), ".config", "gascan", installManifestName)
+}
+
+func readInstallManifest(path string) (*InstallManifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &InstallManifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("unable to parse the manifest '%s': %w", path, err)
+	}
+
+	return m, nil
+}
+
+func (m *InstallManifest) Write(path string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, append(data, '\n'), 0o600)
+}
+
+// Lookup finds the digest that was recorded for a path
+func (m *InstallManifest) Lookup(path string) string {
+	if m == nil {
+		return ""
+	}
+
+	if idx := slices.IndexFunc(m.Files, func(f InstalledFile) bool { return f.Path == path }); idx >= 0 {
+		return m.Files[idx].Digest
+	}
+
+	return ""
+}
+
+// ansibleHelper is the helper script for the PEX, which runs it with the interpreter that was
+// chosen by a fat build, as the shebang may find another version
+func ansibleHelper(python string) []byte {
+	if python == "" {
+		return binHelper
+	}
+
+	return bytes.Replace(binHelper, []byte(installPythonVar), []byte("ANSIBLE_PYTHON="+joinShellWords([]string{python})), 1)
+}
+
+// helperFiles are the files for the bin directory, i.e. the Ansible PEX and helper script, the
+// connection tool and the dynamic inventories, along with their symlinks
+func helperFiles(binDir string) []HelperFile {
+	files := []HelperFile{
+		{Name: "ansible.pex", Content: pex, Mode: 0o750},
+		{Name: "ansible.sh", Content: ansibleHelper(AnsiblePython), Mode: 0o750},
+	}
+
+	for _, name := range []string{"ansible", "ansible-playbook", "ansible-vault", "ansible-config", "ansible-inventory"} {
+		files = append(files, HelperFile{Name: name, Link: filepath.Join(binDir, "ansible.sh")})
+	}
+
+	if ExtractDynamicInventory {
+		files = append(files, HelperFile{Name: "dynamic-inventory.py", Content: dynamicInventory, Mode: 0o550})
+	}
+
+	// The connection tool and the native dynamic inventory are provided by gascan itself
+	if exe, err := currentExecutable(); err == nil {
+		for _, name := range append(sortedKeys(connectLinks), inventoryScriptName) {
+			files = append(files, HelperFile{Name: name, Link: exe})
+		}
+	}
+
+	return files
+}
+
+// currentDigest identifies what exists at the path, or is empty when it is missing
+func currentDigest(path string) (string, error) {
+	fi, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	if fi.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		if err != nil {
+			return "", err
+		}
+
+		return HelperFile{Link: target}.Digest(), nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	return HelperFile{Content: content}.Digest(), nil
+}
+
+// planInstall compares the files with the manifest and what is in the bin directory
+func planInstall(binDir string, files []HelperFile, m *InstallManifest) ([]InstallAction, error) {
+	actions := []InstallAction{}
+	wanted := []string{}
+
+	for _, f := range files {
+		a := InstallAction{File: f, Path: filepath.Join(binDir, f.Name)}
+		wanted = append(wanted, a.Path)
+
+		var err error
+		if a.Current, err = currentDigest(a.Path); err != nil {
+			return nil, err
+		}
+
+		a.Recorded = m.Lookup(a.Path)
+
+		switch {
+		case a.Current == "":
+			a.Action = "create"
+		case a.Current == f.Digest():
+			a.Action = "keep"
+		case a.Current == a.Recorded:
+			a.Action = "update"
+		default:
+			a.Action = "modified"
+		}
+
+		actions = append(actions, a)
+	}
+
+	if m != nil {
+		for _, f := range m.Files {
+			if slices.Contains(wanted, f.Path) {
+				continue
+			}
+
+			a := InstallAction{Action: "remove", Path: f.Path, Recorded: f.Digest}
+
+			var err error
+			if a.Current, err = currentDigest(f.Path); err != nil {
+				return nil, err
+			}
+
+			switch a.Current {
+			case "":
+				continue
+			case f.Digest:
+			default:
+				a.Action = "modified"
+			}
+
+			actions = append(actions, a)
+		}
+	}
+
+	return actions, nil
+}
+
+// stageHelperFile writes the file, or symlink, alongside the target so that it can be renamed
+func stageHelperFile(a InstallAction) (string, error) {
+	if a.File.Link != "" {
+		tmp := filepath.Join(filepath.Dir(a.Path), fmt.Sprintf(".%s-%d", a.File.Name, time.Now().UnixNano()))
+		return tmp, os.Symlink(a.File.Link, tmp)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(a.Path), "."+a.File.Name+"-*")
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := tmp.Write(a.File.Content); err != nil {
+		tmp.Close()
+		return tmp.Name(), err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return tmp.Name(), err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return tmp.Name(), err
+	}
+
+	return tmp.Name(), os.Chmod(tmp.Name(), a.File.Mode)
+}
+
+// applyInstall stages every file before renaming them into place, restoring the previous files
+// when a rename fails, so that the bin directory is never left with a mix of versions. Modified
+// files are only replaced when forced, keeping a backup of the original.
+func applyInstall(binDir string, actions []InstallAction, force bool, manifestPath string) error {
+	if err := os.MkdirAll(binDir, 0o750); err != nil {
+		return fmt.Errorf("unable to create '%s': %w", binDir, err)
+	}
+
+	staged := map[string]string{}
+	defer func() {
+		for _, tmp := range staged {
+			os.Remove(tmp)
+		}
+	}()
+
+	for _, a := range actions {
+		if !slices.Contains([]string{"create", "update", "modified"}, a.Action) || a.File.Name == "" {
+			continue
+		}
+
+		if a.Action == "modified" && !force {
+			continue
+		}
+
+		tmp, err := stageHelperFile(a)
+		if tmp != "" {
+			staged[a.Path] = tmp
+		}
+
+		if err != nil {
+			return fmt.Errorf("unable to stage '%s': %w", a.Path, err)
+		}
+	}
+
+	backups := map[string]string{}
+	replaced := []string{}
+
+	rollback := func() {
+		for _, p := range replaced {
+			if b, ok := backups[p]; ok {
+				os.Rename(b, p)
+			} else {
+				os.Remove(p)
+			}
+		}
+	}
+
+	for _, a := range actions {
+		tmp, ok := staged[a.Path]
+		if !ok {
+			continue
+		}
+
+		if a.Current != "" {
+			backup := a.Path + updatePreviousName
+			if a.Action == "modified" {
+				backup = a.Path + inventoryBackupSuffix
+			}
+
+			os.Remove(backup)
+
+			if err := os.Rename(a.Path, backup); err != nil {
+				rollback()
+				return fmt.Errorf("unable to keep the previous '%s': %w", a.Path, err)
+			}
+
+			backups[a.Path] = backup
+		}
+
+		if err := os.Rename(tmp, a.Path); err != nil {
+			if b, ok := backups[a.Path]; ok {
+				os.Rename(b, a.Path)
+			}
+
+			rollback()
+
+			return fmt.Errorf("unable to replace '%s': %w", a.Path, err)
+		}
+
+		delete(staged, a.Path)
+		replaced = append(replaced, a.Path)
+	}
+
+	// The previous versions are only needed until every file is in place
+	for _, a := range actions {
+		if b, ok := backups[a.Path]; ok && a.Action != "modified" {
+			os.Remove(b)
+		}
+	}
+
+	m := &InstallManifest{BinDir: binDir, BundleVersion: BundleVersion, Python: AnsiblePython, Updated: time.Now().UTC().Truncate(time.Second), Version: Version}
+
+	for _, a := range actions {
+		switch {
+		case a.Action == "remove":
+			if err := os.Remove(a.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				Logger.Warning("unable to remove '%s': %v", a.Path, err)
+			}
+		case a.File.Name == "":
+			// A file that is no longer installed, which was modified and so is left in place
+		case a.Action == "modified" && !force:
+			Logger.Warning("'%s' was modified and has not been replaced, use --force to replace it", a.Path)
+		default:
+			m.Files = append(m.Files, InstalledFile{Digest: a.File.Digest(), Path: a.Path, Version: Version})
+		}
+	}
+
+	return m.Write(manifestPath)
+}
+
+// uninstallHelpers removes the files in the manifest, leaving any that were modified unless forced
+func uninstallHelpers(m *InstallManifest, force bool, manifestPath string) ([]string, error) {
+	removed := []string{}
+	errs := []error{}
+
+	for _, f := range m.Files {
+		current, err := currentDigest(f.Path)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		if current == "" {
+			continue
+		}
+
+		if current != f.Digest && !force {
+			Logger.Warning("'%s' was modified and has not been removed, use --force to remove it", f.Path)
+			continue
+		}
+
+		if err := os.Remove(f.Path); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		removed = append(removed, f.Path)
+	}
+
+	if len(errs) == 0 {
+		if err := os.Remove(manifestPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+
+	return removed, errors.Join(errs...)
+}
+
+// installHelpers installs, or upgrades, the helpers when extracting the bundle
+func installHelpers(binDir string, manifestPath string) error {
+	m, err := readInstallManifest(manifestPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	actions, err := planInstall(binDir, helperFiles(binDir), m)
+	if err != nil {
+		return err
+	}
+
+	writeInstallPlan(actions)
+
+	return applyInstall(binDir, actions, false, manifestPath)
+}
+
+func writeInstallPlan(actions []InstallAction) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ACTION\tPATH")
+
+	for _, a := range actions {
+		fmt.Fprintf(tw, "%s\t%s\n", a.Action, a.Path)
+	}
+
+	tw.Flush()
+}
+
+func installCommand(args []string) int {
+	return installSubcommand("install", args)
+}
+
+func upgradeCommand(args []string) int {
+	return installSubcommand("upgrade", args)
+}
+
+func uninstallCommand(args []string) int {
+	return installSubcommand("uninstall", args)
+}
+
+// installSubcommand handles install, upgrade and uninstall, which share their flags
+func installSubcommand(name string, args []string) int {
+	fs := subcommandFlags(name, "[--bin-dir DIR] [--dry-run] [--force]")
+	binDir := fs.String("bin-dir", "", "Directory for the helpers, defaults to ~/bin or the directory from the manifest")
+	dryRun := fs.Bool("dry-run", false, "Show the changes without making them")
+	force := fs.Bool("force", false, "Replace, or remove, files that were modified, keeping a backup when replacing")
+
+	if len(parseSubcommandFlags(fs, args)) > 0 {
+		fs.Usage()
+		return 1
+	}
+
+	manifestPath := installManifestPath()
+
+	m, err := readInstallManifest(manifestPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		Logger.Error("%v", err)
+		return 1
+	}
+
+	switch {
+	case name == "install" && m != nil:
+		Logger.Error("the helpers are already installed in '%s', use upgrade instead", m.BinDir)
+		return 1
+	case name != "install" && m == nil:
+		Logger.Error("no manifest found at '%s', use install instead", manifestPath)
+		return 1
+	}
+
+	if *binDir == "" {
+		*binDir = filepath.Join(os.Getenv("HOME"), "bin")
+		if m != nil {
+			*binDir = m.BinDir
+		}
+	}
+
+	if name == "uninstall" {
+		if *dryRun {
+			for _, f := range m.Files {
+				fmt.Println("remove", f.Path)
+			}
+
+			return 0
+		}
+
+		removed, err := uninstallHelpers(m, *force, manifestPath)
+		fmt.Printf("Removed %d file(s) from '%s'\n", len(removed), m.BinDir)
+
+		if err != nil {
+			Logger.Error("unable to remove every file: %v", err)
+			return 1
+		}
+
+		return 0
+	}
+
+	if err := selectAnsiblePex(); err != nil {
+		Logger.Error("unable to select a PEX for Ansible: %v", err)
+		return 1
+	}
+
+	actions, err := planInstall(*binDir, helperFiles(*binDir), m)
+	if err != nil {
+		Logger.Error("unable to compare the helpers: %v", err)
+		return 1
+	}
+
+	if m != nil {
+		fmt.Printf("Version: %s -> %s\n", m.Version, Version)
+	}
+
+	writeInstallPlan(actions)
+
+	if *dryRun {
+		return 0
+	}
+
+	modified := slices.ContainsFunc(actions, func(a InstallAction) bool { return a.Action == "modified" })
+
+	if err := applyInstall(*binDir, actions, *force, manifestPath); err != nil {
+		Logger.Error("unable to %s the helpers: %v", name, err)
+		return 1
+	}
+
+	fmt.Printf("Helpers %s in '%s'\n", strings.TrimSuffix(name, "e")+"ed", *binDir)
+
+	if modified && !*force {
+		return 1
+	}
+
+	return 0
+}
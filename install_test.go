@@ -0,0 +1,128 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInstallHelpers(t *testing.T) {
+	binDir := filepath.Join(t.TempDir(), "bin")
+	manifestPath := filepath.Join(t.TempDir(), installManifestName)
+
+	v1 := []HelperFile{
+		{Name: "tool.sh", Content: []byte("#!/bin/sh\necho v1\n"), Mode: 0o750},
+		{Name: "tool", Link: filepath.Join(binDir, "tool.sh")},
+		{Name: "old.py", Content: []byte("print('v1')\n"), Mode: 0o550},
+	}
+
+	actions, err := planInstall(binDir, v1, nil)
+	if err != nil || len(actions) != 3 || actions[0].Action != "create" {
+		t.Fatalf("expected every file to be created, got %+v (%v)", actions, err)
+	}
+
+	if err := applyInstall(binDir, actions, false, manifestPath); err != nil {
+		t.Fatalf("failed to applyInstall: %v", err)
+	}
+
+	m, err := readInstallManifest(manifestPath)
+	if err != nil || len(m.Files) != 3 {
+		t.Fatalf("expected 3 files in the manifest, got %+v (%v)", m, err)
+	}
+
+	if target, err := os.Readlink(filepath.Join(binDir, "tool")); err != nil || target != filepath.Join(binDir, "tool.sh") {
+		t.Fatalf("expected a symlink to tool.sh, got %s (%v)", target, err)
+	}
+
+	// Files that were not installed by gascan are left alone
+	if err := os.WriteFile(filepath.Join(binDir, "other"), []byte("mine"), 0o600); err != nil {
+		t.Fatalf("unable to write: %v", err)
+	}
+
+	v2 := []HelperFile{
+		{Name: "tool.sh", Content: []byte("#!/bin/sh\necho v2\n"), Mode: 0o750},
+		{Name: "tool", Link: filepath.Join(binDir, "tool.sh")},
+		{Name: "new.py", Content: []byte("print('v2')\n"), Mode: 0o550},
+		{Name: "other", Content: []byte("theirs"), Mode: 0o550},
+	}
+
+	actions, err = planInstall(binDir, v2, m)
+	if err != nil {
+		t.Fatalf("failed to planInstall: %v", err)
+	}
+
+	expected := map[string]string{"tool.sh": "update", "tool": "keep", "new.py": "create", "other": "modified", "old.py": "remove"}
+	for _, a := range actions {
+		if expected[filepath.Base(a.Path)] != a.Action {
+			t.Fatalf("expected %s for '%s', got %s", expected[filepath.Base(a.Path)], a.Path, a.Action)
+		}
+	}
+
+	if err := applyInstall(binDir, actions, false, manifestPath); err != nil {
+		t.Fatalf("failed to applyInstall: %v", err)
+	}
+
+	if c, _ := os.ReadFile(filepath.Join(binDir, "tool.sh")); string(c) != "#!/bin/sh\necho v2\n" {
+		t.Fatalf("expected tool.sh to be upgraded, got %q", c)
+	}
+
+	if c, _ := os.ReadFile(filepath.Join(binDir, "other")); string(c) != "mine" {
+		t.Fatalf("expected the modified file to be kept, got %q", c)
+	}
+
+	for _, name := range []string{"old.py", "tool.sh" + updatePreviousName} {
+		if _, err := os.Lstat(filepath.Join(binDir, name)); err == nil {
+			t.Fatalf("expected '%s' to be removed", name)
+		}
+	}
+
+	m, _ = readInstallManifest(manifestPath)
+
+	removed, err := uninstallHelpers(m, false, manifestPath)
+	if err != nil || len(removed) != 3 {
+		t.Fatalf("expected 3 files to be removed, got %v (%v)", removed, err)
+	}
+
+	if entries, _ := os.ReadDir(binDir); len(entries) != 1 || entries[0].Name() != "other" {
+		t.Fatalf("expected only 'other' to remain, got %v", entries)
+	}
+
+	if _, err := os.Stat(manifestPath); err == nil {
+		t.Fatalf("expected the manifest to be removed")
+	}
+}
+
+func TestAnsibleHelper(t *testing.T) {
+	if string(ansibleHelper("")) != string(binHelper) {
+		t.Fatalf("expected the helper to be unchanged without an interpreter")
+	}
+
+	helper := string(ansibleHelper("/opt/rh/rh-python38/root/usr/bin/python3.8"))
+	if !strings.Contains(helper, "\nANSIBLE_PYTHON=/opt/rh/rh-python38/root/usr/bin/python3.8\n") || strings.Contains(helper, installPythonVar) {
+		t.Fatalf("expected the interpreter in the helper, got:\n%s", helper)
+	}
+
+	defer func(python string) { AnsiblePython = python }(AnsiblePython)
+	AnsiblePython = "/opt/rh/rh-python38/root/usr/bin/python3.8"
+
+	binDir := filepath.Join(t.TempDir(), "bin")
+	manifestPath := filepath.Join(t.TempDir(), installManifestName)
+
+	actions, err := planInstall(binDir, helperFiles(binDir)[:2], nil)
+	if err != nil {
+		t.Fatalf("failed to planInstall: %v", err)
+	}
+
+	if err := applyInstall(binDir, actions, false, manifestPath); err != nil {
+		t.Fatalf("failed to applyInstall: %v", err)
+	}
+
+	if m, err := readInstallManifest(manifestPath); err != nil || m.Python != AnsiblePython {
+		t.Fatalf("expected the interpreter in the manifest, got %+v (%v)", m, err)
+	}
+
+	if c, _ := os.ReadFile(filepath.Join(binDir, "ansible.sh")); !strings.Contains(string(c), "ANSIBLE_PYTHON="+AnsiblePython) {
+		t.Fatalf("expected the interpreter in the installed helper, got:\n%s", c)
+	}
+}
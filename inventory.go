@@ -0,0 +1,1011 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	vaultTag = "!vault"
+
+	allGroup       = "all"
+	ungroupedGroup = "ungrouped"
+)
+
+var (
+	// errInventoryPlugin is returned for the configs of inventory plugins, e.g. amazon.aws.aws_ec2
+	errInventoryPlugin = errors.New("inventory plugins are not supported natively")
+	// errInventoryUnreachable is returned when a dynamic inventory or inventory script fails to
+	// provide the hosts
+	errInventoryUnreachable = errors.New("unable to reach the dynamic inventory")
+	// errVaultPassword is returned for vault-encrypted content without a vault password
+	errVaultPassword = errors.New("a vault password is required, please set ANSIBLE_VAULT_PASSWORD_FILE")
+)
+
+var inventoryIgnoreExtensions = []string{".bak", ".cfg", ".md", ".orig", ".pyc", ".pyo", ".retry", ".rpm", ".rst", ".swp", ".txt", "~"}
+
+// Inventory is the result of loading and merging inventory sources
+type Inventory struct {
+	Groups  map[string]*InventoryGroup
+	Hosts   map[string]*InventoryHost
+	Sources []string
+
+	overrides map[string][]byte
+}
+
+// InventoryGroup is a group along with each definition of its variables
+type InventoryGroup struct {
+	Children []string
+	Hosts    []string
+	Name     string
+	Sources  []InventorySource
+	Vars     []InventoryVar
+}
+
+// InventoryHost is a host along with each definition of its variables
+type InventoryHost struct {
+	Groups  []string
+	Name    string
+	Sources []InventorySource
+	Vars    []InventoryVar
+}
+
+// InventorySource identifies where an inventory item was defined
+type InventorySource struct {
+	File string
+	Line int
+}
+
+// InventoryVar is a single definition of a variable
+type InventoryVar struct {
+	Name   string
+	Source InventorySource
+	Value  interface{}
+}
+
+// VaultValue is an inline value encrypted with Ansible Vault
+type VaultValue struct {
+	Ciphertext string
+}
+
+// HostVarOrigin is a variable definition that applies to a host, either
+// via a group or directly when Group is empty
+type HostVarOrigin struct {
+	Group string
+	Var   InventoryVar
+}
+
+func (s InventorySource) String() string {
+	if s.Line == 0 {
+		return s.File
+	}
+
+	return fmt.Sprintf("%s:%d", s.File, s.Line)
+}
+
+// MarshalJSON uses the same representation as ansible-inventory
+func (v VaultValue) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]string{"__ansible_vault": v.Ciphertext})
+}
+
+// MarshalYAML keeps the value encrypted and tagged
+func (v VaultValue) MarshalYAML() (interface{}, error) {
+	return &yaml.Node{Kind: yaml.ScalarNode, Style: yaml.LiteralStyle, Tag: vaultTag, Value: v.Ciphertext + "\n"}, nil
+}
+
+// Decrypt the value with the vault password
+func (v VaultValue) Decrypt(password []byte) (string, error) {
+	if len(password) == 0 {
+		return "", errVaultPassword
+	}
+
+	b, err := vaultDecrypt([]byte(v.Ciphertext), password)
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+func inventoryVaultPassword() []byte {
+	path := os.Getenv("ANSIBLE_VAULT_PASSWORD_FILE")
+	if path == "" {
+		return nil
+	}
+
+	password, err := readVaultPassword(path)
+	if err != nil {
+		Logger.Warning("unable to read the vault password file '%s': %v", path, err)
+		return nil
+	}
+
+	return password
+}
+
+func newInventory() *Inventory {
+	inv := &Inventory{Groups: map[string]*InventoryGroup{}, Hosts: map[string]*InventoryHost{}}
+	inv.group(allGroup, InventorySource{})
+	inv.group(ungroupedGroup, InventorySource{})
+	inv.addChild(allGroup, ungroupedGroup)
+
+	return inv
+}
+
+func (inv *Inventory) group(name string, src InventorySource) *InventoryGroup {
+	g, ok := inv.Groups[name]
+	if !ok {
+		g = &InventoryGroup{Name: name}
+		inv.Groups[name] = g
+
+		if name != allGroup && name != ungroupedGroup {
+			inv.addChild(allGroup, name)
+		}
+	}
+
+	if src.File != "" {
+		g.Sources = append(g.Sources, src)
+	}
+
+	return g
+}
+
+func (inv *Inventory) host(name string, src InventorySource) *InventoryHost {
+	h, ok := inv.Hosts[name]
+	if !ok {
+		h = &InventoryHost{Name: name}
+		inv.Hosts[name] = h
+	}
+
+	if src.File != "" {
+		h.Sources = append(h.Sources, src)
+	}
+
+	return h
+}
+
+func (inv *Inventory) addChild(parent string, child string) {
+	g := inv.Groups[parent]
+	if !slices.Contains(g.Children, child) {
+		g.Children = append(g.Children, child)
+	}
+}
+
+func (inv *Inventory) addHost(group string, host string, src InventorySource) *InventoryHost {
+	g := inv.group(group, InventorySource{})
+	h := inv.host(host, src)
+
+	if group != allGroup && !slices.Contains(g.Hosts, host) {
+		g.Hosts = append(g.Hosts, host)
+	}
+
+	if !slices.Contains(h.Groups, group) {
+		h.Groups = append(h.Groups, group)
+	}
+
+	return h
+}
+
+// finalise places hosts without a group in ungrouped, and removes those with a group from
+// ungrouped, e.g. when an INI file lists them before the first section, as per Ansible
+func (inv *Inventory) finalise() {
+	for _, name := range inv.HostNames() {
+		h := inv.Hosts[name]
+		other := slices.ContainsFunc(h.Groups, func(g string) bool { return g != allGroup && g != ungroupedGroup })
+
+		switch {
+		case !other && !slices.Contains(h.Groups, ungroupedGroup):
+			inv.addHost(ungroupedGroup, name, InventorySource{})
+		case other && slices.Contains(h.Groups, ungroupedGroup):
+			g := inv.Groups[ungroupedGroup]
+			g.Hosts = slices.DeleteFunc(g.Hosts, func(n string) bool { return n == name })
+			h.Groups = slices.DeleteFunc(h.Groups, func(n string) bool { return n == ungroupedGroup })
+		}
+	}
+}
+
+// GroupNames returns the sorted names of all groups
+func (inv *Inventory) GroupNames() []string {
+	names := []string{}
+	for name := range inv.Groups {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+
+	return names
+}
+
+// HostNames returns the sorted names of all hosts
+func (inv *Inventory) HostNames() []string {
+	names := []string{}
+	for name := range inv.Hosts {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+
+	return names
+}
+
+func (inv *Inventory) parents(group string) []string {
+	parents := []string{}
+
+	for _, name := range inv.GroupNames() {
+		if slices.Contains(inv.Groups[name].Children, group) {
+			parents = append(parents, name)
+		}
+	}
+
+	return parents
+}
+
+func (inv *Inventory) groupDepth(group string, seen []string) int {
+	depth := 0
+
+	for _, p := range inv.parents(group) {
+		if slices.Contains(seen, p) {
+			continue
+		}
+
+		depth = max(depth, inv.groupDepth(p, append(seen, group))+1)
+	}
+
+	return depth
+}
+
+// Descendants returns the groups below a group, in a stable order
+func (inv *Inventory) Descendants(group string) []string {
+	found := []string{}
+	queue := []string{group}
+
+	for len(queue) > 0 {
+		g, ok := inv.Groups[queue[0]]
+		queue = queue[1:]
+
+		if !ok {
+			continue
+		}
+
+		for _, c := range g.Children {
+			if c != group && !slices.Contains(found, c) {
+				found = append(found, c)
+				queue = append(queue, c)
+			}
+		}
+	}
+
+	return found
+}
+
+// GroupHosts returns the hosts in a group, including those in child groups
+func (inv *Inventory) GroupHosts(group string) []string {
+	if group == allGroup {
+		return inv.HostNames()
+	}
+
+	hosts := []string{}
+
+	g, ok := inv.Groups[group]
+	if !ok {
+		return hosts
+	}
+
+	hosts = append(hosts, g.Hosts...)
+
+	for _, c := range inv.Descendants(group) {
+		for _, h := range inv.Groups[c].Hosts {
+			if !slices.Contains(hosts, h) {
+				hosts = append(hosts, h)
+			}
+		}
+	}
+
+	return hosts
+}
+
+// HostGroups returns every group that a host belongs to, including ancestors
+func (inv *Inventory) HostGroups(host string) []string {
+	h, ok := inv.Hosts[host]
+	if !ok {
+		return nil
+	}
+
+	groups := []string{allGroup}
+	queue := append([]string{}, h.Groups...)
+
+	for len(queue) > 0 {
+		g := queue[0]
+		queue = queue[1:]
+
+		if slices.Contains(groups, g) {
+			continue
+		}
+
+		groups = append(groups, g)
+		queue = append(queue, inv.parents(g)...)
+	}
+
+	return groups
+}
+
+func groupPriority(g *InventoryGroup) int {
+	priority := 1
+
+	for _, v := range g.Vars {
+		if v.Name == "ansible_group_priority" {
+			if p, ok := v.Value.(int); ok {
+				priority = p
+			}
+		}
+	}
+
+	return priority
+}
+
+// HostVarChain returns the variable definitions for a host from the lowest to the
+// highest precedence, i.e. groups by depth, ansible_group_priority and name, then the host
+func (inv *Inventory) HostVarChain(host string) []HostVarOrigin {
+	chain := []HostVarOrigin{}
+
+	groups := inv.HostGroups(host)
+	slices.SortStableFunc(groups, func(a string, b string) int {
+		da, db := inv.groupDepth(a, nil), inv.groupDepth(b, nil)
+		if da != db {
+			return da - db
+		}
+
+		pa, pb := groupPriority(inv.Groups[a]), groupPriority(inv.Groups[b])
+		if pa != pb {
+			return pa - pb
+		}
+
+		return strings.Compare(a, b)
+	})
+
+	for _, g := range groups {
+		for _, v := range inv.Groups[g].Vars {
+			chain = append(chain, HostVarOrigin{Group: g, Var: v})
+		}
+	}
+
+	if h, ok := inv.Hosts[host]; ok {
+		for _, v := range h.Vars {
+			chain = append(chain, HostVarOrigin{Var: v})
+		}
+	}
+
+	return chain
+}
+
+// HostVars resolves the variables for a host
+func (inv *Inventory) HostVars(host string) map[string]interface{} {
+	vars := map[string]interface{}{}
+
+	for _, o := range inv.HostVarChain(host) {
+		vars[o.Var.Name] = o.Var.Value
+	}
+
+	return vars
+}
+
+func yamlNodeValue(n *yaml.Node) (interface{}, error) {
+	switch n.Kind {
+	case yaml.DocumentNode:
+		if len(n.Content) == 0 {
+			return nil, nil
+		}
+
+		return yamlNodeValue(n.Content[0])
+	case yaml.AliasNode:
+		return yamlNodeValue(n.Alias)
+	case yaml.MappingNode:
+		m := map[string]interface{}{}
+
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k, v := n.Content[i], n.Content[i+1]
+
+			val, err := yamlNodeValue(v)
+			if err != nil {
+				return nil, err
+			}
+
+			if k.Value == "<<" {
+				if merge, ok := val.(map[string]interface{}); ok {
+					for mk, mv := range merge {
+						if _, exists := m[mk]; !exists {
+							m[mk] = mv
+						}
+					}
+				}
+
+				continue
+			}
+
+			m[k.Value] = val
+		}
+
+		return m, nil
+	case yaml.SequenceNode:
+		s := []interface{}{}
+
+		for _, c := range n.Content {
+			val, err := yamlNodeValue(c)
+			if err != nil {
+				return nil, err
+			}
+
+			s = append(s, val)
+		}
+
+		return s, nil
+	default:
+		if n.Tag == vaultTag {
+			return VaultValue{Ciphertext: strings.TrimSpace(n.Value)}, nil
+		}
+
+		var v interface{}
+		if err := n.Decode(&v); err != nil {
+			return nil, err
+		}
+
+		return v, nil
+	}
+}
+
+func yamlMappingPairs(n *yaml.Node) [][2]*yaml.Node {
+	pairs := [][2]*yaml.Node{}
+
+	if n == nil || n.Kind != yaml.MappingNode {
+		return pairs
+	}
+
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		pairs = append(pairs, [2]*yaml.Node{n.Content[i], n.Content[i+1]})
+	}
+
+	return pairs
+}
+
+func (inv *Inventory) loadVarsNode(file string, n *yaml.Node) ([]InventoryVar, error) {
+	vars := []InventoryVar{}
+
+	if n != nil && n.Kind != yaml.MappingNode && !(n.Kind == yaml.ScalarNode && n.Tag == "!!null") {
+		return nil, fmt.Errorf("%s:%d: expected a mapping of variables", file, n.Line)
+	}
+
+	for _, p := range yamlMappingPairs(n) {
+		val, err := yamlNodeValue(p[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", file, p[0].Line, err)
+		}
+
+		vars = append(vars, InventoryVar{Name: p[0].Value, Source: InventorySource{File: file, Line: p[0].Line}, Value: val})
+	}
+
+	return vars, nil
+}
+
+func (inv *Inventory) loadYAMLGroup(file string, name string, key *yaml.Node, n *yaml.Node) error {
+	g := inv.group(name, InventorySource{File: file, Line: key.Line})
+
+	if n.Kind == yaml.ScalarNode && n.Tag == "!!null" {
+		return nil
+	}
+
+	if n.Kind != yaml.MappingNode {
+		return fmt.Errorf("%s:%d: expected a mapping for group '%s'", file, n.Line, name)
+	}
+
+	for _, p := range yamlMappingPairs(n) {
+		switch p[0].Value {
+		case "hosts":
+			if p[1].Kind != yaml.MappingNode && p[1].Tag != "!!null" {
+				return fmt.Errorf("%s:%d: expected a mapping of hosts for group '%s'", file, p[1].Line, name)
+			}
+
+			for _, hp := range yamlMappingPairs(p[1]) {
+				h := inv.addHost(name, hp[0].Value, InventorySource{File: file, Line: hp[0].Line})
+
+				vars, err := inv.loadVarsNode(file, hp[1])
+				if err != nil {
+					return err
+				}
+
+				h.Vars = append(h.Vars, vars...)
+			}
+		case "vars":
+			vars, err := inv.loadVarsNode(file, p[1])
+			if err != nil {
+				return err
+			}
+
+			g.Vars = append(g.Vars, vars...)
+		case "children":
+			if p[1].Kind != yaml.MappingNode && p[1].Tag != "!!null" {
+				return fmt.Errorf("%s:%d: expected a mapping of children for group '%s'", file, p[1].Line, name)
+			}
+
+			for _, cp := range yamlMappingPairs(p[1]) {
+				if err := inv.loadYAMLGroup(file, cp[0].Value, cp[0], cp[1]); err != nil {
+					return err
+				}
+
+				inv.addChild(name, cp[0].Value)
+			}
+		default:
+			return fmt.Errorf("%s:%d: unexpected key '%s' for group '%s'", file, p[0].Line, p[0].Value, name)
+		}
+	}
+
+	return nil
+}
+
+func (inv *Inventory) loadYAML(file string, data []byte) error {
+	var doc yaml.Node
+
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+
+	if len(doc.Content) == 0 {
+		return nil
+	}
+
+	root := doc.Content[0]
+	if root.Kind == yaml.ScalarNode && root.Tag == "!!null" {
+		return nil
+	}
+
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("%s:%d: expected a mapping of groups", file, root.Line)
+	}
+
+	for _, p := range yamlMappingPairs(root) {
+		if p[0].Value == "plugin" && p[1].Kind == yaml.ScalarNode {
+			return fmt.Errorf("%s:%d: %w, '%s' is left to Ansible", file, p[0].Line, errInventoryPlugin, p[1].Value)
+		}
+	}
+
+	for _, p := range yamlMappingPairs(root) {
+		if err := inv.loadYAMLGroup(file, p[0].Value, p[0], p[1]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (inv *Inventory) loadINI(file string, data []byte) error {
+	group := ungroupedGroup
+	section := "hosts"
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+
+	for line := 1; scanner.Scan(); line++ {
+		ln := strings.TrimSpace(scanner.Text())
+		src := InventorySource{File: file, Line: line}
+
+		if ln == "" || strings.HasPrefix(ln, "#") || strings.HasPrefix(ln, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(ln, "[") && strings.HasSuffix(ln, "]") {
+			group, section, _ = strings.Cut(ln[1:len(ln)-1], ":")
+			if section == "" {
+				section = "hosts"
+			}
+
+			inv.group(group, src)
+			continue
+		}
+
+		switch section {
+		case "hosts":
+			fields, err := splitShellWords(ln)
+			if err != nil {
+				return fmt.Errorf("%s:%d: %w", file, line, err)
+			}
+
+			h := inv.addHost(group, fields[0], src)
+
+			for _, f := range fields[1:] {
+				k, v, ok := strings.Cut(f, "=")
+				if !ok {
+					return fmt.Errorf("%s:%d: expected key=value for host '%s'", file, line, fields[0])
+				}
+
+				h.Vars = append(h.Vars, InventoryVar{Name: k, Source: src, Value: v})
+			}
+		case "vars":
+			k, v, ok := strings.Cut(ln, "=")
+			if !ok {
+				return fmt.Errorf("%s:%d: expected key=value for group '%s'", file, line, group)
+			}
+
+			g := inv.group(group, InventorySource{})
+			g.Vars = append(g.Vars, InventoryVar{Name: strings.TrimSpace(k), Source: src, Value: strings.TrimSpace(v)})
+		case "children":
+			inv.group(ln, src)
+			inv.addChild(group, ln)
+		default:
+			return fmt.Errorf("%s:%d: unexpected section '%s'", file, line, section)
+		}
+	}
+
+	return scanner.Err()
+}
+
+func (inv *Inventory) loadScript(file string, data []byte) error {
+	raw := map[string]json.RawMessage{}
+
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("%s: unable to parse the inventory: %w", file, err)
+	}
+
+	src := InventorySource{File: file}
+
+	for _, name := range slices.Sorted(func(yield func(string) bool) {
+		for k := range raw {
+			if !yield(k) {
+				return
+			}
+		}
+	}) {
+		if name == "_meta" {
+			continue
+		}
+
+		var group struct {
+			Children []string               `json:"children"`
+			Hosts    []string               `json:"hosts"`
+			Vars     map[string]interface{} `json:"vars"`
+		}
+
+		if err := json.Unmarshal(raw[name], &group); err != nil {
+			if err := json.Unmarshal(raw[name], &group.Hosts); err != nil {
+				return fmt.Errorf("%s: unexpected content for group '%s'", file, name)
+			}
+		}
+
+		g := inv.group(name, src)
+
+		for _, h := range group.Hosts {
+			inv.addHost(name, h, src)
+		}
+
+		for _, c := range group.Children {
+			inv.group(c, src)
+			inv.addChild(name, c)
+		}
+
+		for _, k := range sortedKeys(group.Vars) {
+			g.Vars = append(g.Vars, InventoryVar{Name: k, Source: src, Value: group.Vars[k]})
+		}
+	}
+
+	var meta struct {
+		Meta struct {
+			HostVars map[string]map[string]interface{} `json:"hostvars"`
+		} `json:"_meta"`
+	}
+
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return fmt.Errorf("%s: unexpected content for _meta: %w", file, err)
+	}
+
+	for _, host := range sortedKeys(meta.Meta.HostVars) {
+		h := inv.host(host, InventorySource{})
+
+		for _, k := range sortedKeys(meta.Meta.HostVars[host]) {
+			h.Vars = append(h.Vars, InventoryVar{Name: k, Source: src, Value: meta.Meta.HostVars[host][k]})
+		}
+	}
+
+	return nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	slices.Sort(keys)
+
+	return keys
+}
+
+func (inv *Inventory) loadVarsDir(dir string, kind string) error {
+	entries, err := os.ReadDir(filepath.Join(dir, kind))
+	if err != nil {
+		return nil
+	}
+
+	for _, e := range entries {
+		name := strings.TrimSuffix(strings.TrimSuffix(strings.TrimSuffix(e.Name(), ".yaml"), ".yml"), ".json")
+		pth := filepath.Join(dir, kind, e.Name())
+		files := []string{pth}
+
+		if e.IsDir() {
+			files = []string{}
+
+			sub, err := os.ReadDir(pth)
+			if err != nil {
+				return err
+			}
+
+			for _, s := range sub {
+				if !s.IsDir() {
+					files = append(files, filepath.Join(pth, s.Name()))
+				}
+			}
+		} else if name == e.Name() && filepath.Ext(e.Name()) != "" {
+			continue
+		}
+
+		for _, f := range files {
+			data, err := inv.readInventoryFile(f)
+			if err != nil {
+				return err
+			}
+
+			var doc yaml.Node
+			if err := yaml.Unmarshal(data, &doc); err != nil {
+				return fmt.Errorf("%s: %w", f, err)
+			}
+
+			if len(doc.Content) == 0 {
+				continue
+			}
+
+			vars, err := inv.loadVarsNode(f, doc.Content[0])
+			if err != nil {
+				return err
+			}
+
+			if kind == "group_vars" {
+				g := inv.group(name, InventorySource{})
+				g.Vars = append(g.Vars, vars...)
+			} else if h, ok := inv.Hosts[name]; ok {
+				h.Vars = append(h.Vars, vars...)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (inv *Inventory) override(path string) ([]byte, bool) {
+	for name, data := range inv.overrides {
+		if sameFile(name, path) {
+			return data, true
+		}
+	}
+
+	return nil, false
+}
+
+func (inv *Inventory) readInventoryFile(path string) ([]byte, error) {
+	if data, ok := inv.override(path); ok {
+		return data, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if isVaultEncrypted(data) {
+		password := inventoryVaultPassword()
+		if len(password) == 0 {
+			return nil, fmt.Errorf("%s: %w", path, errVaultPassword)
+		}
+
+		if data, err = vaultDecrypt(data, password); err != nil {
+			return nil, fmt.Errorf("%s: unable to decrypt: %w", path, err)
+		}
+	}
+
+	return data, nil
+}
+
+func isInventoryConfig(data []byte) bool {
+	cfg := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return false
+	}
+
+	_, hasURI := cfg["uri"]
+	_, hasKey := cfg["key_file"]
+
+	return hasURI && hasKey
+}
+
+func (inv *Inventory) loadFile(path string) error {
+	_, overridden := inv.override(path)
+
+	info, err := os.Stat(path)
+	if err != nil && !overridden {
+		return err
+	}
+
+	ext := filepath.Ext(path)
+
+	// Executable files are treated as inventory scripts
+	if !overridden && info.Mode()&0o111 != 0 && !slices.Contains([]string{".yaml", ".yml", ".json", ".ini"}, ext) {
+		Logger.Debug("executing inventory script '%s'", path)
+
+		data, err := exec.Command(path, "--list").Output()
+		if err != nil {
+			return fmt.Errorf("%s: %w, failed to execute the inventory script: %v", path, errInventoryUnreachable, err)
+		}
+
+		return inv.loadScript(path, data)
+	}
+
+	data, err := inv.readInventoryFile(path)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case isInventoryConfig(data):
+		Logger.Debug("requesting the dynamic inventory configured by '%s'", path)
+
+		cfg, err := loadInventoryConfig(path)
+		if err != nil {
+			return err
+		}
+
+		if data, err = newDynamicInventory(cfg).Fetch(false); err != nil {
+			return fmt.Errorf("%s: %w: %v", path, errInventoryUnreachable, err)
+		}
+
+		return inv.loadScript(path, data)
+	case ext == ".ini" || (ext == "" && !bytes.HasPrefix(bytes.TrimSpace(data), []byte("---")) && bytes.Contains(data, []byte("["))):
+		return inv.loadINI(path, data)
+	default:
+		return inv.loadYAML(path, data)
+	}
+}
+
+func sameFile(a string, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+
+	return errA == nil && errB == nil && absA == absB
+}
+
+// loadInventory merges the comma-separated inventory sources, along with any
+// group_vars and host_vars directories found alongside them
+func loadInventory(sources string) (*Inventory, error) {
+	return loadInventoryWith(sources, nil)
+}
+
+// loadInventoryWith loads the inventory using the content provided for some files,
+// e.g. to validate changes before they are written
+func loadInventoryWith(sources string, overrides map[string][]byte) (*Inventory, error) {
+	inv := newInventory()
+	inv.overrides = overrides
+	dirs := []string{}
+
+	for _, src := range strings.Split(sources, ",") {
+		src = expandHome(strings.TrimSpace(src))
+		if src == "" {
+			continue
+		}
+
+		info, err := os.Stat(src)
+		if _, ok := inv.override(src); err != nil && !ok {
+			Logger.Warning("ignoring inventory path '%s': %v", src, err)
+			continue
+		}
+
+		files := []string{src}
+		dir := filepath.Dir(src)
+
+		if err == nil && info.IsDir() {
+			dir = src
+			files = []string{}
+
+			entries, err := os.ReadDir(src)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, e := range entries {
+				if !e.IsDir() && !strings.HasPrefix(e.Name(), ".") && !isIgnoredInventoryFile(e.Name()) {
+					files = append(files, filepath.Join(src, e.Name()))
+				}
+			}
+		}
+
+		for _, f := range files {
+			if err := inv.loadFile(f); err != nil {
+				return nil, err
+			}
+
+			inv.Sources = append(inv.Sources, f)
+		}
+
+		if !slices.Contains(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+
+	for _, dir := range dirs {
+		for _, kind := range []string{"group_vars", "host_vars"} {
+			if err := inv.loadVarsDir(dir, kind); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	inv.finalise()
+
+	return inv, nil
+}
+
+// isIgnoredInventoryFile skips the same files as Ansible does for inventory directories
+func isIgnoredInventoryFile(name string) bool {
+	for _, ext := range inventoryIgnoreExtensions {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func expandHome(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		return filepath.Join(os.Getenv("HOME"), path[1:])
+	}
+
+	return path
+}
+
+// inventorySources determines the inventory that Ansible will use, preferring
+// the explicit inventory, then ANSIBLE_INVENTORY and lastly the Ansible config
+func inventorySources(inventory string, ansibleConfig string) string {
+	if inventory != "" {
+		return inventory
+	}
+
+	if env := os.Getenv("ANSIBLE_INVENTORY"); env != "" {
+		return env
+	}
+
+	f, err := os.Open(ansibleConfig)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	section := ""
+	scanner := bufio.NewScanner(f)
+
+	for scanner.Scan() {
+		ln := strings.TrimSpace(scanner.Text())
+
+		if strings.HasPrefix(ln, "[") && strings.HasSuffix(ln, "]") {
+			section = ln[1 : len(ln)-1]
+			continue
+		}
+
+		if k, v, ok := strings.Cut(ln, "="); ok && section == "defaults" && strings.TrimSpace(k) == "inventory" {
+			return strings.TrimSpace(v)
+		}
+	}
+
+	return ""
+}
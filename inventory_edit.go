@@ -0,0 +1,800 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	inventoryBackupSuffix = ".bak"
+	inventoryIndent       = 2
+)
+
+// InventoryEditor makes changes to a YAML inventory, keeping the comments,
+// ordering and vault-encrypted values of the original
+type InventoryEditor struct {
+	Encrypted bool
+	File      string
+	Indent    int
+	Mode      os.FileMode
+	Root      *yaml.Node
+
+	encoded  []byte
+	footer   bool
+	header   bool
+	original []byte
+	password []byte
+	text     []byte
+}
+
+// inventoryGroupNode is a group definition within a YAML inventory
+type inventoryGroupNode struct {
+	Key   *yaml.Node
+	Name  string
+	Value *yaml.Node
+}
+
+// inventoryHostNode is a host entry within the hosts of a group
+type inventoryHostNode struct {
+	Group string
+	Hosts *yaml.Node
+	Key   *yaml.Node
+	Value *yaml.Node
+}
+
+func detectIndent(data []byte) int {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+
+	for scanner.Scan() {
+		ln := scanner.Text()
+		trimmed := strings.TrimLeft(ln, " ")
+
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		if indent := len(ln) - len(trimmed); indent > 0 {
+			return indent
+		}
+	}
+
+	return inventoryIndent
+}
+
+func openInventoryEditor(file string) (*InventoryEditor, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &InventoryEditor{File: file, Mode: info.Mode().Perm(), Root: &yaml.Node{}}
+
+	if e.original, err = os.ReadFile(file); err != nil {
+		return nil, err
+	}
+
+	data := e.original
+
+	if isVaultEncrypted(data) {
+		e.Encrypted = true
+		e.password = inventoryVaultPassword()
+
+		if data, err = vaultDecrypt(data, e.password); err != nil {
+			return nil, fmt.Errorf("%s: unable to decrypt: %w", file, err)
+		}
+	}
+
+	if err := e.load(data); err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// load parses the plain text of the inventory
+func (e *InventoryEditor) load(data []byte) error {
+	e.Root = &yaml.Node{}
+
+	if err := yaml.Unmarshal(data, e.Root); err != nil {
+		return fmt.Errorf("%s: %w", e.File, err)
+	}
+
+	if e.Root.Kind == 0 {
+		e.Root = &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+
+	// An empty document, e.g. only "---" and "...", is null rather than a mapping
+	if isYAMLNull(e.Root.Content[0]) {
+		ensureYAMLMapping(e.Root.Content[0])
+	}
+
+	if e.Root.Content[0].Kind != yaml.MappingNode {
+		return fmt.Errorf("%s:%d: expected a mapping of groups", e.File, e.Root.Content[0].Line)
+	}
+
+	e.text = data
+	trimmed := bytes.TrimSpace(data)
+	e.header = bytes.HasPrefix(trimmed, []byte("---"))
+	e.footer = bytes.HasSuffix(trimmed, []byte("\n..."))
+	e.Indent = detectIndent(data)
+
+	encoded, err := e.encode()
+	if err != nil {
+		return fmt.Errorf("%s: %w", e.File, err)
+	}
+
+	e.encoded = encoded
+
+	return nil
+}
+
+// newInventoryEditor starts an empty inventory, for files that do not exist yet
+func newInventoryEditor(file string) *InventoryEditor {
+	return &InventoryEditor{
+		File:   file,
+		Indent: inventoryIndent,
+		Mode:   0o600,
+		Root:   &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}},
+		footer: true,
+		header: true,
+	}
+}
+
+// Diff shows the changes made to the plain text of the inventory
+func (e *InventoryEditor) Diff() (string, error) {
+	data, err := e.Bytes()
+	if err != nil {
+		return "", err
+	}
+
+	return unifiedDiff(e.File, e.File, string(e.text), string(data)), nil
+}
+
+// Bytes returns the plain text of the edited inventory, keeping the layout of the original
+func (e *InventoryEditor) Bytes() ([]byte, error) {
+	data, err := e.encode()
+	if err != nil || e.text == nil {
+		return data, err
+	}
+
+	if bytes.Equal(data, e.encoded) {
+		return e.text, nil
+	}
+
+	return restoreLayout(e.text, data), nil
+}
+
+// encode re-encodes the inventory, which loses the blank lines and the spacing before comments
+func (e *InventoryEditor) encode() ([]byte, error) {
+	buf := bytes.Buffer{}
+
+	if e.header {
+		buf.WriteString("---\n")
+	}
+
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(e.Indent)
+
+	if err := enc.Encode(e.Root); err != nil {
+		return nil, err
+	}
+
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+
+	if e.footer {
+		buf.WriteString("...\n")
+	}
+
+	return buf.Bytes(), nil
+}
+
+// normalizeYAMLLine matches a line of the original with the encoded one, which has trailing
+// spaces removed and a single space before a comment
+func normalizeYAMLLine(ln string) string {
+	ln = strings.TrimRight(ln, " \t")
+
+	if i := strings.Index(ln, " #"); i > 0 && strings.TrimSpace(ln[:i]) != "" {
+		ln = strings.TrimRight(ln[:i], " \t") + ln[i:]
+	}
+
+	return ln
+}
+
+// restoreLayout uses the original for the lines that are unchanged by an edit, along with its
+// blank lines, so that only the edited lines take the layout of the encoder
+func restoreLayout(original []byte, encoded []byte) []byte {
+	a, b := splitLines(string(original)), splitLines(string(encoded))
+	na, nb := make([]string, len(a)), make([]string, len(b))
+
+	for i, ln := range a {
+		na[i] = normalizeYAMLLine(ln)
+	}
+
+	for i, ln := range b {
+		nb[i] = normalizeYAMLLine(ln)
+	}
+
+	buf := bytes.Buffer{}
+	lines := diffLines(na, nb)
+
+	for i, j, k := 0, 0, 0; k < len(lines); {
+		if lines[k].Kind == ' ' {
+			buf.WriteString(a[i] + "\n")
+			i, j, k = i+1, j+1, k+1
+
+			continue
+		}
+
+		// Blank lines before the removed lines are kept for a replacement, while those after
+		// the last one separate what follows, which is where they go for an addition as well
+		before, after, added := []string{}, []string{}, []string{}
+		removed := false
+
+		for ; k < len(lines) && lines[k].Kind != ' '; k++ {
+			switch {
+			case lines[k].Kind == '+':
+				added = append(added, b[j])
+				j++
+			case strings.TrimSpace(a[i]) != "":
+				after = after[:0]
+				removed = true
+				i++
+			case removed:
+				after = append(after, a[i])
+				i++
+			default:
+				before = append(before, a[i])
+				i++
+			}
+		}
+
+		if !removed {
+			before, after = nil, before
+		}
+
+		if len(added) == 0 {
+			before = nil
+		}
+
+		for _, ln := range slices.Concat(before, added, after) {
+			buf.WriteString(ln + "\n")
+		}
+	}
+
+	return buf.Bytes()
+}
+
+// validate checks the edited inventory along with any other sources, without writing it
+func (e *InventoryEditor) validate(data []byte, sources string, monitor string) error {
+	if !slices.Contains(strings.Split(sources, ","), e.File) {
+		sources = strings.Trim(sources+","+e.File, ",")
+	}
+
+	inv, err := loadInventoryWith(sources, map[string][]byte{e.File: data})
+	if err != nil {
+		return fmt.Errorf("the change results in an invalid inventory: %w", err)
+	}
+
+	issues := inv.Validate(monitor)
+	for _, i := range issues {
+		Logger.Error("%s", i)
+	}
+
+	if len(issues) > 0 {
+		return fmt.Errorf("the change results in an inventory with %d issue(s)", len(issues))
+	}
+
+	return nil
+}
+
+// Save validates the edited inventory, along with any other sources, before
+// replacing the file and keeping the original as a backup
+func (e *InventoryEditor) Save(sources string, monitor string, validate bool) error {
+	data, err := e.Bytes()
+	if err != nil {
+		return err
+	}
+
+	if validate {
+		if err := e.validate(data, sources, monitor); err != nil {
+			return err
+		}
+	}
+
+	if e.Encrypted {
+		if data, err = vaultEncrypt(data, e.password); err != nil {
+			return err
+		}
+	}
+
+	if e.original != nil {
+		if err := writeFileAtomic(e.File+inventoryBackupSuffix, e.original, e.Mode); err != nil {
+			return fmt.Errorf("unable to write the backup: %w", err)
+		}
+	}
+
+	return writeFileAtomic(e.File, data, e.Mode)
+}
+
+func yamlNullNode() *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null"}
+}
+
+func isYAMLNull(n *yaml.Node) bool {
+	return n == nil || (n.Kind == yaml.ScalarNode && n.Tag == "!!null")
+}
+
+// ensureYAMLMapping converts a null value to an empty mapping in place, keeping any comments
+func ensureYAMLMapping(n *yaml.Node) error {
+	if isYAMLNull(n) {
+		n.Kind = yaml.MappingNode
+		n.Tag = "!!map"
+		n.Value = ""
+		n.Style = 0
+
+		return nil
+	}
+
+	if n.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: expected a mapping", n.Line)
+	}
+
+	return nil
+}
+
+func yamlMappingIndex(m *yaml.Node, key string) int {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func setYAMLMappingValue(m *yaml.Node, key *yaml.Node, value *yaml.Node) {
+	if i := yamlMappingIndex(m, key.Value); i >= 0 {
+		value.LineComment = m.Content[i+1].LineComment
+		m.Content[i+1] = value
+
+		return
+	}
+
+	m.Content = append(m.Content, key, value)
+}
+
+func removeYAMLMappingKey(m *yaml.Node, key string) bool {
+	i := yamlMappingIndex(m, key)
+	if i < 0 {
+		return false
+	}
+
+	m.Content = append(m.Content[:i], m.Content[i+2:]...)
+
+	return true
+}
+
+// ensureYAMLKey returns the mapping for a key, adding it when missing
+func ensureYAMLKey(m *yaml.Node, key string) (*yaml.Node, error) {
+	if i := yamlMappingIndex(m, key); i >= 0 {
+		return m.Content[i+1], ensureYAMLMapping(m.Content[i+1])
+	}
+
+	value := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	m.Content = append(m.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, value)
+
+	return value, nil
+}
+
+func (e *InventoryEditor) collectGroups(m *yaml.Node, groups []inventoryGroupNode) []inventoryGroupNode {
+	for _, p := range yamlMappingPairs(m) {
+		groups = append(groups, inventoryGroupNode{Key: p[0], Name: p[0].Value, Value: p[1]})
+
+		if i := yamlMappingIndex(p[1], "children"); p[1].Kind == yaml.MappingNode && i >= 0 {
+			groups = e.collectGroups(p[1].Content[i+1], groups)
+		}
+	}
+
+	return groups
+}
+
+func (e *InventoryEditor) groups() []inventoryGroupNode {
+	return e.collectGroups(e.Root.Content[0], nil)
+}
+
+// findGroup prefers the definition of a group over a reference to it as a child
+func (e *InventoryEditor) findGroup(name string) *inventoryGroupNode {
+	var found *inventoryGroupNode
+
+	for _, g := range e.groups() {
+		if g.Name != name {
+			continue
+		}
+
+		if !isYAMLNull(g.Value) {
+			return &g
+		}
+
+		if found == nil {
+			found = &g
+		}
+	}
+
+	return found
+}
+
+// ensureGroup returns the mapping for a group, adding it to the children of all when missing
+func (e *InventoryEditor) ensureGroup(name string) (*yaml.Node, error) {
+	if g := e.findGroup(name); g != nil {
+		return g.Value, ensureYAMLMapping(g.Value)
+	}
+
+	if name == allGroup {
+		return ensureYAMLKey(e.Root.Content[0], allGroup)
+	}
+
+	all, err := e.ensureGroup(allGroup)
+	if err != nil {
+		return nil, err
+	}
+
+	children, err := ensureYAMLKey(all, "children")
+	if err != nil {
+		return nil, err
+	}
+
+	return ensureYAMLKey(children, name)
+}
+
+func (e *InventoryEditor) hostNodes(host string, group string) []inventoryHostNode {
+	found := []inventoryHostNode{}
+
+	for _, g := range e.groups() {
+		if (group != "" && g.Name != group) || g.Value.Kind != yaml.MappingNode {
+			continue
+		}
+
+		i := yamlMappingIndex(g.Value, "hosts")
+		if i < 0 || g.Value.Content[i+1].Kind != yaml.MappingNode {
+			continue
+		}
+
+		hosts := g.Value.Content[i+1]
+		if j := yamlMappingIndex(hosts, host); j >= 0 {
+			found = append(found, inventoryHostNode{Group: g.Name, Hosts: hosts, Key: hosts.Content[j], Value: hosts.Content[j+1]})
+		}
+	}
+
+	return found
+}
+
+func (e *InventoryEditor) addHostNode(group string, key *yaml.Node, value *yaml.Node) error {
+	g, err := e.ensureGroup(group)
+	if err != nil {
+		return fmt.Errorf("group '%s': %w", group, err)
+	}
+
+	hosts, err := ensureYAMLKey(g, "hosts")
+	if err != nil {
+		return fmt.Errorf("hosts for group '%s': %w", group, err)
+	}
+
+	hosts.Content = append(hosts.Content, key, value)
+
+	return nil
+}
+
+// AddHost adds a host to a group, along with any variables
+func (e *InventoryEditor) AddHost(group string, host string, vars [][2]*yaml.Node) error {
+	if len(e.hostNodes(host, group)) > 0 {
+		return fmt.Errorf("host '%s' is already in group '%s'", host, group)
+	}
+
+	value := yamlNullNode()
+	if len(vars) > 0 {
+		value = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+
+		for _, v := range vars {
+			setYAMLMappingValue(value, v[0], v[1])
+		}
+	}
+
+	return e.addHostNode(group, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: host}, value)
+}
+
+// RemoveHost removes a host from a group, or every group when the group is empty
+func (e *InventoryEditor) RemoveHost(host string, group string) error {
+	found := e.hostNodes(host, group)
+	if len(found) == 0 {
+		return fmt.Errorf("host '%s' was not found", host)
+	}
+
+	for _, h := range found {
+		removeYAMLMappingKey(h.Hosts, host)
+	}
+
+	return nil
+}
+
+// selectHostNode prefers an entry for the host that already has variables
+func (e *InventoryEditor) selectHostNode(host string, group string) (*inventoryHostNode, error) {
+	found := e.hostNodes(host, group)
+	if len(found) == 0 {
+		return nil, fmt.Errorf("host '%s' was not found", host)
+	}
+
+	for _, h := range found {
+		if h.Value.Kind == yaml.MappingNode {
+			return &h, nil
+		}
+	}
+
+	return &found[0], nil
+}
+
+func (e *InventoryEditor) varsNode(host string, group string) (*yaml.Node, error) {
+	if host == "" {
+		if group == "" {
+			return nil, fmt.Errorf("a host or group is required")
+		}
+
+		g, err := e.ensureGroup(group)
+		if err != nil {
+			return nil, err
+		}
+
+		return ensureYAMLKey(g, "vars")
+	}
+
+	h, err := e.selectHostNode(host, group)
+	if err != nil {
+		return nil, err
+	}
+
+	return h.Value, ensureYAMLMapping(h.Value)
+}
+
+// SetVars sets variables for a host, or for a group when the host is empty
+func (e *InventoryEditor) SetVars(host string, group string, vars [][2]*yaml.Node) error {
+	m, err := e.varsNode(host, group)
+	if err != nil {
+		return err
+	}
+
+	for _, v := range vars {
+		path := strings.Split(v[0].Value, ".")
+		parent := m
+
+		// Nested values use a dotted path, as variable names cannot contain dots
+		for _, key := range path[:len(path)-1] {
+			if parent, err = ensureYAMLKey(parent, key); err != nil {
+				return fmt.Errorf("variable '%s': %w", v[0].Value, err)
+			}
+		}
+
+		setYAMLMappingValue(parent, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: path[len(path)-1]}, v[1])
+	}
+
+	return nil
+}
+
+// UnsetVars removes variables from a host, or from a group when the host is empty
+func (e *InventoryEditor) UnsetVars(host string, group string, names []string) error {
+	m, err := e.varsNode(host, group)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		path := strings.Split(name, ".")
+		parent := m
+
+		for _, key := range path[:len(path)-1] {
+			i := yamlMappingIndex(parent, key)
+			if i < 0 || parent.Content[i+1].Kind != yaml.MappingNode {
+				return fmt.Errorf("variable '%s' was not found", name)
+			}
+
+			parent = parent.Content[i+1]
+		}
+
+		if !removeYAMLMappingKey(parent, path[len(path)-1]) {
+			return fmt.Errorf("variable '%s' was not found", name)
+		}
+	}
+
+	return nil
+}
+
+// Move a host to another group, keeping its variables and comments
+func (e *InventoryEditor) Move(host string, from string, to string) error {
+	found := []inventoryHostNode{}
+
+	for _, h := range e.hostNodes(host, from) {
+		if h.Group != allGroup && h.Group != to {
+			found = append(found, h)
+		}
+	}
+
+	if len(found) == 0 {
+		return fmt.Errorf("host '%s' was not found in a group to move from", host)
+	}
+
+	key, value := found[0].Key, found[0].Value
+
+	for _, h := range found {
+		removeYAMLMappingKey(h.Hosts, host)
+
+		if h.Value.Kind != yaml.MappingNode || h.Value == value {
+			continue
+		}
+
+		if err := ensureYAMLMapping(value); err != nil {
+			return err
+		}
+
+		for _, p := range yamlMappingPairs(h.Value) {
+			if yamlMappingIndex(value, p[0].Value) < 0 {
+				value.Content = append(value.Content, p[0], p[1])
+			}
+		}
+	}
+
+	if len(e.hostNodes(host, to)) > 0 {
+		return nil
+	}
+
+	return e.addHostNode(to, key, value)
+}
+
+// inventoryVarNodes parses NAME=VALUE pairs, with values typed as per YAML
+func inventoryVarNodes(vars []string, password []byte) ([][2]*yaml.Node, error) {
+	nodes := [][2]*yaml.Node{}
+
+	for _, v := range vars {
+		name, raw, ok := strings.Cut(v, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("expected NAME=VALUE, got '%s'", v)
+		}
+
+		value := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: raw}
+
+		if password != nil {
+			encrypted, err := vaultEncrypt([]byte(raw), password)
+			if err != nil {
+				return nil, err
+			}
+
+			value = &yaml.Node{Kind: yaml.ScalarNode, Style: yaml.LiteralStyle, Tag: vaultTag, Value: string(encrypted)}
+		} else {
+			doc := yaml.Node{}
+
+			if err := yaml.Unmarshal([]byte(raw), &doc); err == nil && len(doc.Content) > 0 {
+				value = doc.Content[0]
+			}
+		}
+
+		nodes = append(nodes, [2]*yaml.Node{{Kind: yaml.ScalarNode, Tag: "!!str", Value: name}, value})
+	}
+
+	return nodes, nil
+}
+
+// defaultInventoryFile chooses the first YAML file from the inventory sources
+func defaultInventoryFile(sources string) string {
+	for _, src := range strings.Split(sources, ",") {
+		src = expandHome(strings.TrimSpace(src))
+
+		if ext := filepath.Ext(src); ext != ".yaml" && ext != ".yml" {
+			continue
+		}
+
+		if _, err := os.Stat(src); err == nil {
+			return src
+		}
+	}
+
+	return ""
+}
+
+func inventoryEditCommand(action string, args []string) int {
+	usage := map[string]string{
+		"add-host":    "--group GROUP HOST [--var NAME=VALUE]...",
+		"move":        "[--from GROUP] --group GROUP HOST",
+		"remove-host": "[--group GROUP] HOST",
+		"set-var":     "[--group GROUP] [HOST] --var NAME=VALUE...",
+		"unset-var":   "[--group GROUP] [HOST] --var NAME...",
+	}
+
+	vars := []string{}
+	fs := subcommandFlags("inventory "+action, usage[action])
+	encrypt := fs.Bool("encrypt", false, "Encrypt the values using the password in ANSIBLE_VAULT_PASSWORD_FILE")
+	file := fs.String("file", "", "The YAML inventory to edit, the first YAML file in the inventory is used when empty")
+	from := fs.String("from", "", "Only move the host from this group, use with move")
+	group := fs.String("group", "", "Group for the host or variables")
+	inventory := fs.String("inventory", os.Getenv("ANSIBLE_INVENTORY"), "Comma-separated inventory sources, used for validation [ANSIBLE_INVENTORY]")
+	monitor := fs.String("monitor", "monitor", "Monitor alias")
+	skipValidation := fs.Bool("skip-validation", false, "Save the changes without validating the inventory")
+	fs.Func("var", "Variable to set as NAME=VALUE, or NAME to unset, can be repeated", func(s string) error {
+		vars = append(vars, s)
+		return nil
+	})
+	args = parseSubcommandFlags(fs, args)
+
+	host := ""
+	if len(args) > 0 {
+		host = args[0]
+	}
+
+	needsVars := action == "set-var" || action == "unset-var"
+
+	if len(args) > 1 || (host == "" && !needsVars) || (needsVars && len(vars) == 0) || (*group == "" && (action == "add-host" || action == "move")) {
+		fs.Usage()
+		return 1
+	}
+
+	if *file == "" {
+		if *file = defaultInventoryFile(*inventory); *file == "" {
+			Logger.Error("unable to find a YAML inventory to edit, use --file or --inventory")
+			return 1
+		}
+	}
+
+	e, err := openInventoryEditor(*file)
+	if err != nil {
+		Logger.Error("unable to open the inventory: %v", err)
+		return 1
+	}
+
+	var password []byte
+	if *encrypt {
+		if password = inventoryVaultPassword(); password == nil {
+			Logger.Error("a vault password is required to encrypt, please set ANSIBLE_VAULT_PASSWORD_FILE")
+			return 1
+		}
+	}
+
+	nodes := [][2]*yaml.Node{}
+	if action != "unset-var" {
+		if nodes, err = inventoryVarNodes(vars, password); err != nil {
+			Logger.Error("%v", err)
+			return 1
+		}
+	}
+
+	switch action {
+	case "add-host":
+		err = e.AddHost(*group, host, nodes)
+	case "move":
+		err = e.Move(host, *from, *group)
+	case "remove-host":
+		err = e.RemoveHost(host, *group)
+	case "set-var":
+		err = e.SetVars(host, *group, nodes)
+	case "unset-var":
+		err = e.UnsetVars(host, *group, vars)
+	}
+
+	if err != nil {
+		Logger.Error("unable to %s: %v", action, err)
+		return 1
+	}
+
+	if err := e.Save(*inventory, *monitor, !*skipValidation); err != nil {
+		Logger.Error("unable to save '%s': %v", e.File, err)
+		return 1
+	}
+
+	fmt.Printf("Updated '%s', the original is saved as '%s'\n", e.File, e.File+inventoryBackupSuffix)
+
+	return 0
+}
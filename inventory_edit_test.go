@@ -0,0 +1,240 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const inventoryEditDummy = `---
+# Customer inventory
+all:
+  children:
+    monitors:
+      hosts:
+        monitor:
+    mysql:
+      hosts:
+        db1: # primary
+          ansible_host: 10.0.0.1
+          secret: !vault |
+            $ANSIBLE_VAULT;1.1;AES256
+            3630373835
+      vars:
+        mysql_port: 3306
+    postgresql:
+      hosts:
+...
+`
+
+func TestInventoryEditor(t *testing.T) {
+	file := filepath.Join(writeInventoryFiles(t, map[string]string{"hosts.yaml": inventoryEditDummy}), "hosts.yaml")
+
+	e, err := openInventoryEditor(file)
+	if err != nil {
+		t.Fatalf("failed to openInventoryEditor: %v", err)
+	}
+
+	vars, err := inventoryVarNodes([]string{"ansible_host=10.0.0.7", "ansible_port=2222"}, nil)
+	if err != nil {
+		t.Fatalf("failed to inventoryVarNodes: %v", err)
+	}
+
+	if err := e.AddHost("mysql", "db7", vars); err != nil {
+		t.Fatalf("failed to AddHost: %v", err)
+	}
+
+	if err := e.AddHost("mysql", "db7", nil); err == nil {
+		t.Fatalf("expected an error when adding db7 again")
+	}
+
+	if err := e.Move("db7", "", "postgresql"); err != nil {
+		t.Fatalf("failed to Move: %v", err)
+	}
+
+	if err := e.AddHost("haproxy", "lb1", nil); err != nil {
+		t.Fatalf("failed to AddHost to a new group: %v", err)
+	}
+
+	if err := e.SetVars("", "mysql", vars[1:]); err != nil {
+		t.Fatalf("failed to SetVars for a group: %v", err)
+	}
+
+	nested, _ := inventoryVarNodes([]string{"pmm_payload_add_mysql.username=pmm", "pmm_payload_add_mysql.password=pmm"}, nil)
+	if err := e.SetVars("db1", "", nested); err != nil {
+		t.Fatalf("failed to SetVars for a host: %v", err)
+	}
+
+	if err := e.UnsetVars("db1", "", []string{"pmm_payload_add_mysql.password"}); err != nil {
+		t.Fatalf("failed to UnsetVars for a host: %v", err)
+	}
+
+	if err := e.UnsetVars("", "mysql", []string{"mysql_port"}); err != nil {
+		t.Fatalf("failed to UnsetVars for a group: %v", err)
+	}
+
+	if err := e.UnsetVars("db1", "", []string{"missing"}); err == nil {
+		t.Fatalf("expected an error for an unknown variable")
+	}
+
+	if err := e.RemoveHost("lb1", ""); err != nil {
+		t.Fatalf("failed to RemoveHost: %v", err)
+	}
+
+	if err := e.Save(file, "monitor", true); err != nil {
+		t.Fatalf("failed to Save: %v", err)
+	}
+
+	expected := `---
+# Customer inventory
+all:
+  children:
+    monitors:
+      hosts:
+        monitor:
+    mysql:
+      hosts:
+        db1: # primary
+          ansible_host: 10.0.0.1
+          secret: !vault |
+            $ANSIBLE_VAULT;1.1;AES256
+            3630373835
+          pmm_payload_add_mysql:
+            username: pmm
+      vars:
+        ansible_port: 2222
+    postgresql:
+      hosts:
+        db7:
+          ansible_host: 10.0.0.7
+          ansible_port: 2222
+    haproxy:
+      hosts: {}
+...
+`
+
+	if data, _ := os.ReadFile(file); string(data) != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, data)
+	}
+
+	if data, _ := os.ReadFile(file + inventoryBackupSuffix); string(data) != inventoryEditDummy {
+		t.Fatalf("expected the backup to match the original, got:\n%s", data)
+	}
+
+	// Changes that fail validation are not saved
+	e, _ = openInventoryEditor(file)
+
+	if err := e.RemoveHost("monitor", ""); err != nil {
+		t.Fatalf("failed to RemoveHost: %v", err)
+	}
+
+	if err := e.Save(file, "monitor", true); err == nil || !strings.Contains(err.Error(), "1 issue") {
+		t.Fatalf("expected a validation error, got %v", err)
+	}
+
+	if data, _ := os.ReadFile(file); string(data) != expected {
+		t.Fatalf("expected the inventory to be unchanged, got:\n%s", data)
+	}
+}
+
+func TestInventoryEditorLayout(t *testing.T) {
+	original := `---
+# Customer inventory
+all:
+  children:
+
+    monitors:
+      hosts:
+        monitor:
+          ansible_host: 10.0.0.10   # the PMM box
+
+    mysql:
+      hosts:
+        db1:
+          ansible_host: 10.0.0.1    # primary
+
+        db2:
+          ansible_host: 10.0.0.2    # replica
+
+        db3:
+          ansible_host: 10.0.0.3
+...
+`
+	file := filepath.Join(writeInventoryFiles(t, map[string]string{"hosts.yaml": original}), "hosts.yaml")
+
+	e, err := openInventoryEditor(file)
+	if err != nil {
+		t.Fatalf("failed to openInventoryEditor: %v", err)
+	}
+
+	if data, err := e.Bytes(); err != nil || string(data) != original {
+		t.Fatalf("expected the inventory to be unchanged, got:\n%s", data)
+	}
+
+	if diff, _ := e.Diff(); diff != "" {
+		t.Fatalf("expected no differences, got:\n%s", diff)
+	}
+
+	vars, _ := inventoryVarNodes([]string{"ansible_host=10.0.0.4"}, nil)
+	if err := e.AddHost("mysql", "db4", vars); err != nil {
+		t.Fatalf("failed to AddHost: %v", err)
+	}
+
+	if err := e.RemoveHost("db2", ""); err != nil {
+		t.Fatalf("failed to RemoveHost: %v", err)
+	}
+
+	vars, _ = inventoryVarNodes([]string{"ansible_host=10.0.0.11"}, nil)
+	if err := e.SetVars("db1", "", vars); err != nil {
+		t.Fatalf("failed to SetVars: %v", err)
+	}
+
+	expected := `---
+# Customer inventory
+all:
+  children:
+
+    monitors:
+      hosts:
+        monitor:
+          ansible_host: 10.0.0.10   # the PMM box
+
+    mysql:
+      hosts:
+        db1:
+          ansible_host: 10.0.0.11 # primary
+
+        db3:
+          ansible_host: 10.0.0.3
+        db4:
+          ansible_host: 10.0.0.4
+...
+`
+
+	if data, _ := e.Bytes(); string(data) != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, data)
+	}
+}
+
+func TestInventoryEditorEmptyDocument(t *testing.T) {
+	file := filepath.Join(writeInventoryFiles(t, map[string]string{"hosts.yaml": "---\n...\n"}), "hosts.yaml")
+
+	e, err := openInventoryEditor(file)
+	if err != nil {
+		t.Fatalf("failed to openInventoryEditor: %v", err)
+	}
+
+	if data, _ := e.Bytes(); string(data) != "---\n...\n" {
+		t.Fatalf("expected the inventory to be unchanged, got:\n%s", data)
+	}
+
+	if err := e.AddHost("mysql", "db1", nil); err != nil {
+		t.Fatalf("failed to AddHost: %v", err)
+	}
+
+	expected := "---\nall:\n  children:\n    mysql:\n      hosts:\n        db1:\n...\n"
+	if data, _ := e.Bytes(); string(data) != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, data)
+	}
+}
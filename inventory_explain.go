@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// VarExplanation is the resolution of a variable for a host, with each definition
+// from the lowest to the highest precedence so that the last one is the value used
+type VarExplanation struct {
+	Chain []HostVarOrigin
+	Host  string
+	Name  string
+	Rule  string
+}
+
+// Value is the final value of the variable
+func (e VarExplanation) Value() interface{} {
+	return e.Chain[len(e.Chain)-1].Var.Value
+}
+
+// isVarsFile checks for files from group_vars or host_vars, which Ansible loads after the inventory
+func isVarsFile(file string) bool {
+	for _, kind := range []string{"group_vars", "host_vars"} {
+		if strings.Contains(filepath.ToSlash(file), "/"+kind+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// precedenceRule describes why the winning definition overrides the one before it
+func (inv *Inventory) precedenceRule(winner HostVarOrigin, previous HostVarOrigin) string {
+	switch {
+	case winner.Group == "" && previous.Group != "":
+		return "host variables override group variables"
+	case winner.Group != previous.Group:
+		dw, dp := inv.groupDepth(winner.Group, nil), inv.groupDepth(previous.Group, nil)
+		if dw != dp {
+			return fmt.Sprintf("child groups override their parents, '%s' is at depth %d and '%s' at depth %d", winner.Group, dw, previous.Group, dp)
+		}
+
+		pw, pp := groupPriority(inv.Groups[winner.Group]), groupPriority(inv.Groups[previous.Group])
+		if pw != pp {
+			return fmt.Sprintf("groups at the same depth are ordered by ansible_group_priority, '%s' has %d and '%s' has %d", winner.Group, pw, previous.Group, pp)
+		}
+
+		return fmt.Sprintf("groups at the same depth and priority are ordered by name, '%s' sorts after '%s'", winner.Group, previous.Group)
+	case isVarsFile(winner.Var.Source.File) && !isVarsFile(previous.Var.Source.File):
+		return "group_vars and host_vars override the inventory sources"
+	default:
+		return "later sources override earlier ones, in the order of the inventory"
+	}
+}
+
+// ExplainVar returns the definitions of a variable for a host
+func (inv *Inventory) ExplainVar(host string, name string) (VarExplanation, bool) {
+	e := VarExplanation{Host: host, Name: name}
+
+	for _, o := range inv.HostVarChain(host) {
+		if o.Var.Name == name {
+			e.Chain = append(e.Chain, o)
+		}
+	}
+
+	switch len(e.Chain) {
+	case 0:
+		return e, false
+	case 1:
+		e.Rule = "only defined once"
+	default:
+		e.Rule = inv.precedenceRule(e.Chain[len(e.Chain)-1], e.Chain[len(e.Chain)-2])
+	}
+
+	return e, true
+}
+
+func explainValue(v interface{}) string {
+	if _, ok := v.(VaultValue); ok {
+		return "!vault (encrypted)"
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+
+	return string(b)
+}
+
+func (e VarExplanation) write(w io.Writer) {
+	fmt.Fprintf(w, "%s = %s\n", e.Name, explainValue(e.Value()))
+
+	for i, o := range e.Chain {
+		marker := " "
+		if i == len(e.Chain)-1 {
+			marker = "*"
+		}
+
+		origin := "host " + e.Host
+		if o.Group != "" {
+			origin = "group " + o.Group
+		}
+
+		fmt.Fprintf(w, "  %s %-20s %-40s %s\n", marker, origin, o.Var.Source, explainValue(o.Var.Value))
+	}
+
+	fmt.Fprintf(w, "  Rule: %s\n", e.Rule)
+}
+
+// explainHost writes the final value and definitions for the variables of a host, or
+// for a single variable when the name is provided
+func explainHost(w io.Writer, inv *Inventory, host string, name string) error {
+	h, ok := inv.Hosts[host]
+	if !ok {
+		return fmt.Errorf("unknown host '%s'", host)
+	}
+
+	names := []string{name}
+	if name == "" {
+		names = sortedKeys(inv.HostVars(host))
+	}
+
+	if _, ok := inv.ExplainVar(host, name); name != "" && !ok {
+		return fmt.Errorf("the variable '%s' is not set for '%s'", name, host)
+	}
+
+	groups := inv.HostGroups(host)
+	slices.Sort(groups)
+
+	sources := []string{}
+	for _, s := range h.Sources {
+		sources = append(sources, s.String())
+	}
+
+	fmt.Fprintf(w, "Host: %s\nDefined in: %s\nGroups: %s\nInventory: %s\n", host, strings.Join(sources, ", "), strings.Join(groups, ", "), strings.Join(inv.Sources, ", "))
+
+	for _, n := range names {
+		e, _ := inv.ExplainVar(host, n)
+
+		fmt.Fprintln(w)
+		e.write(w)
+	}
+
+	return nil
+}
+
+func inventoryExplainCommand(args []string) int {
+	fs := subcommandFlags("inventory explain", "HOST [VAR] [--inventory PATHS]")
+	inventory := fs.String("inventory", "", "Comma-separated inventory sources, otherwise as per Ansible [ANSIBLE_INVENTORY, ~/.ansible.cfg]")
+	args = parseSubcommandFlags(fs, args)
+
+	if len(args) == 0 || len(args) > 2 {
+		fs.Usage()
+		return 1
+	}
+
+	sources := inventorySources(*inventory, filepath.Join(os.Getenv("HOME"), ".ansible.cfg"))
+	if sources == "" {
+		Logger.Error("an inventory is required")
+		return 1
+	}
+
+	inv, err := loadInventory(sources)
+	if err != nil {
+		Logger.Error("unable to load the inventory: %v", err)
+		return 1
+	}
+
+	name := ""
+	if len(args) == 2 {
+		name = args[1]
+	}
+
+	if err := explainHost(os.Stdout, inv, args[0], name); err != nil {
+		Logger.Error("%v", err)
+		return 1
+	}
+
+	return 0
+}
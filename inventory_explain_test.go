@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const inventoryExplainDummy = `---
+all:
+  vars:
+    pmm_version: 2
+  children:
+    dbservers:
+      children:
+        mysql:
+        postgresql:
+      vars:
+        pmm_version: 3
+    mysql:
+      hosts:
+        db1:
+          ansible_host: 10.0.0.1
+      vars:
+        ansible_group_priority: 10
+        role: mysql
+    postgresql:
+      hosts:
+        db1:
+      vars:
+        role: postgresql
+...
+`
+
+func TestExplainVar(t *testing.T) {
+	dir := writeInventoryFiles(t, map[string]string{
+		"a.yaml":                inventoryExplainDummy,
+		"b.ini":                 "db1 ansible_host=10.0.0.9\n",
+		"group_vars/mysql.yaml": "---\npmm_version: 3.1\n",
+	})
+
+	inv, err := loadInventory(filepath.Join(dir, "a.yaml") + "," + filepath.Join(dir, "b.ini"))
+	if err != nil {
+		t.Fatalf("failed to loadInventory: %v", err)
+	}
+
+	tests := map[string]struct {
+		rule   string
+		source string
+		value  interface{}
+	}{
+		"ansible_host": {"later sources override earlier ones", "b.ini:1", "10.0.0.9"},
+		"pmm_version":  {"child groups override their parents", filepath.Join("group_vars", "mysql.yaml") + ":2", 3.1},
+		"role":         {"ordered by ansible_group_priority, 'mysql' has 10 and 'postgresql' has 1", "a.yaml:18", "mysql"},
+	}
+
+	for name, test := range tests {
+		e, ok := inv.ExplainVar("db1", name)
+		if !ok {
+			t.Fatalf("expected %s to be set for db1", name)
+		}
+
+		if e.Value() != test.value {
+			t.Fatalf("expected %v for %s, got %v", test.value, name, e.Value())
+		}
+
+		if !strings.Contains(e.Rule, test.rule) {
+			t.Fatalf("expected the rule for %s to contain '%s', got '%s'", name, test.rule, e.Rule)
+		}
+
+		if src := e.Chain[len(e.Chain)-1].Var.Source.String(); !strings.HasSuffix(src, test.source) {
+			t.Fatalf("expected %s to be set by %s, got %s", name, test.source, src)
+		}
+	}
+
+	if e, _ := inv.ExplainVar("db1", "pmm_version"); len(e.Chain) != 3 || e.Chain[0].Group != allGroup {
+		t.Fatalf("expected pmm_version from all, dbservers and mysql, got %+v", e.Chain)
+	}
+
+	if _, ok := inv.ExplainVar("db1", "missing"); ok {
+		t.Fatalf("expected missing to be unset")
+	}
+
+	out := bytes.Buffer{}
+	if err := explainHost(&out, inv, "db1", ""); err != nil {
+		t.Fatalf("failed to explainHost: %v", err)
+	}
+
+	for _, expected := range []string{"Groups: all, dbservers, mysql, postgresql\n", "\nrole = \"mysql\"\n", "  * group mysql ", "Rule: only defined once\n"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Fatalf("expected '%s' in:\n%s", expected, out.String())
+		}
+	}
+
+	if err := explainHost(&out, inv, "db1", "missing"); err == nil {
+		t.Fatalf("expected an error for an unknown variable")
+	}
+
+	if err := explainHost(&out, inv, "db9", ""); err == nil {
+		t.Fatalf("expected an error for an unknown host")
+	}
+}
@@ -0,0 +1,506 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	redactedValue = "REDACTED"
+
+	defaultPMMServerPort = 8443
+	defaultSSHPort       = 22
+)
+
+var (
+	inventoryExportFormats = []string{"connect-json", "csv", "ini", "json", "ssh-config", "yaml"}
+	inventorySecretModes   = []string{"keep", "redact", "strip"}
+
+	// inventorySecretPatterns identify secrets by the name of a variable, along with vault values
+	inventorySecretPatterns = []string{"access_key", "api_key", "credential", "passphrase", "passwd", "password", "secret", "token"}
+
+	// connectServices are the groups with services that the connection tool supports
+	connectServices = []string{"mongodb", "mysql", "postgresql"}
+)
+
+// isSecretVar checks the name of a variable, ignoring paths such as ansible_ssh_private_key_file
+func isSecretVar(name string) bool {
+	name = strings.ToLower(name)
+
+	if strings.HasSuffix(name, "_file") || strings.HasSuffix(name, "_path") {
+		return false
+	}
+
+	for _, p := range inventorySecretPatterns {
+		if strings.Contains(name, p) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// filterSecrets returns a copy of the variables with secrets either kept, redacted or stripped,
+// or replaced with a digest so that changes can be detected without keeping the secret
+func filterSecrets(vars map[string]interface{}, mode string) map[string]interface{} {
+	filtered := map[string]interface{}{}
+
+	for k, v := range vars {
+		_, vault := v.(VaultValue)
+
+		switch {
+		case mode == "keep":
+			filtered[k] = v
+		case vault || isSecretVar(k):
+			switch mode {
+			case "digest":
+				b, _ := json.Marshal(v)
+				filtered[k] = fmt.Sprintf("sha256:%x", sha256.Sum256(b))
+			case "redact":
+				filtered[k] = redactedValue
+			}
+		default:
+			if m, ok := v.(map[string]interface{}); ok {
+				v = filterSecrets(m, mode)
+			}
+
+			filtered[k] = v
+		}
+	}
+
+	return filtered
+}
+
+// exportGroups returns the groups to export, leaving out the implicit groups
+func (inv *Inventory) exportGroups() []string {
+	groups := []string{}
+
+	for _, g := range inv.GroupNames() {
+		if g != allGroup && g != ungroupedGroup {
+			groups = append(groups, g)
+		}
+	}
+
+	return groups
+}
+
+// stringVar returns the first of the variables that is set, as a string
+func stringVar(vars map[string]interface{}, names ...string) string {
+	for _, n := range names {
+		if v, ok := vars[n]; ok && v != nil {
+			return fmt.Sprint(v)
+		}
+	}
+
+	return ""
+}
+
+// proxyJump finds the jump host in ansible_ssh_common_args, as set by an import from ssh_config
+func proxyJump(vars map[string]interface{}) string {
+	words, err := splitShellWords(stringVar(vars, "ansible_ssh_common_args", "ansible_ssh_extra_args"))
+	if err != nil {
+		return ""
+	}
+
+	for i, w := range words {
+		switch {
+		case w == "-J" && i+1 < len(words):
+			return words[i+1]
+		case w == "-o" && i+1 < len(words) && strings.HasPrefix(strings.ToLower(words[i+1]), "proxyjump="):
+			return words[i+1][len("proxyjump="):]
+		case strings.HasPrefix(strings.ToLower(w), "-oproxyjump="):
+			return w[len("-oproxyjump="):]
+		}
+	}
+
+	return ""
+}
+
+func exportYAML(w io.Writer, inv *Inventory, hostVars map[string]map[string]interface{}) error {
+	hosts := map[string]interface{}{}
+	for h, vars := range hostVars {
+		if len(vars) > 0 {
+			hosts[h] = vars
+		} else {
+			hosts[h] = nil
+		}
+	}
+
+	children := map[string]interface{}{}
+	for _, name := range inv.exportGroups() {
+		g := inv.Groups[name]
+		group := map[string]interface{}{}
+
+		if len(g.Hosts) > 0 {
+			members := map[string]interface{}{}
+			for _, h := range g.Hosts {
+				members[h] = nil
+			}
+
+			group["hosts"] = members
+		}
+
+		if len(g.Children) > 0 {
+			members := map[string]interface{}{}
+			for _, c := range g.Children {
+				members[c] = nil
+			}
+
+			group["children"] = members
+		}
+
+		if len(group) > 0 {
+			children[name] = group
+		} else {
+			children[name] = nil
+		}
+	}
+
+	all := map[string]interface{}{}
+	if len(hosts) > 0 {
+		all["hosts"] = hosts
+	}
+
+	if len(children) > 0 {
+		all["children"] = children
+	}
+
+	buf := bytes.Buffer{}
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+
+	if err := enc.Encode(map[string]interface{}{allGroup: all}); err != nil {
+		return err
+	}
+
+	if err := enc.Close(); err != nil {
+		return err
+	}
+
+	_, err := fmt.Fprintf(w, "---\n%s...\n", buf.String())
+
+	return err
+}
+
+// exportJSON uses the same layout as ansible-inventory --list
+func exportJSON(w io.Writer, inv *Inventory, hostVars map[string]map[string]interface{}) error {
+	out := map[string]interface{}{
+		"_meta": map[string]interface{}{"hostvars": hostVars},
+	}
+
+	for _, name := range inv.GroupNames() {
+		g := inv.Groups[name]
+		group := map[string]interface{}{}
+
+		if len(g.Hosts) > 0 && name != allGroup {
+			group["hosts"] = g.Hosts
+		}
+
+		if len(g.Children) > 0 {
+			group["children"] = g.Children
+		}
+
+		out[name] = group
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "    ")
+
+	return enc.Encode(out)
+}
+
+// iniValue formats a value so that Ansible parses it back to the same type
+func iniValue(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "None", nil
+	case bool:
+		if v {
+			return "True", nil
+		}
+
+		return "False", nil
+	case int, float64:
+		return fmt.Sprint(v), nil
+	case string:
+		if v != "" && !strings.ContainsAny(v, " \t\"'#=\\") {
+			return v, nil
+		}
+
+		return strconv.Quote(v), nil
+	case VaultValue:
+		return "", fmt.Errorf("vault values cannot be exported as INI, use --secrets redact or strip")
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+
+		return "'" + string(b) + "'", nil
+	}
+}
+
+func iniHostLine(host string, vars map[string]interface{}) (string, error) {
+	ln := []string{host}
+
+	for _, k := range sortedKeys(vars) {
+		v, err := iniValue(vars[k])
+		if err != nil {
+			return "", fmt.Errorf("%s: %s: %w", host, k, err)
+		}
+
+		ln = append(ln, k+"="+v)
+	}
+
+	return strings.Join(ln, " "), nil
+}
+
+// exportINI sets the variables for each host on its first line, with ungrouped hosts at the top
+func exportINI(w io.Writer, inv *Inventory, hostVars map[string]map[string]interface{}) error {
+	out := strings.Builder{}
+	done := []string{}
+
+	if g, ok := inv.Groups[ungroupedGroup]; ok {
+		for _, h := range g.Hosts {
+			ln, err := iniHostLine(h, hostVars[h])
+			if err != nil {
+				return err
+			}
+
+			out.WriteString(ln + "\n")
+			done = append(done, h)
+		}
+	}
+
+	for _, name := range inv.exportGroups() {
+		g := inv.Groups[name]
+
+		if len(g.Hosts) > 0 {
+			if out.Len() > 0 {
+				out.WriteString("\n")
+			}
+
+			fmt.Fprintf(&out, "[%s]\n", name)
+
+			for _, h := range g.Hosts {
+				if slices.Contains(done, h) {
+					out.WriteString(h + "\n")
+					continue
+				}
+
+				ln, err := iniHostLine(h, hostVars[h])
+				if err != nil {
+					return err
+				}
+
+				out.WriteString(ln + "\n")
+				done = append(done, h)
+			}
+		}
+
+		if len(g.Children) > 0 {
+			if out.Len() > 0 {
+				out.WriteString("\n")
+			}
+
+			fmt.Fprintf(&out, "[%s:children]\n%s\n", name, strings.Join(g.Children, "\n"))
+		}
+	}
+
+	_, err := io.WriteString(w, out.String())
+
+	return err
+}
+
+// exportCSV uses the same columns as an import, so that the hosts can be imported elsewhere
+func exportCSV(w io.Writer, inv *Inventory, hostVars map[string]map[string]interface{}) error {
+	cw := csv.NewWriter(w)
+
+	if err := cw.Write([]string{"name", "groups", "ansible_host", "ansible_port", "ansible_user"}); err != nil {
+		return err
+	}
+
+	for _, h := range inv.HostNames() {
+		groups := []string{}
+		for _, g := range inv.Hosts[h].Groups {
+			if g != allGroup && g != ungroupedGroup {
+				groups = append(groups, g)
+			}
+		}
+
+		vars := hostVars[h]
+		row := []string{
+			h,
+			strings.Join(groups, ";"),
+			stringVar(vars, "ansible_host", "ansible_ssh_host"),
+			stringVar(vars, "ansible_port", "ansible_ssh_port"),
+			stringVar(vars, "ansible_user", "ansible_ssh_user"),
+		}
+
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+
+	return cw.Error()
+}
+
+// exportSSHConfig writes a Host entry for each host that Ansible connects to via ssh
+func exportSSHConfig(w io.Writer, inv *Inventory, hostVars map[string]map[string]interface{}) error {
+	return writeSSHHosts(w, sshHosts(inv, hostVars, ""))
+}
+
+// exportConnectJSON writes the inventory overrides for the connection tool, along with the
+// address of the first monitor; the result can be merged into connect-py.json
+func exportConnectJSON(w io.Writer, inv *Inventory, hostVars map[string]map[string]interface{}) error {
+	hosts := map[string]interface{}{}
+
+	for _, h := range inv.HostNames() {
+		vars := hostVars[h]
+		address := stringVar(vars, "ansible_host", "ansible_ssh_host")
+		entry := map[string]interface{}{}
+
+		ssh := map[string]interface{}{}
+		if address != "" {
+			ssh["address"] = address
+		}
+
+		if p, err := strconv.Atoi(stringVar(vars, "ansible_port", "ansible_ssh_port")); err == nil && p != defaultSSHPort {
+			ssh["custom_labels"] = map[string]interface{}{"port": p}
+		}
+
+		if len(ssh) > 0 {
+			entry["ssh"] = ssh
+		}
+
+		groups := inv.HostGroups(h)
+		for _, svc := range connectServices {
+			if !slices.Contains(groups, svc) {
+				continue
+			}
+
+			service := map[string]interface{}{}
+			payload, _ := vars["pmm_payload_add_"+svc].(map[string]interface{})
+
+			if a := stringVar(payload, "address"); a != "" {
+				service["address"] = a
+			} else if address != "" {
+				service["address"] = address
+			}
+
+			if p, err := strconv.Atoi(stringVar(payload, "port")); err == nil {
+				service["port"] = p
+			}
+
+			if len(service) > 0 {
+				entry[svc] = service
+			}
+		}
+
+		if len(entry) > 0 {
+			hosts[h] = entry
+		}
+	}
+
+	out := map[string]interface{}{
+		"inventory": map[string]interface{}{"source": "pmm", "hosts": hosts},
+	}
+
+	if monitors := inv.GroupHosts("monitors"); len(monitors) > 0 {
+		vars := hostVars[monitors[0]]
+
+		if address := stringVar(vars, "pmm_server_host_public", "ansible_host", "ansible_ssh_host"); address != "" {
+			port := defaultPMMServerPort
+			if p, err := strconv.Atoi(stringVar(vars, "pmm_server_port", "pmm_container_port")); err == nil {
+				port = p
+			}
+
+			out["server_address"] = fmt.Sprintf("https://%s:%d", address, port)
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(out)
+}
+
+// exportInventory writes the resolved inventory, with the variables for each host after merging
+func exportInventory(w io.Writer, inv *Inventory, format string, secrets string) error {
+	hostVars := map[string]map[string]interface{}{}
+	for _, h := range inv.HostNames() {
+		hostVars[h] = filterSecrets(inv.HostVars(h), secrets)
+	}
+
+	switch format {
+	case "connect-json":
+		return exportConnectJSON(w, inv, hostVars)
+	case "csv":
+		return exportCSV(w, inv, hostVars)
+	case "ini":
+		return exportINI(w, inv, hostVars)
+	case "json":
+		return exportJSON(w, inv, hostVars)
+	case "ssh-config":
+		return exportSSHConfig(w, inv, hostVars)
+	case "yaml":
+		return exportYAML(w, inv, hostVars)
+	default:
+		return fmt.Errorf("unknown format '%s', expected one of %s", format, strings.Join(inventoryExportFormats, ", "))
+	}
+}
+
+func inventoryExportCommand(args []string) int {
+	fs := subcommandFlags("inventory export", "[--format FORMAT] [--secrets keep|redact|strip] [--output FILE]")
+	format := fs.String("format", "yaml", "Output format, one of "+strings.Join(inventoryExportFormats, ", "))
+	inventory := fs.String("inventory", os.Getenv("ANSIBLE_INVENTORY"), "Comma-separated inventory sources [ANSIBLE_INVENTORY]")
+	output := fs.String("output", "", "Write to a file rather than stdout")
+	secrets := fs.String("secrets", "redact", "Handling of vault values and variables such as passwords, one of "+strings.Join(inventorySecretModes, ", "))
+	args = parseSubcommandFlags(fs, args)
+
+	if len(args) > 0 || !slices.Contains(inventorySecretModes, *secrets) {
+		fs.Usage()
+		return 1
+	}
+
+	if *inventory == "" {
+		Logger.Error("an inventory is required")
+		return 1
+	}
+
+	inv, err := loadInventory(*inventory)
+	if err != nil {
+		Logger.Error("unable to load the inventory: %v", err)
+		return 1
+	}
+
+	buf := bytes.Buffer{}
+	if err := exportInventory(&buf, inv, *format, *secrets); err != nil {
+		Logger.Error("unable to export the inventory: %v", err)
+		return 1
+	}
+
+	if *output == "" {
+		fmt.Print(buf.String())
+		return 0
+	}
+
+	if err := writeFileAtomic(*output, buf.Bytes(), 0o600); err != nil {
+		Logger.Error("unable to write '%s': %v", *output, err)
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const inventoryExportDummy = `---
+all:
+  children:
+    monitors:
+      hosts:
+        monitor:
+          ansible_host: 10.0.0.10
+    mysql:
+      hosts:
+        db1:
+          ansible_host: 10.0.0.1
+          ansible_port: 2222
+          ansible_ssh_common_args: -o ProxyJump=bastion
+          pmm_payload_add_mysql:
+            port: 3307
+            password: s3cret
+        db2:
+      vars:
+        mysql_password: !vault |
+          $ANSIBLE_VAULT;1.1;AES256
+          3630373835
+        note: a b
+...
+`
+
+func TestFilterSecrets(t *testing.T) {
+	vars := map[string]interface{}{
+		"ansible_ssh_private_key_file": "~/.ssh/id_ed25519",
+		"api_token":                    "abc",
+		"nested":                       map[string]interface{}{"aws_secret_key": "xyz", "region": "eu-west-1"},
+		"vaulted":                      VaultValue{Ciphertext: vaultHeader},
+	}
+
+	redacted := filterSecrets(vars, "redact")
+	if redacted["api_token"] != redactedValue || redacted["vaulted"] != redactedValue {
+		t.Fatalf("expected the secrets to be redacted, got %v", redacted)
+	}
+
+	if redacted["ansible_ssh_private_key_file"] != "~/.ssh/id_ed25519" {
+		t.Fatalf("expected the path to the key to be kept, got %v", redacted)
+	}
+
+	stripped := filterSecrets(vars, "strip")
+	if _, ok := stripped["api_token"]; ok {
+		t.Fatalf("expected api_token to be stripped, got %v", stripped)
+	}
+
+	if nested := stripped["nested"].(map[string]interface{}); len(nested) != 1 || nested["region"] != "eu-west-1" {
+		t.Fatalf("expected nested secrets to be stripped, got %v", nested)
+	}
+
+	if kept := filterSecrets(vars, "keep"); kept["api_token"] != "abc" {
+		t.Fatalf("expected the secrets to be kept, got %v", kept)
+	}
+}
+
+func TestExportInventory(t *testing.T) {
+	dir := writeInventoryFiles(t, map[string]string{
+		"hosts.yaml": inventoryExportDummy,
+		"hosts.ini":  "standalone ansible_host=10.0.0.9\n",
+	})
+
+	inv, err := loadInventory(filepath.Join(dir, "hosts.yaml") + "," + filepath.Join(dir, "hosts.ini"))
+	if err != nil {
+		t.Fatalf("failed to loadInventory: %v", err)
+	}
+
+	export := func(format string, secrets string) string {
+		buf := bytes.Buffer{}
+
+		if err := exportInventory(&buf, inv, format, secrets); err != nil {
+			t.Fatalf("failed to export as %s: %v", format, err)
+		}
+
+		return buf.String()
+	}
+
+	// The export is an inventory with the same hosts, groups and variables
+	for _, format := range []string{"ini", "json", "yaml"} {
+		name := "export." + format
+		if format == "json" {
+			name = "export"
+		}
+
+		content := export(format, "redact")
+		if format == "json" {
+			content = "#!/bin/sh\ncat <<'EOF'\n" + content + "EOF\n"
+		}
+
+		exported, err := loadInventory(filepath.Join(writeInventoryFiles(t, map[string]string{name: content}), name))
+		if err != nil {
+			t.Fatalf("failed to load the %s export: %v\n%s", format, err, content)
+		}
+
+		if hosts := exported.GroupHosts("mysql"); strings.Join(hosts, ",") != "db1,db2" {
+			t.Fatalf("expected db1 and db2 in mysql for %s, got %v", format, hosts)
+		}
+
+		// Values from INI are strings, as Ansible only converts them when used
+		vars := exported.HostVars("db1")
+		if fmt.Sprint(vars["ansible_port"]) != "2222" || vars["note"] != "a b" || vars["mysql_password"] != redactedValue {
+			t.Fatalf("expected the resolved variables for db1 from %s, got %v", format, vars)
+		}
+
+		if groups := exported.Hosts["standalone"].Groups; !slices.Contains(groups, ungroupedGroup) {
+			t.Fatalf("expected standalone to be ungrouped for %s, got %v", format, groups)
+		}
+	}
+
+	if !strings.Contains(export("yaml", "keep"), "mysql_password: !vault |") {
+		t.Fatalf("expected the vault value to be kept")
+	}
+
+	buf := bytes.Buffer{}
+	if err := exportInventory(&buf, inv, "ini", "keep"); err == nil {
+		t.Fatalf("expected an error for a vault value in INI")
+	}
+
+	expected := "name,groups,ansible_host,ansible_port,ansible_user\n" +
+		"db1,mysql,10.0.0.1,2222,\n" +
+		"db2,mysql,,,\n" +
+		"monitor,monitors,10.0.0.10,,\n" +
+		"standalone,,10.0.0.9,,\n"
+	if csv := export("csv", "strip"); csv != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, csv)
+	}
+
+	// Imports only accept the groups for database hosts
+	if hosts, err := importCSV(strings.NewReader(strings.Replace(expected, "monitor,monitors,10.0.0.10,,\n", "", 1)), "mysql"); err != nil || len(hosts) != 3 {
+		t.Fatalf("expected the CSV to be imported, got %v: %v", hosts, err)
+	}
+
+	expected = "Host db1\n  HostName 10.0.0.1\n  Port 2222\n  ProxyJump bastion\n\nHost db2\n"
+	if cfg := export("ssh-config", "strip"); !strings.HasPrefix(cfg, expected) {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, cfg)
+	}
+
+	connect := struct {
+		Inventory struct {
+			Hosts  map[string]map[string]map[string]interface{} `json:"hosts"`
+			Source string                                       `json:"source"`
+		} `json:"inventory"`
+		ServerAddress string `json:"server_address"`
+	}{}
+
+	if err := json.Unmarshal([]byte(export("connect-json", "strip")), &connect); err != nil {
+		t.Fatalf("failed to parse connect-json: %v", err)
+	}
+
+	if connect.ServerAddress != "https://10.0.0.10:8443" {
+		t.Fatalf("expected the server address for the monitor, got %s", connect.ServerAddress)
+	}
+
+	db1 := connect.Inventory.Hosts["db1"]
+	if db1["mysql"]["port"] != 3307.0 || db1["ssh"]["address"] != "10.0.0.1" {
+		t.Fatalf("expected the overrides for db1, got %v", db1)
+	}
+
+	if err := exportInventory(&buf, inv, "toml", "keep"); err == nil {
+		t.Fatalf("expected an error for an unknown format")
+	}
+}
@@ -0,0 +1,478 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ImportedHost is a host read from an import source, along with its groups and connection vars
+type ImportedHost struct {
+	Groups []string
+	Name   string
+	Vars   map[string]interface{}
+}
+
+// importGroupAliases maps the names used for services to the inventory groups
+var importGroupAliases = map[string]string{
+	"haproxy":    "haproxy",
+	"mariadb":    "mysql",
+	"mongo":      "mongodb",
+	"mongodb":    "mongodb",
+	"mysql":      "mysql",
+	"percona":    "mysql",
+	"pg":         "postgresql",
+	"postgres":   "postgresql",
+	"postgresql": "postgresql",
+	"proxysql":   "proxysql",
+}
+
+// importInferPatterns are checked in order against host names without a known group
+var importInferPatterns = []string{"proxysql", "haproxy", "mariadb", "mysql", "postgres", "mongo"}
+
+// importCSVColumns maps the CSV header to the fields for a host
+var importCSVColumns = map[string]string{
+	"address":            "ansible_host",
+	"ansible_host":       "ansible_host",
+	"ansible_port":       "ansible_port",
+	"ansible_user":       "ansible_user",
+	"engine":             "group",
+	"group":              "group",
+	"groups":             "group",
+	"host":               "name",
+	"hostname":           "ansible_host",
+	"inventory_hostname": "name",
+	"ip":                 "ansible_host",
+	"name":               "name",
+	"port":               "ansible_port",
+	"service":            "group",
+	"type":               "group",
+	"user":               "ansible_user",
+	"username":           "ansible_user",
+}
+
+// importSSHKeywords maps ssh_config keywords to Ansible connection vars
+var importSSHKeywords = map[string]string{
+	"hostname":     "ansible_host",
+	"identityfile": "ansible_ssh_private_key_file",
+	"port":         "ansible_port",
+	"proxyjump":    "ansible_ssh_common_args",
+	"user":         "ansible_user",
+}
+
+// importPMMNodeGroups maps the types of remote nodes in PMM to cloud groups
+var importPMMNodeGroups = map[string]string{
+	"remote_azure_database": "azure",
+	"remote_rds":            "rds",
+}
+
+func importGroup(name string) (string, bool) {
+	g, ok := importGroupAliases[strings.ToLower(strings.TrimSpace(name))]
+	return g, ok
+}
+
+func inferImportGroup(host string) string {
+	lower := strings.ToLower(host)
+
+	for _, p := range importInferPatterns {
+		if strings.Contains(lower, p) {
+			return importGroupAliases[p]
+		}
+	}
+
+	return ""
+}
+
+func importValue(name string, value string) interface{} {
+	if name == "ansible_port" {
+		if port, err := strconv.Atoi(value); err == nil {
+			return port
+		}
+	}
+
+	return value
+}
+
+func (h *ImportedHost) addGroup(group string) {
+	if group != "" && !slices.Contains(h.Groups, group) {
+		h.Groups = append(h.Groups, group)
+	}
+}
+
+// importCSV reads hosts from a CSV file with a header, e.g. name,address,port,user,type
+func importCSV(r io.Reader, defaultGroup string) ([]ImportedHost, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the header: %w", err)
+	}
+
+	fields := make([]string, len(header))
+	for i, col := range header {
+		col = strings.ToLower(strings.TrimSpace(col))
+		if f, ok := importCSVColumns[col]; ok {
+			fields[i] = f
+		} else {
+			fields[i] = col
+		}
+	}
+
+	if !slices.Contains(fields, "name") {
+		return nil, fmt.Errorf("the header needs a name or host column")
+	}
+
+	hosts := []ImportedHost{}
+
+	for {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		line, _ := reader.FieldPos(0)
+		h := ImportedHost{Vars: map[string]interface{}{}}
+
+		for i, value := range row {
+			value = strings.TrimSpace(value)
+			if i >= len(fields) || value == "" {
+				continue
+			}
+
+			switch fields[i] {
+			case "name":
+				h.Name = value
+			case "group":
+				for _, g := range strings.FieldsFunc(value, func(r rune) bool { return r == ';' || r == '|' || r == ' ' }) {
+					group, ok := importGroup(g)
+					if !ok {
+						return nil, fmt.Errorf("line %d: unknown group '%s'", line, g)
+					}
+
+					h.addGroup(group)
+				}
+			default:
+				h.Vars[fields[i]] = importValue(fields[i], value)
+			}
+		}
+
+		if h.Name == "" {
+			return nil, fmt.Errorf("line %d: a name is required", line)
+		}
+
+		hosts = append(hosts, h)
+	}
+
+	return resolveImportGroups(hosts, defaultGroup), nil
+}
+
+// importSSHConfig reads the Host entries from ssh_config, ignoring patterns and Match blocks
+func importSSHConfig(r io.Reader, defaultGroup string) ([]ImportedHost, error) {
+	hosts := []ImportedHost{}
+	current := []int{}
+	scanner := bufio.NewScanner(r)
+
+	for line := 1; scanner.Scan(); line++ {
+		ln := strings.TrimSpace(scanner.Text())
+		if ln == "" || strings.HasPrefix(ln, "#") {
+			continue
+		}
+
+		keyword, value, _ := strings.Cut(strings.Replace(ln, "=", " ", 1), " ")
+		keyword = strings.ToLower(keyword)
+		value = strings.Trim(strings.TrimSpace(value), `"`)
+
+		switch keyword {
+		case "host":
+			current = []int{}
+
+			for _, name := range strings.Fields(value) {
+				if strings.ContainsAny(name, "*?!") {
+					continue
+				}
+
+				current = append(current, len(hosts))
+				hosts = append(hosts, ImportedHost{Name: name, Vars: map[string]interface{}{}})
+			}
+		case "match":
+			current = []int{}
+		case "include":
+			Logger.Warning("line %d: Include is not supported when importing, '%s' has been ignored", line, value)
+		default:
+			name, ok := importSSHKeywords[keyword]
+			if !ok {
+				continue
+			}
+
+			if keyword == "proxyjump" {
+				value = "-o ProxyJump=" + value
+			}
+
+			// As per ssh, the first value is used
+			for _, i := range current {
+				if _, exists := hosts[i].Vars[name]; !exists {
+					hosts[i].Vars[name] = importValue(name, value)
+				}
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return resolveImportGroups(hosts, defaultGroup), nil
+}
+
+// importPMM reads the nodes and services registered with PMM Server
+func importPMM(c *PMMClient) ([]ImportedHost, error) {
+	nodes, err := c.ListNodes()
+	if err != nil {
+		return nil, err
+	}
+
+	services, err := c.ListServices()
+	if err != nil {
+		return nil, err
+	}
+
+	byID := map[string]PMMNode{}
+	for _, n := range nodes {
+		byID[n.NodeID] = n
+	}
+
+	hosts := []ImportedHost{}
+	index := map[string]int{}
+
+	for _, s := range services {
+		n, ok := byID[s.NodeID]
+		if !ok || n.NodeID == pmmServerNodeID {
+			continue
+		}
+
+		group, ok := importGroup(s.ServiceType)
+		if !ok {
+			Logger.Warning("service '%s' has been ignored as '%s' is not supported", s.ServiceName, s.ServiceType)
+			continue
+		}
+
+		if n.NodeType == "remote" {
+			Logger.Warning("service '%s' has been ignored as remote nodes are not supported", s.ServiceName)
+			continue
+		}
+
+		i, ok := index[n.NodeName]
+		if !ok {
+			i = len(hosts)
+			index[n.NodeName] = i
+			hosts = append(hosts, ImportedHost{Name: n.NodeName, Vars: map[string]interface{}{}})
+		}
+
+		h := &hosts[i]
+		h.addGroup(importPMMNodeGroups[n.NodeType])
+		h.addGroup(group)
+
+		if address := n.Address; address != "" {
+			h.Vars["ansible_host"] = address
+		} else if s.Address != "" {
+			h.Vars["ansible_host"] = s.Address
+		}
+	}
+
+	return hosts, nil
+}
+
+// resolveImportGroups infers a group for hosts without one, or uses the default
+func resolveImportGroups(hosts []ImportedHost, defaultGroup string) []ImportedHost {
+	for i := range hosts {
+		if len(hosts[i].Groups) > 0 {
+			continue
+		}
+
+		if defaultGroup != "" {
+			hosts[i].addGroup(defaultGroup)
+		} else {
+			hosts[i].addGroup(inferImportGroup(hosts[i].Name))
+		}
+	}
+
+	return hosts
+}
+
+// readImportSource reads hosts from csv:FILE, ssh-config:FILE or pmm:URL
+func readImportSource(source string, defaultGroup string, credentials string, insecure bool) ([]ImportedHost, error) {
+	kind, location, ok := strings.Cut(source, ":")
+	if !ok || location == "" {
+		return nil, fmt.Errorf("expected csv:FILE, ssh-config:FILE or pmm:URL, got '%s'", source)
+	}
+
+	switch kind {
+	case "csv", "ssh-config":
+		f, err := os.Open(expandHome(location))
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		if kind == "csv" {
+			return importCSV(f, defaultGroup)
+		}
+
+		return importSSHConfig(f, defaultGroup)
+	case "pmm":
+		c, err := newPMMClient(location, credentials, insecure)
+		if err != nil {
+			return nil, err
+		}
+
+		return importPMM(c)
+	default:
+		return nil, fmt.Errorf("unknown import source '%s'", kind)
+	}
+}
+
+func importVarNodes(vars map[string]interface{}, skip func(string) bool) ([][2]*yaml.Node, error) {
+	nodes := [][2]*yaml.Node{}
+
+	for _, name := range sortedKeys(vars) {
+		if skip(name) {
+			continue
+		}
+
+		value := &yaml.Node{}
+		if err := value.Encode(vars[name]); err != nil {
+			return nil, err
+		}
+
+		nodes = append(nodes, [2]*yaml.Node{{Kind: yaml.ScalarNode, Tag: "!!str", Value: name}, value})
+	}
+
+	return nodes, nil
+}
+
+// mergeImportedHosts adds the hosts to their groups, keeping any existing variables
+func mergeImportedHosts(e *InventoryEditor, hosts []ImportedHost) error {
+	for _, h := range hosts {
+		if len(h.Groups) == 0 {
+			Logger.Warning("host '%s' has been ignored as the group is unknown, use --group to set one", h.Name)
+			continue
+		}
+
+		for _, g := range h.Groups {
+			if len(e.hostNodes(h.Name, g)) == 0 {
+				if err := e.AddHost(g, h.Name, nil); err != nil {
+					return err
+				}
+			}
+		}
+
+		existing, err := e.selectHostNode(h.Name, "")
+		if err != nil {
+			return err
+		}
+
+		vars, err := importVarNodes(h.Vars, func(name string) bool {
+			return existing.Value.Kind == yaml.MappingNode && yamlMappingIndex(existing.Value, name) >= 0
+		})
+		if err != nil {
+			return err
+		}
+
+		if len(vars) > 0 {
+			if err := e.SetVars(h.Name, existing.Group, vars); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func inventoryImportCommand(args []string) int {
+	fs := subcommandFlags("inventory import", "--from csv:FILE|ssh-config:FILE|pmm:URL [--group GROUP] [--dry-run] [--yes]")
+	credentials := fs.String("credentials", "", "Credentials for PMM Server, as username:password or a token [GASCAN_PMM_CREDENTIALS]")
+	dryRun := fs.Bool("dry-run", false, "Show the changes without saving them")
+	file := fs.String("file", "", "The YAML inventory to update, the first YAML file in the inventory is used when empty")
+	from := fs.String("from", "", "Source of the hosts, i.e. csv:FILE, ssh-config:FILE or pmm:URL")
+	group := fs.String("group", "", "Group for hosts when it cannot be determined from the source")
+	insecure := fs.Bool("insecure", false, "Skip verification of the certificate for PMM Server")
+	inventory := fs.String("inventory", os.Getenv("ANSIBLE_INVENTORY"), "Comma-separated inventory sources, used for validation [ANSIBLE_INVENTORY]")
+	monitor := fs.String("monitor", "monitor", "Monitor alias")
+	skipValidation := fs.Bool("skip-validation", false, "Save the changes without validating the inventory")
+	yes := fs.Bool("yes", false, "Save the changes without asking for confirmation")
+	args = parseSubcommandFlags(fs, args)
+
+	if *from == "" || len(args) > 0 {
+		fs.Usage()
+		return 1
+	}
+
+	if *file == "" {
+		if *file = defaultInventoryFile(*inventory); *file == "" {
+			Logger.Error("unable to find a YAML inventory to update, use --file or --inventory")
+			return 1
+		}
+	}
+
+	hosts, err := readImportSource(*from, *group, *credentials, *insecure)
+	if err != nil {
+		Logger.Error("unable to import from '%s': %v", *from, err)
+		return 1
+	}
+
+	e, err := openInventoryEditor(*file)
+	if errors.Is(err, os.ErrNotExist) {
+		e, err = newInventoryEditor(*file), nil
+	}
+
+	if err != nil {
+		Logger.Error("unable to open the inventory: %v", err)
+		return 1
+	}
+
+	if err := mergeImportedHosts(e, hosts); err != nil {
+		Logger.Error("unable to merge the hosts: %v", err)
+		return 1
+	}
+
+	diff, err := e.Diff()
+	if err != nil {
+		Logger.Error("%v", err)
+		return 1
+	}
+
+	if diff == "" {
+		fmt.Println("The inventory is already up to date")
+		return 0
+	}
+
+	fmt.Print(diff)
+
+	if *dryRun || (!*yes && !confirm("Save the changes to '"+e.File+"'?", bufio.NewReader(os.Stdin))) {
+		fmt.Println("No changes saved")
+		return 0
+	}
+
+	if err := e.Save(*inventory, *monitor, !*skipValidation); err != nil {
+		Logger.Error("unable to save '%s': %v", e.File, err)
+		return 1
+	}
+
+	fmt.Printf("Updated '%s' with %d host(s)\n", e.File, len(hosts))
+
+	return 0
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const importDummyCSV = `name,address,port,user,type,datacenter
+db1,10.0.0.1,22,admin,mysql,dc1
+db2,10.0.0.2,,,postgres;pg,dc2
+lb1,10.0.0.3,,,,dc1
+`
+
+const importDummySSHConfig = `# Managed hosts
+Host *
+  User nobody
+
+Host db1 mongo-db3
+  HostName 10.0.0.1
+  Port 2222
+  User admin
+
+Host db4
+  HostName=10.0.0.4
+  HostName 10.0.0.5
+  ProxyJump bastion
+
+Match host *.example.com
+  User ignored
+`
+
+func TestImportHosts(t *testing.T) {
+	hosts, err := importCSV(strings.NewReader(importDummyCSV), "")
+	if err != nil {
+		t.Fatalf("failed to importCSV: %v", err)
+	}
+
+	expected := []ImportedHost{
+		{Name: "db1", Groups: []string{"mysql"}, Vars: map[string]interface{}{"ansible_host": "10.0.0.1", "ansible_port": 22, "ansible_user": "admin", "datacenter": "dc1"}},
+		{Name: "db2", Groups: []string{"postgresql"}, Vars: map[string]interface{}{"ansible_host": "10.0.0.2", "datacenter": "dc2"}},
+		{Name: "lb1", Groups: nil, Vars: map[string]interface{}{"ansible_host": "10.0.0.3", "datacenter": "dc1"}},
+	}
+
+	if !reflect.DeepEqual(hosts, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, hosts)
+	}
+
+	if _, err := importCSV(strings.NewReader("name,type\ndb1,oracle\n"), ""); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("expected an error for an unknown group on line 2, got %v", err)
+	}
+
+	hosts, err = importSSHConfig(strings.NewReader(importDummySSHConfig), "")
+	if err != nil {
+		t.Fatalf("failed to importSSHConfig: %v", err)
+	}
+
+	expected = []ImportedHost{
+		{Name: "db1", Groups: nil, Vars: map[string]interface{}{"ansible_host": "10.0.0.1", "ansible_port": 2222, "ansible_user": "admin"}},
+		{Name: "mongo-db3", Groups: []string{"mongodb"}, Vars: map[string]interface{}{"ansible_host": "10.0.0.1", "ansible_port": 2222, "ansible_user": "admin"}},
+		{Name: "db4", Groups: nil, Vars: map[string]interface{}{"ansible_host": "10.0.0.4", "ansible_ssh_common_args": "-o ProxyJump=bastion"}},
+	}
+
+	if !reflect.DeepEqual(hosts, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, hosts)
+	}
+
+	srv := newPMMDummyServer(t)
+	defer srv.Close()
+
+	hosts, err = readImportSource("pmm:http://admin:s3cret@"+srv.Listener.Addr().String(), "", "", false)
+	if err != nil {
+		t.Fatalf("failed to import from PMM: %v", err)
+	}
+
+	expected = []ImportedHost{
+		{Name: "db1", Groups: []string{"mysql", "proxysql"}, Vars: map[string]interface{}{"ansible_host": "10.0.0.1"}},
+		{Name: "rds1", Groups: []string{"rds", "mysql"}, Vars: map[string]interface{}{"ansible_host": "rds1.abc.eu-west-1.rds.amazonaws.com"}},
+	}
+
+	if !reflect.DeepEqual(hosts, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, hosts)
+	}
+
+	if _, err := readImportSource("ldap:example", "", "", false); err == nil {
+		t.Fatalf("expected an error for an unknown source")
+	}
+}
+
+func TestMergeImportedHosts(t *testing.T) {
+	file := filepath.Join(writeInventoryFiles(t, map[string]string{"hosts.yaml": inventoryEditDummy}), "hosts.yaml")
+
+	e, err := openInventoryEditor(file)
+	if err != nil {
+		t.Fatalf("failed to openInventoryEditor: %v", err)
+	}
+
+	hosts, _ := importCSV(strings.NewReader(importDummyCSV), "haproxy")
+	if err := mergeImportedHosts(e, hosts); err != nil {
+		t.Fatalf("failed to mergeImportedHosts: %v", err)
+	}
+
+	diff, err := e.Diff()
+	if err != nil {
+		t.Fatalf("failed to Diff: %v", err)
+	}
+
+	expected := fmt.Sprintf(`--- %[1]s
++++ %[1]s
+@@ -12,8 +12,19 @@
+           secret: !vault |
+             $ANSIBLE_VAULT;1.1;AES256
+             3630373835
++          ansible_port: 22
++          ansible_user: admin
++          datacenter: dc1
+       vars:
+         mysql_port: 3306
+     postgresql:
+       hosts:
++        db2:
++          ansible_host: 10.0.0.2
++          datacenter: dc2
++    haproxy:
++      hosts:
++        lb1:
++          ansible_host: 10.0.0.3
++          datacenter: dc1
+ ...
+`, file)
+
+	if diff != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, diff)
+	}
+
+	if err := e.Save(file, "monitor", true); err != nil {
+		t.Fatalf("failed to Save: %v", err)
+	}
+
+	// A new inventory is started when the file does not exist
+	e = newInventoryEditor(filepath.Join(filepath.Dir(file), "new.yaml"))
+	if err := mergeImportedHosts(e, hosts); err != nil {
+		t.Fatalf("failed to mergeImportedHosts: %v", err)
+	}
+
+	if data, _ := e.Bytes(); !strings.HasPrefix(string(data), "---\nall:\n  children:\n    mysql:\n      hosts:\n        db1:\n") {
+		t.Fatalf("expected a new inventory, got:\n%s", data)
+	}
+}
+
+func TestMergeImportedHostsLayout(t *testing.T) {
+	original := `---
+all:
+  children:
+    monitors:
+      hosts:
+        monitor:
+          ansible_host: 10.0.0.10   # the PMM box
+
+    mysql:
+      hosts:
+        db1:
+          ansible_host: 10.0.0.1    # primary
+          ansible_port: 22
+          ansible_user: admin
+          datacenter: dc1
+...
+`
+	file := filepath.Join(writeInventoryFiles(t, map[string]string{"hosts.yaml": original}), "hosts.yaml")
+
+	e, err := openInventoryEditor(file)
+	if err != nil {
+		t.Fatalf("failed to openInventoryEditor: %v", err)
+	}
+
+	hosts, _ := importCSV(strings.NewReader(importDummyCSV), "haproxy")
+	if err := mergeImportedHosts(e, hosts[:1]); err != nil {
+		t.Fatalf("failed to mergeImportedHosts: %v", err)
+	}
+
+	// Hosts that are already up to date do not show the formatting of the encoder as changes
+	if diff, err := e.Diff(); err != nil || diff != "" {
+		t.Fatalf("expected no differences, got:\n%s", diff)
+	}
+
+	if err := mergeImportedHosts(e, hosts[2:]); err != nil {
+		t.Fatalf("failed to mergeImportedHosts: %v", err)
+	}
+
+	diff, _ := e.Diff()
+	expected := fmt.Sprintf(`--- %[1]s
++++ %[1]s
+@@ -13,4 +13,9 @@
+           ansible_port: 22
+           ansible_user: admin
+           datacenter: dc1
++    haproxy:
++      hosts:
++        lb1:
++          ansible_host: 10.0.0.3
++          datacenter: dc1
+ ...
+`, file)
+
+	if diff != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, diff)
+	}
+}
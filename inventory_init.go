@@ -0,0 +1,256 @@
+package main
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+// inventoryInitGroups are the groups that hosts can be added to via flags
+var inventoryInitGroups = []string{"azure", "cloudsql", "haproxy", "mongodb", "mysql", "postgresql", "proxysql", "rds"}
+
+// InventoryInit describes the inventory to generate without an editor
+type InventoryInit struct {
+	ContainerEngine string
+	GroupHosts      map[string][]string
+	GroupVars       []string
+	HostVars        []string
+	Monitor         string
+	MonitorHost     string
+	PMMVersion      string
+	Vars            []string
+}
+
+// renderDefaultInventory renders the default inventory from the bundle for the monitor
+func renderDefaultInventory(monitor string) ([]byte, error) {
+	files, err := readBundle(bundle)
+	if err != nil {
+		return nil, err
+	}
+
+	idx := slices.IndexFunc(files, func(f BundleFile) bool { return f.Name == defaultInventory && f.Type == tar.TypeReg })
+	if idx < 0 {
+		return nil, fmt.Errorf("unable to locate '%s' in the bundle", defaultInventory)
+	}
+
+	tmpl, err := template.New(defaultInventory).Parse(string(files[idx].Content))
+	if err != nil {
+		return nil, err
+	}
+
+	return renderTemplate(&ansibleInventory{Config: Flags{Monitor: monitor}}, tmpl)
+}
+
+// splitScopedVar splits SCOPE:NAME=VALUE, where the scope is a group or host
+func splitScopedVar(v string) (string, string, error) {
+	scope, nameValue, ok := strings.Cut(v, ":")
+	if !ok || scope == "" || !strings.Contains(nameValue, "=") {
+		return "", "", fmt.Errorf("expected SCOPE:NAME=VALUE, got '%s'", v)
+	}
+
+	return scope, nameValue, nil
+}
+
+func isLocalAddress(address string) bool {
+	return slices.Contains([]string{"localhost", "127.0.0.1", "::1"}, address)
+}
+
+// Apply adds the hosts and variables to the default inventory
+func (i InventoryInit) Apply(e *InventoryEditor, password []byte) error {
+	if i.MonitorHost != "" && !isLocalAddress(i.MonitorHost) {
+		if err := e.UnsetVars(i.Monitor, allGroup, []string{"ansible_connection"}); err != nil {
+			return err
+		}
+
+		vars, _ := inventoryVarNodes([]string{"ansible_host=" + i.MonitorHost}, nil)
+		if err := e.SetVars(i.Monitor, allGroup, vars); err != nil {
+			return err
+		}
+	}
+
+	if i.ContainerEngine != "" {
+		vars, _ := inventoryVarNodes([]string{"container_engine=" + i.ContainerEngine}, nil)
+		if err := e.SetVars(i.Monitor, allGroup, vars); err != nil {
+			return err
+		}
+	}
+
+	if i.PMMVersion != "" {
+		// The version is a string, as per the default inventory
+		version := [][2]*yaml.Node{{
+			{Kind: yaml.ScalarNode, Tag: "!!str", Value: "pmm_version"},
+			{Kind: yaml.ScalarNode, Tag: "!!str", Style: yaml.SingleQuotedStyle, Value: i.PMMVersion},
+		}}
+
+		if err := e.SetVars("", allGroup, version); err != nil {
+			return err
+		}
+	}
+
+	for _, group := range inventoryInitGroups {
+		for _, h := range i.GroupHosts[group] {
+			name, address, _ := strings.Cut(strings.TrimSpace(h), "=")
+			if name == "" {
+				continue
+			}
+
+			vars := [][2]*yaml.Node{}
+			if address != "" {
+				vars, _ = inventoryVarNodes([]string{"ansible_host=" + address}, nil)
+			}
+
+			if err := e.AddHost(group, name, vars); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(i.Vars) > 0 {
+		vars, err := inventoryVarNodes(i.Vars, password)
+		if err != nil {
+			return err
+		}
+
+		if err := e.SetVars("", allGroup, vars); err != nil {
+			return err
+		}
+	}
+
+	for _, scoped := range []struct {
+		Group bool
+		Vars  []string
+	}{{true, i.GroupVars}, {false, i.HostVars}} {
+		for _, v := range scoped.Vars {
+			scope, nameValue, err := splitScopedVar(v)
+			if err != nil {
+				return err
+			}
+
+			vars, err := inventoryVarNodes([]string{nameValue}, password)
+			if err != nil {
+				return err
+			}
+
+			if scoped.Group {
+				err = e.SetVars("", scope, vars)
+			} else {
+				err = e.SetVars(scope, "", vars)
+			}
+
+			if err != nil {
+				return fmt.Errorf("unable to set '%s': %w", v, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func inventoryInitCommand(args []string) int {
+	spec := InventoryInit{GroupHosts: map[string][]string{}}
+
+	fs := subcommandFlags("inventory init", "[--file FILE] [--monitor-host ADDRESS] [--mysql HOSTS] ... [--group-var GROUP:NAME=VALUE]...")
+	fs.StringVar(&spec.ContainerEngine, "container-engine", "", "Container engine for the monitor, e.g. docker or podman")
+	encrypt := fs.Bool("encrypt", false, "Encrypt the values of variables using the password in ANSIBLE_VAULT_PASSWORD_FILE")
+	file := fs.String("file", "inventory.yaml", "The inventory to create, or - for stdout")
+	force := fs.Bool("force", false, "Replace an existing inventory, keeping the original as a backup")
+	fs.StringVar(&spec.Monitor, "monitor", "monitor", "Monitor alias")
+	fs.StringVar(&spec.MonitorHost, "monitor-host", "", "Address of the monitor, which is deployed locally when empty")
+	fs.StringVar(&spec.PMMVersion, "pmm-version", "", "Major version of PMM, e.g. 2 or 3")
+	skipValidation := fs.Bool("skip-validation", false, "Create the inventory without validating it")
+
+	for _, group := range inventoryInitGroups {
+		fs.Func(group, "Comma-separated hosts for "+group+", as NAME or NAME=ADDRESS, can be repeated", func(s string) error {
+			spec.GroupHosts[group] = append(spec.GroupHosts[group], strings.Split(s, ",")...)
+			return nil
+		})
+	}
+
+	fs.Func("group-var", "Variable for a group as GROUP:NAME=VALUE, can be repeated", func(s string) error {
+		spec.GroupVars = append(spec.GroupVars, s)
+		return nil
+	})
+	fs.Func("host-var", "Variable for a host as HOST:NAME=VALUE, can be repeated", func(s string) error {
+		spec.HostVars = append(spec.HostVars, s)
+		return nil
+	})
+	fs.Func("var", "Variable for all hosts as NAME=VALUE, can be repeated", func(s string) error {
+		spec.Vars = append(spec.Vars, s)
+		return nil
+	})
+
+	if len(parseSubcommandFlags(fs, args)) > 0 {
+		fs.Usage()
+		return 1
+	}
+
+	var password []byte
+	if *encrypt {
+		if password = inventoryVaultPassword(); password == nil {
+			Logger.Error("a vault password is required to encrypt, please set ANSIBLE_VAULT_PASSWORD_FILE")
+			return 1
+		}
+	}
+
+	data, err := renderDefaultInventory(spec.Monitor)
+	if err != nil {
+		Logger.Error("unable to render the default inventory: %v", err)
+		return 1
+	}
+
+	e := newInventoryEditor(*file)
+	if err := e.load(data); err != nil {
+		Logger.Error("unable to parse the default inventory: %v", err)
+		return 1
+	}
+
+	if *file != "-" {
+		original, err := os.ReadFile(*file)
+
+		switch {
+		case err == nil && !*force:
+			Logger.Error("the inventory '%s' already exists, use --force to replace it", *file)
+			return 1
+		case err == nil:
+			e.original = original
+		case !errors.Is(err, os.ErrNotExist):
+			Logger.Error("unable to read '%s': %v", *file, err)
+			return 1
+		}
+	}
+
+	if err := spec.Apply(e, password); err != nil {
+		Logger.Error("unable to create the inventory: %v", err)
+		return 1
+	}
+
+	if *file == "-" {
+		if data, err = e.Bytes(); err == nil && !*skipValidation {
+			err = e.validate(data, "", spec.Monitor)
+		}
+
+		if err != nil {
+			Logger.Error("%v", err)
+			return 1
+		}
+
+		fmt.Print(string(data))
+
+		return 0
+	}
+
+	if err := e.Save("", spec.Monitor, !*skipValidation); err != nil {
+		Logger.Error("unable to save '%s': %v", *file, err)
+		return 1
+	}
+
+	fmt.Printf("Created '%s'\n", *file)
+
+	return 0
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestInventoryInit(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "inventory.yaml")
+
+	data, err := renderDefaultInventory("monitor")
+	if err != nil {
+		t.Fatalf("failed to renderDefaultInventory: %v", err)
+	}
+
+	e := newInventoryEditor(file)
+	if err := e.load(data); err != nil {
+		t.Fatalf("failed to load: %v", err)
+	}
+
+	spec := InventoryInit{
+		ContainerEngine: "podman",
+		GroupHosts:      map[string][]string{"mysql": {"db1", "db2=10.0.0.2"}, "postgresql": {"pg1"}, "proxysql": {"px1"}},
+		GroupVars:       []string{"mysql:mysql_port=3307"},
+		HostVars:        []string{"db1:ansible_user=admin"},
+		Monitor:         "monitor",
+		MonitorHost:     "10.0.0.5",
+		PMMVersion:      "2",
+		Vars:            []string{"ansible_user=centos"},
+	}
+
+	if err := spec.Apply(e, nil); err != nil {
+		t.Fatalf("failed to Apply: %v", err)
+	}
+
+	if err := e.Save("", "monitor", true); err != nil {
+		t.Fatalf("failed to Save: %v", err)
+	}
+
+	inv, err := loadInventory(file)
+	if err != nil {
+		t.Fatalf("failed to loadInventory: %v", err)
+	}
+
+	monitor := inv.HostVars("monitor")
+	if monitor["ansible_host"] != "10.0.0.5" || monitor["container_engine"] != "podman" || monitor["pmm_version"] != "2" {
+		t.Fatalf("unexpected vars for the monitor: %v", monitor)
+	}
+
+	if _, ok := monitor["ansible_connection"]; ok {
+		t.Fatalf("expected ansible_connection to be removed for a remote monitor")
+	}
+
+	for group, hosts := range map[string][]string{"mysql": {"db1", "db2"}, "postgresql": {"pg1"}, "proxysql": {"px1"}} {
+		for _, h := range hosts {
+			if !slices.Contains(inv.Groups[group].Hosts, h) {
+				t.Fatalf("expected %s in %s, got %v", h, group, inv.Groups[group].Hosts)
+			}
+		}
+	}
+
+	db1, db2 := inv.HostVars("db1"), inv.HostVars("db2")
+	if db1["ansible_user"] != "admin" || db2["ansible_user"] != "centos" || db2["ansible_host"] != "10.0.0.2" || db2["mysql_port"] != 3307 {
+		t.Fatalf("unexpected vars for db1 %v and db2 %v", db1, db2)
+	}
+
+	if err := (InventoryInit{HostVars: []string{"ansible_user=admin"}}).Apply(e, nil); err == nil {
+		t.Fatalf("expected an error for a variable without a scope")
+	}
+
+	// An existing inventory is kept unless forced
+	if _, err := os.Stat(file); err != nil {
+		t.Fatalf("expected the inventory to exist: %v", err)
+	}
+
+	if rc := inventoryInitCommand([]string{"--file", file, "--mysql", "db9"}); rc == 0 {
+		t.Fatalf("expected an existing inventory to be kept")
+	}
+}
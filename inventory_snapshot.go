@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+const defaultChangeThreshold = 20
+
+// InventorySnapshot is the resolved inventory from a deploy, with secrets replaced by digests
+type InventorySnapshot struct {
+	Created  time.Time                         `json:"created"`
+	Groups   map[string]SnapshotGroup          `json:"groups"`
+	Hosts    map[string]map[string]interface{} `json:"hosts"`
+	Playbook string                            `json:"playbook"`
+	Sources  []string                          `json:"sources"`
+}
+
+// SnapshotGroup is the membership of a group
+type SnapshotGroup struct {
+	Children []string `json:"children,omitempty"`
+	Hosts    []string `json:"hosts,omitempty"`
+}
+
+// InventoryChange is a host or group that was added (+), removed (-) or changed (~)
+type InventoryChange struct {
+	Details []string
+	Kind    byte
+	Name    string
+}
+
+// InventoryDiff compares the inventory with the one from the last deploy
+type InventoryDiff struct {
+	Groups   []InventoryChange
+	Hosts    []InventoryChange
+	Previous *InventorySnapshot
+}
+
+func newInventorySnapshot(inv *Inventory, playbook string) *InventorySnapshot {
+	s := &InventorySnapshot{
+		Created:  time.Now().UTC().Truncate(time.Second),
+		Groups:   map[string]SnapshotGroup{},
+		Hosts:    map[string]map[string]interface{}{},
+		Playbook: playbook,
+		Sources:  inv.Sources,
+	}
+
+	for _, name := range inv.GroupNames() {
+		g := inv.Groups[name]
+		s.Groups[name] = SnapshotGroup{Children: g.Children, Hosts: g.Hosts}
+	}
+
+	for _, h := range inv.HostNames() {
+		s.Hosts[h] = filterSecrets(inv.HostVars(h), "digest")
+	}
+
+	return s
+}
+
+// snapshotPath keeps a snapshot for each combination of inventory and monitor
+func snapshotPath(sources string, monitor string) string {
+	sum := sha256.Sum256([]byte(sources))
+
+	return filepath.Join(os.Getenv("HOME"), ".config", "gascan", "snapshots", fmt.Sprintf("%s-%x.json", monitor, sum[:6]))
+}
+
+func readInventorySnapshot(path string) (*InventorySnapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &InventorySnapshot{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("unable to parse the snapshot '%s': %w", path, err)
+	}
+
+	return s, nil
+}
+
+func (s *InventorySnapshot) Write(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, append(data, '\n'), 0o600)
+}
+
+func snapshotValue(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+
+	return string(b)
+}
+
+// diffMembers lists the names that were added to, or removed from, a group
+func diffMembers(kind string, before []string, after []string) []string {
+	details := []string{}
+
+	for _, n := range before {
+		if !slices.Contains(after, n) {
+			details = append(details, fmt.Sprintf("- %s %s", kind, n))
+		}
+	}
+
+	for _, n := range after {
+		if !slices.Contains(before, n) {
+			details = append(details, fmt.Sprintf("+ %s %s", kind, n))
+		}
+	}
+
+	return details
+}
+
+func diffVars(before map[string]interface{}, after map[string]interface{}) []string {
+	details := []string{}
+	names := sortedKeys(after)
+
+	for _, k := range sortedKeys(before) {
+		if _, ok := after[k]; !ok {
+			names = append(names, k)
+		}
+	}
+
+	slices.Sort(names)
+
+	for _, k := range names {
+		b, inBefore := before[k]
+		a, inAfter := after[k]
+
+		switch {
+		case !inBefore:
+			details = append(details, fmt.Sprintf("+ %s: %s", k, snapshotValue(a)))
+		case !inAfter:
+			details = append(details, fmt.Sprintf("- %s: %s", k, snapshotValue(b)))
+		case snapshotValue(a) != snapshotValue(b):
+			details = append(details, fmt.Sprintf("~ %s: %s -> %s", k, snapshotValue(b), snapshotValue(a)))
+		}
+	}
+
+	return details
+}
+
+func diffSnapshots(prev *InventorySnapshot, cur *InventorySnapshot) InventoryDiff {
+	d := InventoryDiff{Previous: prev}
+
+	for _, h := range sortedKeys(prev.Hosts) {
+		if _, ok := cur.Hosts[h]; !ok {
+			d.Hosts = append(d.Hosts, InventoryChange{Kind: '-', Name: h})
+		}
+	}
+
+	for _, h := range sortedKeys(cur.Hosts) {
+		before, ok := prev.Hosts[h]
+		if !ok {
+			d.Hosts = append(d.Hosts, InventoryChange{Kind: '+', Name: h, Details: diffVars(map[string]interface{}{}, cur.Hosts[h])})
+		} else if details := diffVars(before, cur.Hosts[h]); len(details) > 0 {
+			d.Hosts = append(d.Hosts, InventoryChange{Kind: '~', Name: h, Details: details})
+		}
+	}
+
+	for _, g := range sortedKeys(prev.Groups) {
+		if _, ok := cur.Groups[g]; !ok {
+			d.Groups = append(d.Groups, InventoryChange{Kind: '-', Name: g})
+		}
+	}
+
+	for _, g := range sortedKeys(cur.Groups) {
+		before, ok := prev.Groups[g]
+		after := cur.Groups[g]
+		details := append(diffMembers("host", before.Hosts, after.Hosts), diffMembers("child", before.Children, after.Children)...)
+
+		if !ok {
+			d.Groups = append(d.Groups, InventoryChange{Kind: '+', Name: g, Details: details})
+		} else if len(details) > 0 {
+			d.Groups = append(d.Groups, InventoryChange{Kind: '~', Name: g, Details: details})
+		}
+	}
+
+	return d
+}
+
+// Empty checks for any changes
+func (d InventoryDiff) Empty() bool {
+	return len(d.Hosts) == 0 && len(d.Groups) == 0
+}
+
+// hostCount is the number of hosts in either snapshot, i.e. from the last deploy along with
+// those that have been added
+func (d InventoryDiff) hostCount() int {
+	n := len(d.Previous.Hosts)
+
+	for _, c := range d.Hosts {
+		if c.Kind == '+' {
+			n++
+		}
+	}
+
+	return n
+}
+
+// ChangedPercent is the percentage of hosts in either snapshot that have been added, removed
+// or changed
+func (d InventoryDiff) ChangedPercent() int {
+	if len(d.Hosts) == 0 {
+		return 0
+	}
+
+	return len(d.Hosts) * 100 / d.hostCount()
+}
+
+func (d InventoryDiff) String() string {
+	out := strings.Builder{}
+
+	fmt.Fprintf(&out, "Inventory changes since the last deploy of %s at %s:\n", d.Previous.Playbook, d.Previous.Created.Local().Format(time.DateTime))
+
+	for _, section := range []struct {
+		Changes []InventoryChange
+		Kind    string
+	}{{d.Hosts, "host"}, {d.Groups, "group"}} {
+		for _, c := range section.Changes {
+			fmt.Fprintf(&out, "  %c %s %s\n", c.Kind, section.Kind, c.Name)
+
+			for _, detail := range c.Details {
+				fmt.Fprintf(&out, "      %s\n", detail)
+			}
+		}
+	}
+
+	fmt.Fprintf(&out, "%d of %d host(s) changed (%d%%)\n", len(d.Hosts), d.hostCount(), d.ChangedPercent())
+
+	return out.String()
+}
+
+// checkInventoryChanges shows the changes since the last deploy, asking for confirmation when
+// more than the threshold percentage of hosts have changed, and returns the snapshot to save
+// once the deploy succeeds
+func checkInventoryChanges(inv *Inventory, path string, playbook string, threshold int, yes bool, answers *bufio.Reader) (*InventorySnapshot, error) {
+	if inv == nil {
+		Logger.Debug("skipping the comparison with the last deploy")
+		return nil, nil
+	}
+
+	cur := newInventorySnapshot(inv, playbook)
+
+	prev, err := readInventorySnapshot(path)
+	if os.IsNotExist(err) {
+		Logger.Debug("no snapshot found at '%s'", path)
+		return cur, nil
+	}
+
+	if err != nil {
+		Logger.Warning("%v", err)
+		return cur, nil
+	}
+
+	d := diffSnapshots(prev, cur)
+	if d.Empty() {
+		Logger.Info("the inventory is unchanged since the last deploy")
+		return cur, nil
+	}
+
+	fmt.Print(d)
+
+	if d.ChangedPercent() > threshold && !yes && !confirm("The changes exceed the threshold of "+fmt.Sprint(threshold)+"%, continue?", answers) {
+		return nil, fmt.Errorf("the deploy was cancelled as the inventory has changed")
+	}
+
+	return cur, nil
+}
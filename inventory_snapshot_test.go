@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const inventorySnapshotDummy = `---
+all:
+  children:
+    monitors:
+      hosts:
+        monitor:
+          ansible_host: 10.0.0.10
+    mysql:
+      hosts:
+        db1:
+          ansible_host: 10.0.0.1
+          ansible_port: 2222
+        db2:
+          ansible_host: 10.0.0.2
+        db3:
+          ansible_host: 10.0.0.3
+      vars:
+        mysql_password: s3cret
+...
+`
+
+func TestInventorySnapshot(t *testing.T) {
+	dir := writeInventoryFiles(t, map[string]string{
+		"before.yaml": inventorySnapshotDummy,
+		"after.yaml": strings.NewReplacer(
+			"10.0.0.2", "10.0.0.9",
+			"        db3:\n          ansible_host: 10.0.0.3\n", "",
+			"s3cret", "changed",
+			"    mysql:\n", "    postgresql:\n      hosts:\n        db4:\n    mysql:\n",
+		).Replace(inventorySnapshotDummy),
+	})
+
+	before, after := filepath.Join(dir, "before.yaml"), filepath.Join(dir, "after.yaml")
+	snapshot := filepath.Join(dir, "snapshots", "monitor.json")
+
+	beforeInv, err := loadInventory(before)
+	if err != nil {
+		t.Fatalf("failed to loadInventory: %v", err)
+	}
+
+	// Without a previous deploy there is nothing to compare
+	cur, err := checkInventoryChanges(beforeInv, snapshot, "pmm-full.yaml", 0, false, bufio.NewReader(strings.NewReader("")))
+	if err != nil || cur == nil {
+		t.Fatalf("expected a snapshot without any changes, got %v", err)
+	}
+
+	if !strings.HasPrefix(cur.Hosts["db1"]["mysql_password"].(string), "sha256:") {
+		t.Fatalf("expected a digest for the secret, got %v", cur.Hosts["db1"]["mysql_password"])
+	}
+
+	if err := cur.Write(snapshot); err != nil {
+		t.Fatalf("failed to Write: %v", err)
+	}
+
+	prev, err := readInventorySnapshot(snapshot)
+	if err != nil {
+		t.Fatalf("failed to readInventorySnapshot: %v", err)
+	}
+
+	if d := diffSnapshots(prev, cur); !d.Empty() {
+		t.Fatalf("expected no changes after reading the snapshot, got:\n%s", d)
+	}
+
+	inv, _ := loadInventory(after)
+	d := diffSnapshots(prev, newInventorySnapshot(inv, "pmm-full.yaml"))
+
+	expected := []string{
+		"  - host db3\n",
+		"  ~ host db1\n      ~ mysql_password: \"sha256:",
+		"  ~ host db2\n      ~ ansible_host: \"10.0.0.2\" -> \"10.0.0.9\"\n",
+		"  + host db4\n",
+		"  ~ group mysql\n      - host db3\n",
+		"  + group postgresql\n      + host db4\n",
+		"4 of 5 host(s) changed (80%)\n",
+	}
+
+	for _, e := range expected {
+		if !strings.Contains(d.String(), e) {
+			t.Fatalf("expected %q in:\n%s", e, d)
+		}
+	}
+
+	if _, err := checkInventoryChanges(inv, snapshot, "pmm-full.yaml", 50, false, bufio.NewReader(strings.NewReader("n\n"))); err == nil {
+		t.Fatalf("expected the deploy to be cancelled")
+	}
+
+	for _, answer := range []string{"y\n", ""} {
+		if _, err := checkInventoryChanges(inv, snapshot, "pmm-full.yaml", 50, answer == "", bufio.NewReader(strings.NewReader(answer))); err != nil {
+			t.Fatalf("expected the deploy to continue, got %v", err)
+		}
+	}
+
+	if _, err := checkInventoryChanges(inv, snapshot, "pmm-full.yaml", 100, false, bufio.NewReader(strings.NewReader(""))); err != nil {
+		t.Fatalf("expected the deploy to continue within the threshold, got %v", err)
+	}
+
+	// The comparison is skipped when the inventory could not be loaded
+	if cur, err := checkInventoryChanges(nil, snapshot, "pmm-full.yaml", 0, false, bufio.NewReader(strings.NewReader(""))); cur != nil || err != nil {
+		t.Fatalf("expected no snapshot without an inventory, got %v (%v)", cur, err)
+	}
+}
+
+func TestInventoryDiffChangedPercent(t *testing.T) {
+	snapshot := func(hosts ...string) *InventorySnapshot {
+		s := &InventorySnapshot{Groups: map[string]SnapshotGroup{}, Hosts: map[string]map[string]interface{}{}}
+		for _, h := range hosts {
+			s.Hosts[h] = map[string]interface{}{}
+		}
+
+		return s
+	}
+
+	// Added hosts count towards the total, so that the percentage cannot exceed 100
+	for expected, hosts := range map[int][2][]string{
+		0:   {{"db1", "db2"}, {"db1", "db2"}},
+		60:  {{"db1", "db2"}, {"db1", "db2", "db3", "db4", "db5"}},
+		75:  {{"db1", "db2", "db3"}, {"db1", "db4"}},
+		100: {{}, {"db1", "db2"}},
+	} {
+		if p := diffSnapshots(snapshot(hosts[0]...), snapshot(hosts[1]...)).ChangedPercent(); p != expected {
+			t.Fatalf("expected %d%% for %v -> %v, got %d%%", expected, hosts[0], hosts[1], p)
+		}
+	}
+}
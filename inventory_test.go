@@ -0,0 +1,156 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const inventoryDummyYAML = `---
+all:
+  vars:
+    pmm_version: '2'
+    shared: all
+  children:
+    dbservers:
+      children:
+        mysql:
+      vars:
+        shared: dbservers
+    mysql:
+      hosts:
+        db1:
+          ansible_host: 10.0.0.1
+        db2:
+      vars:
+        shared: mysql
+        secret: !vault |
+          $ANSIBLE_VAULT;1.1;AES256
+          3630373835
+    monitors:
+      hosts:
+        monitor:
+...
+`
+
+const inventoryDummyINI = `# comment
+standalone ansible_host=10.0.0.9
+
+[postgresql]
+db3 ansible_host="10.0.0.3" ansible_user=admin
+
+[postgresql:vars]
+shared = postgresql
+
+[dbservers:children]
+postgresql
+`
+
+func writeInventoryFiles(t *testing.T, files map[string]string) string {
+	dir, err := os.MkdirTemp(tmpDir, "inventory")
+	if err != nil {
+		t.Fatalf("unable to create directory: %v", err)
+	}
+
+	for name, content := range files {
+		pth := filepath.Join(dir, name)
+
+		if err := os.MkdirAll(filepath.Dir(pth), 0o700); err != nil {
+			t.Fatalf("unable to create directory: %v", err)
+		}
+
+		mode := os.FileMode(0o600)
+		if strings.HasPrefix(content, "#!") {
+			mode = 0o700
+		}
+
+		if err := os.WriteFile(pth, []byte(content), mode); err != nil {
+			t.Fatalf("unable to write '%s': %v", pth, err)
+		}
+	}
+
+	return dir
+}
+
+func TestLoadInventory(t *testing.T) {
+	dir := writeInventoryFiles(t, map[string]string{
+		"hosts.yaml":            inventoryDummyYAML,
+		"hosts.ini":             inventoryDummyINI,
+		"script":                "#!/bin/sh\necho '" + dynamicInventoryDummy + "'\n",
+		"group_vars/mysql.yaml": "---\nshared: group_vars\n",
+		"host_vars/db1.yaml":    "---\nshared: host_vars\n",
+	})
+
+	sources := strings.Join([]string{
+		filepath.Join(dir, "hosts.yaml"),
+		filepath.Join(dir, "hosts.ini"),
+		filepath.Join(dir, "missing.yaml"),
+		filepath.Join(dir, "script"),
+	}, ",")
+
+	inv, err := loadInventory(sources)
+	if err != nil {
+		t.Fatalf("failed to loadInventory: %v", err)
+	}
+
+	if hosts := inv.GroupHosts("dbservers"); !slices.Equal(hosts, []string{"db1", "db2", "db3"}) {
+		t.Fatalf("expected db1, db2 and db3 in dbservers, got %v", hosts)
+	}
+
+	if groups := inv.Hosts["standalone"].Groups; !slices.Equal(groups, []string{ungroupedGroup}) {
+		t.Fatalf("expected standalone to be ungrouped, got %v", groups)
+	}
+
+	expected := map[string]string{"db1": "host_vars", "db2": "group_vars", "db3": "postgresql", "monitor": "all"}
+	for host, shared := range expected {
+		if v := inv.HostVars(host)["shared"]; v != shared {
+			t.Fatalf("expected shared=%s for %s, got %v", shared, host, v)
+		}
+	}
+
+	// The script inventory overrides db1, as the last source
+	if v := inv.HostVars("db1")["ansible_host"]; v != "10.0.0.1" {
+		t.Fatalf("expected ansible_host for db1, got %v", v)
+	}
+
+	if v, ok := inv.HostVars("db2")["secret"].(VaultValue); !ok || !strings.HasPrefix(v.Ciphertext, vaultHeader) {
+		t.Fatalf("expected a vault value for secret, got %v", inv.HostVars("db2")["secret"])
+	}
+
+	if src := inv.Hosts["db2"].Sources[0].String(); src != filepath.Join(dir, "hosts.yaml")+":16" {
+		t.Fatalf("expected the source of db2, got %s", src)
+	}
+
+	chain := inv.HostVarChain("db3")
+	if last := chain[len(chain)-1]; last.Group != "" || last.Var.Name != "ansible_user" || last.Var.Source.Line != 5 {
+		t.Fatalf("expected ansible_user for db3 last, got %+v", last)
+	}
+
+	if _, err := loadInventory(filepath.Join(dir, "group_vars", "mysql.yaml")); err == nil {
+		t.Fatalf("expected an error for a file that is not an inventory")
+	}
+}
+
+func TestInventorySources(t *testing.T) {
+	cfg := filepath.Join(writeInventoryFiles(t, map[string]string{
+		"ansible.cfg": "[defaults]\ninventory = a.yaml,b.yaml\n\n[ssh_connection]\ninventory = c.yaml\n",
+	}), "ansible.cfg")
+
+	t.Setenv("ANSIBLE_INVENTORY", "")
+
+	if s := inventorySources("x.yaml", cfg); s != "x.yaml" {
+		t.Fatalf("expected x.yaml, got %s", s)
+	}
+
+	if s := inventorySources("", cfg); s != "a.yaml,b.yaml" {
+		t.Fatalf("expected a.yaml,b.yaml, got %s", s)
+	}
+
+	t.Setenv("ANSIBLE_INVENTORY", "env.yaml")
+
+	if s := inventorySources("", cfg); s != "env.yaml" {
+		t.Fatalf("expected env.yaml, got %s", s)
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// InventoryIssue is a problem found when validating the inventory
+type InventoryIssue struct {
+	Message string
+	Source  InventorySource
+}
+
+// inventoryTopology lists the known children for the groups that only contain other groups
+var inventoryTopology = map[string][]string{
+	"cloud":       {"azure", "cloudsql", "rds"},
+	"dbservers":   {"cloud", "mongodb", "mysql", "postgresql"},
+	"ha":          {"haproxy", "proxysql"},
+	"pmm_clients": {"dbservers"},
+}
+
+// inventoryRequiredVars lists the variables, using dotted paths, that hosts in a group need
+var inventoryRequiredVars = map[string][]string{
+	"azure": {
+		"ansible_host",
+		"pmm_payload_add_azure.azure_client_id",
+		"pmm_payload_add_azure.azure_client_secret",
+		"pmm_payload_add_azure.azure_subscription_id",
+		"pmm_payload_add_azure.azure_tenant_id",
+	},
+	"cloudsql": {"ansible_host"},
+	"rds": {
+		"ansible_host",
+		"pmm_payload_add_rds.aws_access_key",
+		"pmm_payload_add_rds.aws_secret_key",
+	},
+}
+
+// inventoryConflicts lists groups that a host can belong to at most one of,
+// with the conflict only applying to hosts within a particular group
+var inventoryConflicts = []struct {
+	Groups []string
+	Within string
+}{
+	{Groups: []string{"azure", "cloudsql", "rds"}, Within: allGroup},
+	{Groups: []string{"cloud", "monitors"}, Within: allGroup},
+	{Groups: []string{"mongodb", "mysql", "postgresql"}, Within: "cloud"},
+}
+
+func (i InventoryIssue) String() string {
+	if i.Source.File == "" {
+		return i.Message
+	}
+
+	return fmt.Sprintf("%s: %s", i.Source, i.Message)
+}
+
+func (inv *Inventory) groupSource(group string) InventorySource {
+	if g, ok := inv.Groups[group]; ok && len(g.Sources) > 0 {
+		return g.Sources[0]
+	}
+
+	if len(inv.Sources) > 0 {
+		return InventorySource{File: inv.Sources[0]}
+	}
+
+	return InventorySource{}
+}
+
+func (inv *Inventory) hostSource(host string, group string) InventorySource {
+	h, ok := inv.Hosts[host]
+	if !ok || len(h.Sources) == 0 {
+		return inv.groupSource(group)
+	}
+
+	g, ok := inv.Groups[group]
+	if !ok {
+		return h.Sources[0]
+	}
+
+	// Prefer the definition within the group when known
+	for _, src := range h.Sources {
+		for _, gs := range g.Sources {
+			if src.File == gs.File && src.Line > gs.Line {
+				return src
+			}
+		}
+	}
+
+	return h.Sources[0]
+}
+
+// lookupVar finds a dotted path within the variables
+func lookupVar(vars map[string]interface{}, name string) (interface{}, bool) {
+	var current interface{} = vars
+
+	for _, key := range strings.Split(name, ".") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+
+		if current, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+
+	return current, current != nil && current != ""
+}
+
+// Validate the inventory against the topology expected by the playbooks
+func (inv *Inventory) Validate(monitor string) []InventoryIssue {
+	issues := []InventoryIssue{}
+
+	monitors := inv.GroupHosts("monitors")
+	switch {
+	case len(monitors) == 0:
+		issues = append(issues, InventoryIssue{Message: "the monitors group needs a host", Source: inv.groupSource("monitors")})
+	case len(monitors) > 1 && !slices.Contains(monitors, monitor):
+		issues = append(issues, InventoryIssue{
+			Message: fmt.Sprintf("the monitors group has %d hosts (%s), use --monitor to choose one", len(monitors), strings.Join(monitors, ", ")),
+			Source:  inv.groupSource("monitors"),
+		})
+	}
+
+	for _, group := range sortedKeys(inventoryTopology) {
+		g, ok := inv.Groups[group]
+		if !ok {
+			continue
+		}
+
+		for _, h := range g.Hosts {
+			issues = append(issues, InventoryIssue{
+				Message: fmt.Sprintf("host '%s' belongs in one of the child groups of '%s' (%s)", h, group, strings.Join(inventoryTopology[group], ", ")),
+				Source:  inv.hostSource(h, group),
+			})
+		}
+
+		for _, c := range g.Children {
+			if !slices.Contains(inventoryTopology[group], c) {
+				issues = append(issues, InventoryIssue{
+					Message: fmt.Sprintf("unknown group '%s' in '%s', expected one of %s", c, group, strings.Join(inventoryTopology[group], ", ")),
+					Source:  inv.groupSource(c),
+				})
+			}
+		}
+	}
+
+	for _, group := range sortedKeys(inventoryRequiredVars) {
+		for _, h := range inv.GroupHosts(group) {
+			vars := inv.HostVars(h)
+
+			for _, name := range inventoryRequiredVars[group] {
+				if _, ok := lookupVar(vars, name); !ok {
+					issues = append(issues, InventoryIssue{
+						Message: fmt.Sprintf("host '%s' in '%s' needs the variable '%s'", h, group, name),
+						Source:  inv.hostSource(h, group),
+					})
+				}
+			}
+		}
+	}
+
+	for _, h := range inv.HostNames() {
+		groups := inv.HostGroups(h)
+
+		for _, c := range inventoryConflicts {
+			if !slices.Contains(groups, c.Within) {
+				continue
+			}
+
+			found := []string{}
+			for _, g := range c.Groups {
+				if slices.Contains(groups, g) {
+					found = append(found, g)
+				}
+			}
+
+			if len(found) > 1 {
+				message := fmt.Sprintf("host '%s' cannot be in more than one of %s", h, strings.Join(found, ", "))
+				if c.Within != allGroup {
+					message += fmt.Sprintf(" as it is in '%s'", c.Within)
+				}
+
+				issues = append(issues, InventoryIssue{Message: message, Source: inv.hostSource(h, found[len(found)-1])})
+			}
+		}
+	}
+
+	return issues
+}
+
+// loadRunInventory loads the inventory once for the jump hosts and the checks around a run; sources
+// that cannot be read natively, i.e. inventory plugins, an unreachable dynamic inventory or a
+// missing vault password, are left to Ansible and the checks are skipped, while any other error
+// is returned
+func loadRunInventory(sources string) (*Inventory, error) {
+	if sources == "" {
+		Logger.Debug("no inventory to check")
+		return nil, nil
+	}
+
+	inv, err := loadInventory(sources)
+
+	switch {
+	case errors.Is(err, errInventoryPlugin), errors.Is(err, errInventoryUnreachable), errors.Is(err, errVaultPassword):
+		Logger.Warning("unable to load the inventory, it is left to Ansible and the checks around the run are skipped: %v", err)
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+
+	return inv, nil
+}
+
+// validateInventory logs each issue found in the inventory
+func validateInventory(inv *Inventory, monitor string) error {
+	issues := inv.Validate(monitor)
+	for _, i := range issues {
+		Logger.Error("%s", i)
+	}
+
+	if len(issues) > 0 {
+		return fmt.Errorf("the inventory has %d issue(s)", len(issues))
+	}
+
+	return nil
+}
+
+func inventoryValidateCommand(args []string) int {
+	fs := subcommandFlags("inventory validate", "[--inventory PATHS] [--monitor NAME]")
+	inventory := fs.String("inventory", os.Getenv("ANSIBLE_INVENTORY"), "Comma-separated inventory sources [ANSIBLE_INVENTORY]")
+	monitor := fs.String("monitor", "monitor", "Monitor alias")
+	parseSubcommandFlags(fs, args)
+
+	if *inventory == "" {
+		Logger.Error("an inventory is required")
+		return 1
+	}
+
+	inv, err := loadInventory(*inventory)
+	if err != nil {
+		Logger.Error("unable to load the inventory: %v", err)
+		return 1
+	}
+
+	if err := validateInventory(inv, *monitor); err != nil {
+		Logger.Error("%v", err)
+		return 1
+	}
+
+	fmt.Println("The inventory is valid")
+
+	return 0
+}
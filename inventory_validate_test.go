@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const inventoryInvalidYAML = `---
+all:
+  children:
+    cloud:
+      children:
+        rds:
+        aurora:
+    dbservers:
+      hosts:
+        stray:
+      children:
+        cloud:
+        mysql:
+    mysql:
+      hosts:
+        db1:
+    postgresql:
+      hosts:
+        db1:
+    rds:
+      hosts:
+        rds1:
+          ansible_host: rds1.abc.eu-west-1.rds.amazonaws.com
+          pmm_payload_add_rds:
+            aws_access_key: AKIA
+    cloudsql:
+      hosts:
+        rds1:
+    monitors:
+      hosts:
+        monitor1:
+        monitor2:
+...
+`
+
+func TestValidateInventory(t *testing.T) {
+	Config.Monitor = "monitor"
+	generateDefaults(filepath.Join(tmpDir, "temp-inventory.yaml"))
+
+	for _, valid := range []string{
+		filepath.Join(tmpDir, "etc", "pr-workflow-inventory.yaml"),
+		filepath.Join(tmpDir, "temp-inventory.yaml"),
+	} {
+		inv, err := loadRunInventory(valid)
+		if err != nil || inv == nil {
+			t.Fatalf("failed to load '%s': %v", valid, err)
+		}
+
+		if err := validateInventory(inv, Config.Monitor); err != nil {
+			t.Fatalf("expected '%s' to be valid, got %v", valid, err)
+		}
+	}
+
+	dir := writeInventoryFiles(t, map[string]string{"hosts.yaml": inventoryInvalidYAML})
+	file := filepath.Join(dir, "hosts.yaml")
+
+	inv, err := loadInventory(file)
+	if err != nil {
+		t.Fatalf("failed to loadInventory: %v", err)
+	}
+
+	issues := []string{}
+	for _, i := range inv.Validate("monitor") {
+		issues = append(issues, strings.TrimPrefix(i.String(), file+":"))
+	}
+
+	expected := []string{
+		"29: the monitors group has 2 hosts (monitor1, monitor2), use --monitor to choose one",
+		"7: unknown group 'aurora' in 'cloud', expected one of azure, cloudsql, rds",
+		"10: host 'stray' belongs in one of the child groups of 'dbservers' (cloud, mongodb, mysql, postgresql)",
+		"22: host 'rds1' in 'rds' needs the variable 'pmm_payload_add_rds.aws_secret_key'",
+		"22: host 'rds1' cannot be in more than one of cloudsql, rds",
+	}
+
+	if strings.Join(issues, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("expected:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(issues, "\n"))
+	}
+}
+
+func TestValidateInventoryPlugin(t *testing.T) {
+	dir := writeInventoryFiles(t, map[string]string{
+		"aws_ec2.yml": "plugin: amazon.aws.aws_ec2\nregions:\n  - eu-west-1\n",
+		"hosts.yaml":  "all:\n  children:\n    monitors:\n      hosts:\n        monitor:\n",
+	})
+
+	if _, err := loadInventory(dir); !errors.Is(err, errInventoryPlugin) {
+		t.Fatalf("expected the plugin config to be reported, got %v", err)
+	}
+
+	// The checks are skipped rather than failing the run
+	if inv, err := loadRunInventory(dir); inv != nil || err != nil {
+		t.Fatalf("expected the inventory to be left to Ansible, got %+v (%v)", inv, err)
+	}
+
+	if inv, err := loadRunInventory(filepath.Join(dir, "hosts.yaml")); err != nil || inv == nil || validateInventory(inv, "monitor") != nil {
+		t.Fatalf("expected the remaining source to be valid, got %v", err)
+	}
+
+	// As are vault-encrypted files without a password
+	t.Setenv("ANSIBLE_VAULT_PASSWORD_FILE", "")
+
+	encrypted, err := vaultEncrypt([]byte("all:\n"), []byte("s3cret"))
+	if err != nil {
+		t.Fatalf("failed to vaultEncrypt: %v", err)
+	}
+
+	vaulted := writeInventoryFiles(t, map[string]string{"hosts.yaml": string(encrypted)})
+	if inv, err := loadRunInventory(vaulted); inv != nil || err != nil {
+		t.Fatalf("expected the encrypted inventory to be left to Ansible, got %+v (%v)", inv, err)
+	}
+
+	// While errors in the inventory itself fail the run, naming the file and line
+	broken := writeInventoryFiles(t, map[string]string{
+		"hosts.yaml": "all:\n  children:\n    mysql:\n      hostz:\n        db1:\n",
+	})
+
+	if _, err := loadRunInventory(broken); err == nil || !strings.Contains(err.Error(), "hosts.yaml:4: unexpected key 'hostz'") {
+		t.Fatalf("expected an error for the unexpected key, got %v", err)
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// jumpHostVar sets the jump host for a group, or a single host, in the inventory
+	jumpHostVar = "gascan_jump_host"
+
+	jumpHostSSHConfig  = "ssh_config"
+	jumpHostTunnelWait = 10 * time.Second
+)
+
+// jumpHostUsage describes the --jump-host flag for the subcommands
+const jumpHostUsage = "Reach the hosts via this jump host, i.e. [user@]host[:port], unless " + jumpHostVar + " is set for the host or its group [GASCAN_FLAG_JUMP_HOST]"
+
+// JumpHost is a bastion that is used to reach the managed hosts
+type JumpHost struct {
+	Host string
+	Port int
+	User string
+}
+
+// parseJumpHost reads [user@]host[:port], as accepted by ssh -J
+func parseJumpHost(s string) (JumpHost, error) {
+	j := JumpHost{Host: strings.TrimSpace(s), Port: defaultSSHPort}
+
+	if user, host, ok := strings.Cut(j.Host, "@"); ok {
+		j.User, j.Host = user, host
+	}
+
+	if h, p, err := net.SplitHostPort(j.Host); err == nil {
+		port, err := strconv.Atoi(p)
+		if err != nil || port < 1 || port > 65535 {
+			return j, fmt.Errorf("invalid port in jump host '%s'", s)
+		}
+
+		j.Host, j.Port = h, port
+	}
+
+	if j.Host == "" || strings.ContainsAny(j.Host, " ,/") || (j.User == "" && strings.Contains(s, "@")) {
+		return j, fmt.Errorf("expected [user@]host[:port] for the jump host, got '%s'", s)
+	}
+
+	return j, nil
+}
+
+// String formats the jump host for ssh -J, or ProxyJump
+func (j JumpHost) String() string {
+	host := j.Host
+	if j.Port != defaultSSHPort {
+		host = net.JoinHostPort(j.Host, strconv.Itoa(j.Port))
+	}
+
+	if j.User != "" {
+		return j.User + "@" + host
+	}
+
+	return host
+}
+
+// SSHArgs are the arguments for ssh to connect to the jump host itself
+func (j JumpHost) SSHArgs() []string {
+	args := []string{"-p", strconv.Itoa(j.Port)}
+	if j.User != "" {
+		args = append(args, "-l", j.User)
+	}
+
+	return append(args, j.Host)
+}
+
+// jumpHostFor finds the jump host for a host, preferring a ProxyJump that is already in the
+// SSH arguments for Ansible, then gascan_jump_host and finally the jump host from the flag;
+// the jump host is never used to reach itself
+func jumpHostFor(host string, vars map[string]interface{}, fallback string) string {
+	if j := proxyJump(vars); j != "" {
+		return j
+	}
+
+	jump := stringVar(vars, jumpHostVar)
+	if jump == "" {
+		jump = fallback
+	}
+
+	j, err := parseJumpHost(jump)
+	if err != nil {
+		return jump
+	}
+
+	if address := stringVar(vars, "ansible_host", "ansible_ssh_host"); address == j.Host || host == j.Host {
+		return ""
+	}
+
+	return jump
+}
+
+// writeJumpHostSSHConfig writes the ProxyJump for each address that needs a jump host and is not
+// already covered by the arguments for Ansible, returning false when there were none
+func writeJumpHostSSHConfig(path string, inv *Inventory, fallback string) (bool, error) {
+	hosts := []SSHHost{}
+	seen := map[string]bool{}
+
+	for _, h := range inv.HostNames() {
+		vars := inv.HostVars(h)
+
+		if stringVar(vars, "ansible_connection") == "local" || proxyJump(vars) != "" {
+			continue
+		}
+
+		address := stringVar(vars, "ansible_host", "ansible_ssh_host")
+		if address == "" {
+			address = h
+		}
+
+		jump := jumpHostFor(h, vars, fallback)
+		if jump == "" || seen[address] {
+			continue
+		}
+
+		if _, err := parseJumpHost(jump); err != nil {
+			return false, fmt.Errorf("invalid %s for %s: %w", jumpHostVar, h, err)
+		}
+
+		seen[address] = true
+		hosts = append(hosts, SSHHost{Name: address, ProxyJump: jump})
+	}
+
+	if len(hosts) == 0 {
+		return false, nil
+	}
+
+	out := strings.Builder{}
+	out.WriteString("# Generated by gascan for the jump hosts, followed by the usual config for ssh\n\n")
+
+	if err := writeSSHHosts(&out, hosts); err != nil {
+		return false, err
+	}
+
+	out.WriteString("\nMatch all\n  Include ~/.ssh/config /etc/ssh/ssh_config\n")
+
+	return true, writeFileAtomic(path, []byte(out.String()), 0o600)
+}
+
+// usesJumpHosts checks whether any host sets gascan_jump_host
+func usesJumpHosts(inv *Inventory) bool {
+	if inv == nil {
+		return false
+	}
+
+	for _, h := range inv.HostNames() {
+		if stringVar(inv.HostVars(h), jumpHostVar) != "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// configureJumpHosts has Ansible use the jump hosts via an SSH config in the workspace, which is
+// added to ssh_args; when the inventory could not be loaded, the jump host from the flag is used
+// for every host instead
+func configureJumpHosts(workspace string, ansibleConfig string, inv *Inventory, fallback string) error {
+	if fallback == "" && !usesJumpHosts(inv) {
+		return nil
+	}
+
+	path := filepath.Join(workspace, jumpHostSSHConfig)
+	arg := []string{"-F", path}
+
+	switch {
+	case inv == nil:
+		Logger.Warning("the inventory was not loaded, '%s' will be used to reach every host", fallback)
+		arg = []string{"-J", fallback}
+	default:
+		needed, err := writeJumpHostSSHConfig(path, inv, fallback)
+		if err != nil || !needed {
+			return err
+		}
+	}
+
+	data, err := os.ReadFile(ansibleConfig)
+	if err != nil {
+		return err
+	}
+
+	cfg := parseAnsibleCfg(data)
+
+	args, ok := cfg.Get("ssh_connection", "ssh_args")
+	if !ok {
+		args = ansibleDefaultSSHArgs
+	}
+
+	cfg.Set("ssh_connection", "ssh_args", strings.TrimSpace(args+" "+joinShellWords(arg)))
+
+	return writeFileAtomic(ansibleConfig, cfg.Bytes(), 0o440)
+}
+
+// openTunnel forwards a local port to the address via the jump host, for the clients that are
+// unable to use a jump host themselves, returning the local port once it accepts connections
+// along with a function to close the tunnel
+func openTunnel(jump string, address string, port int) (func(), int, error) {
+	j, err := parseJumpHost(jump)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	local := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	forward := fmt.Sprintf("127.0.0.1:%d:%s", local, net.JoinHostPort(address, strconv.Itoa(port)))
+	args := append([]string{"-N", "-o", "ExitOnForwardFailure=yes", "-L", forward}, j.SSHArgs()...)
+	Logger.Debug("running %s %s", sshCommand, strings.Join(args, " "))
+
+	cmd := exec.Command(sshCommand, args...)
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Start(); err != nil {
+		return nil, 0, err
+	}
+
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	for deadline := time.Now().Add(jumpHostTunnelWait); time.Now().Before(deadline); {
+		select {
+		case err := <-exited:
+			if err == nil {
+				err = errors.New("ssh exited")
+			}
+
+			return nil, 0, fmt.Errorf("the tunnel via '%s' closed: %w", jump, err)
+		default:
+		}
+
+		if c, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", local), time.Second); err == nil {
+			c.Close()
+
+			return func() {
+				cmd.Process.Kill()
+				<-exited
+			}, local, nil
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	cmd.Process.Kill()
+	<-exited
+
+	return nil, 0, fmt.Errorf("timed out waiting for the tunnel via '%s'", jump)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const jumpHostInventoryDummy = `---
+all:
+  children:
+    monitors:
+      hosts:
+        monitor:
+          ansible_connection: local
+    mysql:
+      hosts:
+        db1:
+          ansible_host: 10.0.0.1
+        db2:
+          ansible_host: 10.0.0.2
+          ansible_ssh_common_args: -o ProxyJump=other
+      vars:
+        gascan_jump_host: ops@bastion:2200
+    bastions:
+      hosts:
+        bastion:
+...
+`
+
+func TestParseJumpHost(t *testing.T) {
+	for s, expected := range map[string]JumpHost{
+		"bastion":              {Host: "bastion", Port: 22},
+		"ops@bastion:2200":     {Host: "bastion", Port: 2200, User: "ops"},
+		"ops@[2001:db8::1]:22": {Host: "2001:db8::1", Port: 22, User: "ops"},
+	} {
+		j, err := parseJumpHost(s)
+		if err != nil || j != expected {
+			t.Fatalf("expected %+v for %s, got %+v (%v)", expected, s, j, err)
+		}
+	}
+
+	for _, s := range []string{"", "@bastion", "bastion:ssh", "bastion:70000", "a,b"} {
+		if _, err := parseJumpHost(s); err == nil {
+			t.Fatalf("expected an error for '%s'", s)
+		}
+	}
+
+	j, _ := parseJumpHost("ops@bastion:2200")
+	if j.String() != "ops@bastion:2200" || !slices.Equal(j.SSHArgs(), []string{"-p", "2200", "-l", "ops", "bastion"}) {
+		t.Fatalf("unexpected format for %+v: %s %v", j, j, j.SSHArgs())
+	}
+}
+
+func TestConfigureJumpHosts(t *testing.T) {
+	dir := writeInventoryFiles(t, map[string]string{"hosts.yaml": jumpHostInventoryDummy})
+	sources := filepath.Join(dir, "hosts.yaml")
+
+	inv, err := loadInventory(sources)
+	if err != nil {
+		t.Fatalf("failed to loadInventory: %v", err)
+	}
+
+	// The group var wins over the flag, a ProxyJump for Ansible wins over both and the jump
+	// host does not jump to itself
+	expected := map[string]string{"bastion": "", "db1": "ops@bastion:2200", "db2": "other"}
+	for _, target := range preflightTargets(inv, "bastion") {
+		if e, ok := expected[target.Host]; ok && target.JumpHost != e {
+			t.Fatalf("expected the jump host '%s' for %s, got '%s'", e, target.Host, target.JumpHost)
+		}
+
+		if target.Host == "db1" && !strings.Contains(strings.Join(target.SSHArgs, " "), "-J ops@bastion:2200") {
+			t.Fatalf("expected the jump host in the ssh args for db1, got %v", target.SSHArgs)
+		}
+	}
+
+	workspace := t.TempDir()
+	cfgPath := filepath.Join(workspace, ansibleSettingsFile)
+
+	if err := os.WriteFile(cfgPath, []byte("[defaults]\nforks = 5\n"), 0o640); err != nil {
+		t.Fatalf("unable to write '%s': %v", cfgPath, err)
+	}
+
+	if err := configureJumpHosts(workspace, cfgPath, inv, ""); err != nil {
+		t.Fatalf("failed to configureJumpHosts: %v", err)
+	}
+
+	sshConfig, _ := os.ReadFile(filepath.Join(workspace, jumpHostSSHConfig))
+	if !strings.Contains(string(sshConfig), "Host 10.0.0.1\n  ProxyJump ops@bastion:2200\n\nMatch all\n") || strings.Contains(string(sshConfig), "10.0.0.2") {
+		t.Fatalf("expected only db1 to need the jump host:\n%s", sshConfig)
+	}
+
+	data, _ := os.ReadFile(cfgPath)
+	if v, _ := parseAnsibleCfg(data).Get("ssh_connection", "ssh_args"); v != ansibleDefaultSSHArgs+" -F "+filepath.Join(workspace, jumpHostSSHConfig) {
+		t.Fatalf("expected the config in ssh_args, got '%s'", v)
+	}
+
+	// Without a jump host for any host, Ansible is left alone
+	dir = writeInventoryFiles(t, map[string]string{"hosts.yaml": inventoryEditDummy})
+
+	if inv, err = loadInventory(filepath.Join(dir, "hosts.yaml")); err != nil || usesJumpHosts(inv) {
+		t.Fatalf("expected an inventory without jump hosts, got %v", err)
+	}
+
+	workspace = t.TempDir()
+	if err := configureJumpHosts(workspace, filepath.Join(workspace, "missing.cfg"), inv, ""); err != nil {
+		t.Fatalf("expected no changes without jump hosts, got %v", err)
+	}
+
+	// When the inventory could not be loaded, the flag is used for every host
+	cfgPath = filepath.Join(workspace, ansibleSettingsFile)
+	if err := os.WriteFile(cfgPath, []byte("[ssh_connection]\nssh_args = -C\n"), 0o640); err != nil {
+		t.Fatalf("unable to write '%s': %v", cfgPath, err)
+	}
+
+	if err := configureJumpHosts(workspace, cfgPath, nil, "bastion"); err != nil {
+		t.Fatalf("failed to configureJumpHosts: %v", err)
+	}
+
+	if data, _ := os.ReadFile(cfgPath); !strings.Contains(string(data), "ssh_args = -C -J bastion\n") {
+		t.Fatalf("expected the jump host for every host:\n%s", data)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"crypto/sha256"
 	_ "embed"
@@ -10,6 +11,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
 	"text/template"
 	"time"
@@ -24,7 +26,7 @@ const (
 	adhocMode     uint = 64
 
 	extractMessage string = `
-# Add the following to your shell profile:
+# Add the following to your shell profile, or use: gascan env --install
 export ANSIBLE_VAULT_PASSWORD_FILE='%s' \
        GASCAN_DEFAULT_INVENTORY=0 \
        GASCAN_INVENTORY_CONFIG_FILE='%s'
@@ -47,15 +49,16 @@ var (
 	// Ansible stores the path to the extracted executable
 	Ansible string
 
+	// AnsiblePython stores the path to the interpreter chosen for the PEX,
+	// when empty the PEX is executed directly
+	AnsiblePython string
+
 	// APIEndpoint defines the target for requesting an inventory
 	APIEndpoint = "http://localhost/inventory"
 
 	// Config stores the settings
 	Config Flags
 
-	// ConnectionTool is the path to the SSH and database connection tool
-	ConnectionTool string
-
 	// DynamicInventoryScript is the path to the extracted Python script
 	// to use as a dynamic inventory
 	DynamicInventoryScript string
@@ -78,9 +81,6 @@ var (
 	//go:embed bundle.tgz
 	bundle []byte
 
-	//go:embed scripts/connect/connect.py
-	connectTool []byte
-
 	defaultConfig = "templates/defaultConfig.j2"
 
 	defaultInventory = "templates/defaultInventory.j2"
@@ -95,7 +95,6 @@ var (
 	optInDefaultOn  = map[string]bool{"": true, "true": true, "yes": true, "1": true}
 	optInDefaultOff = map[string]bool{"true": true, "yes": true, "1": true}
 
-	//go:embed build/ansible
 	pex []byte
 
 	sampleInventoryConfig SampleInventoryConfig
@@ -112,6 +111,7 @@ type SampleConnectionToolConfig struct {
 type SampleInventoryConfig struct {
 	Headers          map[string]string `json:"headers"`
 	KeyFile          string            `json:"key_file"`
+	RequestTimeout   uint              `json:"request_timeout,omitempty"`
 	RetryAttempts    uint              `json:"retry_attempts"`
 	RetryWaitSeconds uint              `json:"retry_wait_seconds"`
 	URI              string            `json:"uri"`
@@ -218,24 +218,25 @@ func generateVaultKey(path string) error {
 func prepareHost(baseDir string, binDir string, configDir string) error {
 	ansibleConfig := filepath.Join(os.Getenv("HOME"), ".ansible.cfg")
 	ansibleConfigSrc := filepath.Join(baseDir, "default.cfg")
-	ansibleHelper := filepath.Join(binDir, "ansible.sh")
-	ansiblePex := filepath.Join(binDir, "ansible.pex")
-	connectionTool := filepath.Join(binDir, "connect.py")
-	connectionToolSrc := filepath.Join(baseDir, "connect.py")
-	connectionToolConf := filepath.Join(configDir, "connect-py.json")
-	dynInventory := filepath.Join(binDir, "dynamic-inventory.py")
-	dynInventorySrc := filepath.Join(baseDir, "dynamic-inventory.py")
+	connectionToolConf := filepath.Join(configDir, connectConfigFile)
 	dynInventoryConf := filepath.Join(configDir, "inventory-config.json")
 	secrets := filepath.Join(configDir, "secrets.yaml")
 	tempInventory := filepath.Join(baseDir, "temp-inventory.yaml")
 	vaultKey := filepath.Join(configDir, ".vault-key")
 
-	// Extract default.cfg to ~/.ansible.cfg
-	if ExtractAnsibleConfig {
-		fmt.Println("Extracting default.cfg to ~/.ansible.cfg")
-		if c, err := os.ReadFile(ansibleConfigSrc); err == nil {
-			extractToFile(ansibleConfig, c, 0o640)
+	// Merge default.cfg into ~/.ansible.cfg, as per --ansible-cfg
+	if Config.AnsibleCfg != "skip" {
+		if c, err := os.ReadFile(ansibleConfigSrc); err != nil {
+			Logger.Warning("unable to read '%s': %v", ansibleConfigSrc, err)
+		} else if err := updateAnsibleCfg(ansibleConfig, c, Config.AnsibleCfg); err != nil {
+			Logger.Fatal("failed to update '%s': %v", ansibleConfig, err)
+		}
+
+		if Config.AnsibleCfg == "print" {
+			return nil
 		}
+
+		fmt.Printf("Updated '%s' using --ansible-cfg=%s\n", ansibleConfig, Config.AnsibleCfg)
 	}
 
 	// Create the config directory
@@ -250,24 +251,10 @@ func prepareHost(baseDir string, binDir string, configDir string) error {
 		generateVaultKey(vaultKey)
 	}
 
-	// Create the bin directory
-	if err := os.MkdirAll(binDir, 0o750); err != nil {
-		Logger.Fatal("failed to create bin directory '%s': %v", binDir, err)
-	}
-
-	// Create the Ansible PEX helper and symlinks
-	if _, err := os.Stat(ansibleHelper); err != nil {
-		symlinks := []string{"ansible", "ansible-playbook", "ansible-vault", "ansible-config", "ansible-inventory"}
-
-		fmt.Println("Creating Ansible PEX:", ansiblePex)
-		extractToFile(ansiblePex, pex, 0o750)
-
-		fmt.Println("Creating Ansible helper script:", ansibleHelper)
-		extractToFile(ansibleHelper, binHelper, 0o750)
-
-		for _, p := range symlinks {
-			os.Symlink(ansibleHelper, filepath.Join(binDir, p))
-		}
+	// Install, or upgrade, the Ansible PEX, connection tool and dynamic inventories
+	fmt.Println("Installing helpers:", binDir)
+	if err := installHelpers(binDir, installManifestPath()); err != nil {
+		Logger.Fatal("failed to install the helpers in '%s': %v", binDir, err)
 	}
 
 	// Copy the generated inventory to use for secrets
@@ -282,21 +269,10 @@ func prepareHost(baseDir string, binDir string, configDir string) error {
 		}
 	}
 
-	// Copy the connection tool
-	if c, err := os.ReadFile(connectionToolSrc); err == nil {
-		fmt.Printf("Copying connection tool '%s' to '%s'\n", connectionToolSrc, connectionTool)
-		extractToFile(connectionTool, c, 0o550)
-
-		for _, p := range []string{"db_connect", "ssh_connect"} {
-			os.Symlink(connectionTool, filepath.Join(binDir, p))
-		}
-
-	}
-
 	// Generate a config for the connection tool
 	if _, err := os.Stat(connectionToolConf); err != nil {
 		sampleConnectionToolConfig := SampleConnectionToolConfig{
-			ServerAddress: "https://localhost:8443",
+			ServerAddress: defaultServerAddress,
 			Standardise:   true,
 			Inventory:     map[string]string{},
 		}
@@ -311,14 +287,6 @@ func prepareHost(baseDir string, binDir string, configDir string) error {
 		}
 	}
 
-	// Copy the dynamic inventory script
-	if ExtractDynamicInventory {
-		if c, err := os.ReadFile(dynInventorySrc); err == nil {
-			fmt.Printf("Copying dynamic inventory '%s' to '%s'\n", dynInventorySrc, dynInventory)
-			extractToFile(dynInventory, c, 0o550)
-		}
-	}
-
 	hi, _ := generateHash("/etc/machine-id")
 	ht, _ := generateHash("/etc/machine-id")
 
@@ -372,7 +340,38 @@ func prepareHost(baseDir string, binDir string, configDir string) error {
 	return nil
 }
 
+// runInventory loads the inventory for the run, where one that fails to load stops the run
+// unless the validation is skipped
+func runInventory(sources string) (*Inventory, error) {
+	inv, err := loadRunInventory(sources)
+	if err != nil && Config.SkipValidation {
+		Logger.Warning("unable to load the inventory, the checks around the run are skipped: %v", err)
+		return nil, nil
+	}
+
+	return inv, err
+}
+
+// configureRunJumpHosts has Ansible use the jump hosts for the run
+func configureRunJumpHosts(workspace string, ansibleConfig string, inv *Inventory) {
+	if err := configureJumpHosts(workspace, ansibleConfig, inv, Config.JumpHost); err != nil {
+		Logger.Fatal("unable to configure the jump hosts: %v", err)
+	}
+}
+
 func main() {
+	if filepath.Base(os.Args[0]) == inventoryScriptName {
+		os.Exit(inventoryCommand(os.Args[1:]))
+	}
+
+	if kind, ok := connectLinks[filepath.Base(os.Args[0])]; ok {
+		os.Exit(connectCommand(append([]string{kind}, os.Args[1:]...)))
+	}
+
+	if code, ok := runSubcommand(os.Args[1:]); ok {
+		os.Exit(code)
+	}
+
 	flags()
 
 	exitCode = 0
@@ -380,7 +379,6 @@ func main() {
 	tmpDir := createWorkspace()
 
 	Ansible = filepath.Join(tmpDir, "ansible.pex")
-	ConnectionTool = filepath.Join(tmpDir, "connect.py")
 	DynamicInventoryScript = filepath.Join(tmpDir, "dynamic-inventory.py")
 
 	ansibleConfig := strings.Replace(Ansible, "ansible.pex", "default.cfg", 1)
@@ -418,20 +416,42 @@ func main() {
 			cleanupWorkspace(tmpDir)
 		} else {
 			a := strings.Join(playArgs, " ")
+			pexCmd := strings.TrimSpace(AnsiblePython + " " + Ansible)
 			Logger.Info("Your workspace has been left in place:", tmpDir)
 			fmt.Println("Ansible:", Ansible)
-			fmt.Println("Run ping test: ANSIBLE_CONFIG="+ansibleConfig, "PEX_SCRIPT=ansible-playbook", Ansible, a, tp)
-			fmt.Println("Run deploy: ANSIBLE_CONFIG="+ansibleConfig, "PEX_SCRIPT=ansible-playbook", Ansible, a, pp)
+			fmt.Println("Run ping test: ANSIBLE_CONFIG="+ansibleConfig, "PEX_SCRIPT=ansible-playbook", pexCmd, a, tp)
+			fmt.Println("Run deploy: ANSIBLE_CONFIG="+ansibleConfig, "PEX_SCRIPT=ansible-playbook", pexCmd, a, pp)
 		}
 
 		os.Exit(exitCode)
 	}()
 
+	if err := selectAnsiblePex(); err != nil {
+		Logger.Fatal("unable to select a PEX for Ansible: %v", err)
+	}
+
 	extractToFile(Ansible, pex, 0o550)
 	extractBundle(bundle, tmpDir)
 
+	if len(Config.Overlays) > 0 {
+		shadowed, err := applyOverlays(Config.Overlays, tmpDir)
+		if err != nil {
+			Logger.Fatal("failed to apply overlays: %v", err)
+		}
+
+		printShadowedFiles(shadowed)
+	}
+
+	if Config.Mode&extractMode == 0 {
+		cfg, err := configureAnsible(tmpDir, inventory, Config.AnsibleSettings)
+		if err != nil {
+			Logger.Fatal("unable to configure Ansible: %v", err)
+		}
+
+		ansibleConfig = cfg
+	}
+
 	if Config.Mode&adhocMode == 0 {
-		extractToFile(ConnectionTool, connectTool, 0o550)
 		extractToFile(DynamicInventoryScript, dynamicInventory, 0o550)
 	}
 
@@ -455,6 +475,15 @@ func main() {
 	}
 
 	if Config.Mode&adhocMode > 0 {
+		inv, err := runInventory(inventorySources(inventory, ansibleConfig))
+		if err != nil {
+			Logger.Error("unable to load the inventory: %v, use --skip-validation to ignore", err)
+			isDone, exitCode = true, 1
+			return
+		}
+
+		configureRunJumpHosts(tmpDir, ansibleConfig, inv)
+
 		a := append(playArgs, Config.ExtraArguments...)
 		isDone, exitCode = RunAnsible(ansibleConfig, a...)
 	}
@@ -470,9 +499,8 @@ func main() {
 
 	if Config.Mode&configMode > 0 {
 		Logger.Debug("Opening inventory for editing")
-		tpls := inventory
 
-		if err := editTemplates(tpls); err != nil {
+		if err := editInventory(inventorySources(inventory, ansibleConfig), Config.Monitor); err != nil {
 			Logger.Fatal("unable to make the necessary configuration changes: %v", err)
 		}
 	}
@@ -492,13 +520,61 @@ func main() {
 		os.Exit(0)
 	}
 
+	// The inventory is loaded once, after any edits, so that each check uses the same hosts
+	var inv *Inventory
+	if Config.Mode&(testMode|deployMode) > 0 {
+		var err error
+		if inv, err = runInventory(inventorySources(inventory, ansibleConfig)); err != nil {
+			Logger.Error("unable to load the inventory: %v, use --skip-validation to ignore", err)
+			isDone, exitCode = true, 1
+			return
+		}
+
+		configureRunJumpHosts(tmpDir, ansibleConfig, inv)
+	}
+
+	if inv != nil && Config.Mode&(testMode|deployMode) > 0 && !Config.SkipValidation {
+		Logger.Debug("Validating the inventory")
+
+		if err := validateInventory(inv, Config.Monitor); err != nil {
+			Logger.Error("%v, use --skip-validation to ignore", err)
+			isDone, exitCode = true, 1
+			return
+		}
+	}
+
 	if Config.Mode&testMode > 0 {
 		a := append([]string{tp}, playArgs...)
 		RunPlaybook(ansibleConfig, a...)
 	}
 
 	if Config.Mode&deployMode > 0 {
+		sources := inventorySources(inventory, ansibleConfig)
+		snapshotFile := snapshotPath(sources, Config.Monitor)
+
+		snapshot, err := checkInventoryChanges(inv, snapshotFile, Config.Playbook, Config.ChangeThreshold, Config.Yes, bufio.NewReader(os.Stdin))
+		if err != nil {
+			Logger.Error("%v, use --yes to deploy anyway", err)
+			isDone, exitCode = true, 1
+			return
+		}
+
 		a := append([]string{pp}, playArgs...)
 		isDone, exitCode = RunPlaybook(ansibleConfig, a...)
+
+		if isDone && exitCode == 0 && snapshot != nil {
+			if err := snapshot.Write(snapshotFile); err != nil {
+				Logger.Warning("unable to save the inventory snapshot '%s': %v", snapshotFile, err)
+			}
+		}
+
+		if isDone && exitCode == 0 && !Config.SkipVerify && slices.Contains(verifyPlaybooks, Config.Playbook) {
+			if len(Config.LimitHosts) > 0 {
+				Logger.Info("skipping the verification as --limit is set, use gascan verify instead")
+			} else if err := verifyDeployment(inv, Config.Monitor, Config.JumpHost); err != nil {
+				Logger.Error("%v, use --skip-verify to ignore", err)
+				exitCode = 1
+			}
+		}
 	}
 }
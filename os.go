@@ -5,11 +5,12 @@ import (
 	"bufio"
 	"bytes"
 	"compress/gzip"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
-	"os/user"
+	"os/exec"
 	"path"
 	"path/filepath"
 	"slices"
@@ -17,9 +18,6 @@ import (
 	"text/template"
 )
 
-// EnvCachePaths is a comma-separated set of path names, which can be configured at build time
-var EnvCachePaths string = "/tmp/.gascan/inventory.cache,~/.config/gascan/cache/gas_inventory_dynamic_inventory_plugin*"
-
 func cleanupWorkspace(path string) error {
 	Logger.Debug("cleaning path %q", path)
 
@@ -31,42 +29,6 @@ func cleanupWorkspace(path string) error {
 	return nil
 }
 
-func clearInventoryCache() error {
-	currentUser, _ := user.Current()
-	paths := strings.Split(EnvCachePaths, ",")
-
-	for i, path := range paths {
-		if path == "~" || path == "~/" {
-			return fmt.Errorf("EnvCachePaths is using ~/ as a path")
-		}
-
-		if strings.HasPrefix(path, "~/") {
-			path = filepath.Join(currentUser.HomeDir, path[2:])
-			Logger.Debug("converted path %q to %q", paths[i], path)
-		}
-
-		if strings.HasSuffix(path, "*") {
-			matches, err := filepath.Glob(path)
-			if err != nil {
-				return err
-			}
-
-			for _, m := range matches {
-				path = m
-				Logger.Debug("converted path %q to %q", paths[i], path)
-				break
-			}
-		}
-
-		if err := cleanupWorkspace(path); err != nil {
-			Logger.Warning("unable to remove %s", path)
-			return err
-		}
-	}
-
-	return nil
-}
-
 func createWorkspace() string {
 	tmpDir, err := os.MkdirTemp(Config.ExtractPath, "onboarding")
 	if err != nil {
@@ -119,9 +81,17 @@ func makeAbsolutePaths(sourceFileName string, newName string) string {
 	return destFile.Name()
 }
 
+func generateAnsibleCommand(args ...string) *exec.Cmd {
+	if AnsiblePython == "" {
+		return generateCommand(Ansible, args...)
+	}
+
+	return generateCommand(AnsiblePython, append([]string{Ansible}, args...)...)
+}
+
 // RunPlaybook via ansible-playbook
 func RunPlaybook(ansibleConfig string, args ...string) (bool, int) {
-	c := generateCommand(Ansible, args...)
+	c := generateAnsibleCommand(args...)
 	c.Env = append(os.Environ(), "PEX_SCRIPT=ansible-playbook", fmt.Sprintf("ANSIBLE_CONFIG=%s", ansibleConfig))
 
 	Logger.Debug("Executing playbook: %s", c.Env)
@@ -136,7 +106,7 @@ func RunPlaybook(ansibleConfig string, args ...string) (bool, int) {
 
 // RunAnsible via ansible
 func RunAnsible(ansibleConfig string, args ...string) (bool, int) {
-	c := generateCommand(Ansible, args...)
+	c := generateAnsibleCommand(args...)
 	c.Env = append(os.Environ(), "PEX_SCRIPT=ansible", fmt.Sprintf("ANSIBLE_CONFIG=%s", ansibleConfig))
 
 	Logger.Debug("Executing ansible: %s", c.Env)
@@ -151,7 +121,7 @@ func RunAnsible(ansibleConfig string, args ...string) (bool, int) {
 
 // ShowInventory via ansible-inventory
 func ShowInventory(ansibleConfig string, args ...string) (bool, int) {
-	c := generateCommand(Ansible, args...)
+	c := generateAnsibleCommand(args...)
 	c.Env = append(os.Environ(), "PEX_SCRIPT=ansible-inventory", fmt.Sprintf("ANSIBLE_CONFIG=%s", ansibleConfig))
 
 	Logger.Debug("Showing the inventory: %s", c.Env)
@@ -164,42 +134,248 @@ func ShowInventory(ansibleConfig string, args ...string) (bool, int) {
 	return true, 0
 }
 
-func editTemplates(path string) error {
-	args := append([]string{Config.Editor}, strings.Split(path, " ")...)
-	c := generateCommand("command", args...)
+// shellSafeChars do not need to be quoted for a POSIX shell
+const shellSafeChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789@%+=:,./_-"
+
+// splitShellWords splits a command line as per a POSIX shell, without expansion
+func splitShellWords(s string) ([]string, error) {
+	words := []string{}
+	current := strings.Builder{}
+	inWord := false
+	quote := rune(0)
+	escaped := false
+
+	for _, r := range s {
+		switch {
+		case escaped:
+			if quote == '"' && !strings.ContainsRune("\\\"$`", r) {
+				current.WriteRune('\\')
+			}
 
-	Logger.Debug("configuring templates %s", path)
+			current.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inWord = true
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			current.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, current.String())
+				current.Reset()
+				inWord = false
+			}
+		default:
+			current.WriteRune(r)
+			inWord = true
+		}
+	}
 
-	if err := c.Run(); err != nil {
-		Logger.Error("failed to execute command '%s': %s", c, err)
-		return err
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("unterminated quote or escape in '%s'", s)
 	}
 
-	return nil
+	if inWord {
+		words = append(words, current.String())
+	}
+
+	return words, nil
 }
 
-func generateDefaults(inventory string) {
-	d := path.Dir(inventory)
-	t := []string{filepath.Join(d, defaultInventory), filepath.Join(d, defaultConfig)}
+// joinShellWords is the reverse of splitShellWords, quoting only the words that need it
+func joinShellWords(words []string) string {
+	quoted := make([]string, len(words))
 
-	for _, tmplSrc := range t {
-		j2, err := os.ReadFile(tmplSrc)
-		if err != nil {
-			Logger.Fatal("unable to load template '%s'", tmplSrc)
+	for i, w := range words {
+		quoted[i] = w
+		if w == "" || strings.ContainsFunc(w, func(r rune) bool { return !strings.ContainsRune(shellSafeChars, r) }) {
+			quoted[i] = shellQuote("sh", w)
 		}
+	}
+
+	return strings.Join(quoted, " ")
+}
+
+// editorCommand parses the editor along with any arguments, e.g. "code --wait"
+func editorCommand(editor string) ([]string, error) {
+	args, err := splitShellWords(editor)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(args) == 0 {
+		return nil, fmt.Errorf("no editor has been set, please use --editor, VISUAL or EDITOR")
+	}
+
+	return args, nil
+}
+
+func runEditor(editor []string, path string) error {
+	c := generateCommand(editor[0], append(editor[1:], path)...)
+
+	Logger.Debug("editing '%s' with %v", path, editor)
+
+	return c.Run()
+}
+
+// checkEditedInventory validates the content for an inventory file, along with the other sources
+func checkEditedInventory(file string, data []byte, sources string, monitor string) []string {
+	problems := []string{}
+
+	inv, err := loadInventoryWith(sources, map[string][]byte{file: data})
+	if err != nil {
+		return append(problems, err.Error())
+	}
+
+	for _, i := range inv.Validate(monitor) {
+		problems = append(problems, i.String())
+	}
+
+	return problems
+}
+
+// editInventoryFile edits a temporary copy of the file, only replacing the file once the
+// copy is valid, or the user chooses to save it regardless, similar to visudo
+func editInventoryFile(file string, sources string, monitor string, editor []string, answers *bufio.Reader) error {
+	info, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+
+	original, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	data := original
+	password := []byte(nil)
+
+	if isVaultEncrypted(original) {
+		if password = inventoryVaultPassword(); password == nil {
+			return fmt.Errorf("'%s' is encrypted, please set ANSIBLE_VAULT_PASSWORD_FILE", file)
+		}
+
+		if data, err = vaultDecrypt(original, password); err != nil {
+			return fmt.Errorf("unable to decrypt '%s': %w", file, err)
+		}
+	}
+
+	tmp, err := os.CreateTemp("", "inventory-*"+filepath.Ext(file))
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
 
-		tmpl, err := template.New(tmplSrc).Parse(string(j2))
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	for {
+		if err := runEditor(editor, tmp.Name()); err != nil {
+			return fmt.Errorf("failed to run the editor: %w", err)
+		}
+
+		edited, err := os.ReadFile(tmp.Name())
 		if err != nil {
-			Logger.Fatal("failed to parse template '%s': %v", tmplSrc, err)
+			return err
 		}
 
-		switch path.Base(tmplSrc) {
-		case path.Base(defaultInventory):
-			ds := ansibleInventory{Config}
-			content, _ := renderTemplate(&ds, tmpl)
-			extractToFile(inventory, content, 0o440)
+		if bytes.Equal(edited, data) {
+			fmt.Printf("No changes made to '%s'\n", file)
+			return nil
+		}
+
+		problems := checkEditedInventory(file, edited, sources, monitor)
+		if len(problems) > 0 {
+			fmt.Printf("The changes to '%s' are not valid:\n", file)
+
+			for _, p := range problems {
+				fmt.Println(" ", strings.Replace(p, tmp.Name(), file, 1))
+			}
+
+			fmt.Print("What now? (e)dit again, e(x)it without saving, save anyway (Q): ")
+
+			answer, err := answers.ReadString('\n')
+			if err != nil && answer == "" {
+				return fmt.Errorf("no changes saved to '%s'", file)
+			}
+
+			switch strings.TrimSpace(answer) {
+			case "x":
+				fmt.Printf("No changes saved to '%s'\n", file)
+				return nil
+			case "Q":
+				Logger.Warning("saving '%s' with %d issue(s)", file, len(problems))
+			default:
+				continue
+			}
 		}
+
+		if password != nil {
+			if edited, err = vaultEncrypt(edited, password); err != nil {
+				return err
+			}
+		}
+
+		return writeFileAtomic(file, edited, info.Mode().Perm())
+	}
+}
+
+// editInventory opens each file from the inventory sources in the editor
+func editInventory(sources string, monitor string) error {
+	editor, err := editorCommand(Config.Editor)
+	if err != nil {
+		return err
+	}
+
+	answers := bufio.NewReader(os.Stdin)
+
+	for _, src := range strings.Split(sources, ",") {
+		src = expandHome(strings.TrimSpace(src))
+
+		info, err := os.Stat(src)
+		if err != nil || !info.Mode().IsRegular() || info.Mode()&0o111 != 0 {
+			Logger.Debug("skipping '%s' as it is not an inventory file", src)
+			continue
+		}
+
+		if err := editInventoryFile(src, sources, monitor, editor, answers); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// generateDefaults renders the default inventory, while the config for Ansible is generated
+// by configureAnsible
+func generateDefaults(inventory string) {
+	tmplSrc := filepath.Join(path.Dir(inventory), defaultInventory)
+
+	j2, err := os.ReadFile(tmplSrc)
+	if err != nil {
+		Logger.Fatal("unable to load template '%s'", tmplSrc)
 	}
+
+	tmpl, err := template.New(tmplSrc).Parse(string(j2))
+	if err != nil {
+		Logger.Fatal("failed to parse template '%s': %v", tmplSrc, err)
+	}
+
+	ds := ansibleInventory{Config}
+	content, _ := renderTemplate(&ds, tmpl)
+	extractToFile(inventory, content, 0o440)
 }
 
 func extractToFile(path string, content []byte, mode fs.FileMode) bool {
@@ -261,8 +437,8 @@ func extractBundle(tgz []byte, targetDir string) bool {
 		case tar.TypeReg:
 			Logger.Debug("File = %s", pth)
 			if _, err := io.Copy(&tbuf, tr); err == nil {
-				switch strings.Replace(pth, targetDir, "", 1) {
-				case "/pax_global_header":
+				switch rel, _ := filepath.Rel(targetDir, pth); rel {
+				case "pax_global_header":
 					tbuf.Reset()
 					Logger.Debug("ignoring %s", pth)
 					continue
@@ -285,3 +461,56 @@ func extractBundle(tgz []byte, targetDir string) bool {
 
 	return true
 }
+
+// resolveSymlink returns the file that a symlink points to, so that writeFileAtomic replaces the
+// target rather than the link, with a dangling link resolving to its missing target
+func resolveSymlink(name string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(name)
+	if err == nil {
+		return resolved, nil
+	}
+
+	if !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+
+	target, err := os.Readlink(name)
+	if err != nil {
+		return name, nil
+	}
+
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(name), target)
+	}
+
+	return target, nil
+}
+
+func writeFileAtomic(name string, content []byte, mode os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+"-*")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), name)
+}
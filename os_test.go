@@ -2,10 +2,13 @@ package main
 
 import (
 	"archive/tar"
+	"bufio"
 	"bytes"
 	"compress/gzip"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 )
 
@@ -95,35 +98,6 @@ func TestExtraction(t *testing.T) {
 	}
 }
 
-func TestClearCache(t *testing.T) {
-	var err error
-
-	EnvCachePaths = "~,~/"
-	cacheDir := ""
-
-	if err = clearInventoryCache(); err == nil {
-		t.Fatalf("expected an error for EnvCachePaths %q", EnvCachePaths)
-	}
-
-	if cacheDir, err = os.MkdirTemp(os.TempDir(), "cache1"); err != nil {
-		t.Fatalf("unable to create directory: %v", err)
-	}
-
-	defer os.RemoveAll(cacheDir)
-
-	EnvCachePaths = cacheDir
-
-	if cacheDir, err = os.MkdirTemp(os.TempDir(), "cache2"); err != nil {
-		t.Fatalf("unable to create directory: %v", err)
-	}
-
-	EnvCachePaths += "," + filepath.Join(cacheDir, "foobar*") + "," + cacheDir
-
-	if err = clearInventoryCache(); err != nil {
-		t.Fatalf("unexpected error for EnvCachePaths %q", EnvCachePaths)
-	}
-}
-
 func TestAnsibleConfig(t *testing.T) {
 	cfgDir := ""
 
@@ -152,3 +126,73 @@ func TestAnsibleConfig(t *testing.T) {
 		t.Fatalf("unable to read the new dummy config: %v", err)
 	}
 }
+
+func TestSplitShellWords(t *testing.T) {
+	expected := map[string][]string{
+		`code --wait`:                    {"code", "--wait"},
+		`"/opt/my editor/bin/ed" -n`:     {"/opt/my editor/bin/ed", "-n"},
+		`vim -c 'set ft=yaml' x\ y`:      {"vim", "-c", "set ft=yaml", "x y"},
+		`db1 ansible_host="10.0.0.1" a=`: {"db1", "ansible_host=10.0.0.1", "a="},
+		`   `:                            {},
+	}
+
+	for s, words := range expected {
+		if got, err := splitShellWords(s); err != nil || !slices.Equal(got, words) {
+			t.Fatalf("expected %q for %q, got %q (%v)", words, s, got, err)
+		}
+	}
+
+	for _, words := range [][]string{{"-o", "UserKnownHostsFile=/home/user/.config/gascan/known_hosts"}, {"-o", "ProxyCommand=ssh -W %h:%p bastion", "it's", ""}} {
+		if got, err := splitShellWords(joinShellWords(words)); err != nil || !slices.Equal(got, words) {
+			t.Fatalf("expected %q after joining, got %q (%v)", words, got, err)
+		}
+	}
+
+	if _, err := splitShellWords(`vim "unterminated`); err == nil {
+		t.Fatalf("expected an error for an unterminated quote")
+	}
+}
+
+func TestEditInventoryFile(t *testing.T) {
+	dir := writeInventoryFiles(t, map[string]string{
+		"hosts.yaml": "---\nall:\n  children:\n    monitors:\n      hosts:\n        monitor:\n",
+		"valid.yaml": "---\nall:\n  children:\n    monitors:\n      hosts:\n        pmm:\n",
+		"editor": `#!/bin/sh
+n=$(cat "$(dirname "$0")/count" 2>/dev/null || echo 0)
+echo $((n + 1)) > "$(dirname "$0")/count"
+test "$1" = "--wait" || exit 1
+if [ "$n" -eq 0 ]; then
+  printf -- '---\nall:\n  hosts:\n    db1:\n' > "$2"
+else
+  cp "$(dirname "$0")/valid.yaml" "$2"
+fi
+`,
+	})
+
+	file := filepath.Join(dir, "hosts.yaml")
+	editor := []string{filepath.Join(dir, "editor"), "--wait"}
+
+	// Exit without saving after the first, invalid, edit
+	if err := editInventoryFile(file, file, "pmm", editor, bufio.NewReader(strings.NewReader("x\n"))); err != nil {
+		t.Fatalf("failed to editInventoryFile: %v", err)
+	}
+
+	if data, _ := os.ReadFile(file); !strings.Contains(string(data), "monitor:") {
+		t.Fatalf("expected the inventory to be unchanged, got:\n%s", data)
+	}
+
+	// Edit again after the invalid edit
+	os.Remove(filepath.Join(dir, "count"))
+
+	if err := editInventoryFile(file, file, "pmm", editor, bufio.NewReader(strings.NewReader("e\n"))); err != nil {
+		t.Fatalf("failed to editInventoryFile: %v", err)
+	}
+
+	if data, _ := os.ReadFile(file); !strings.Contains(string(data), "pmm:") {
+		t.Fatalf("expected the valid inventory to be saved, got:\n%s", data)
+	}
+
+	if count, _ := os.ReadFile(filepath.Join(dir, "count")); strings.TrimSpace(string(count)) != "2" {
+		t.Fatalf("expected the editor to run twice, got %s", count)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const bundleSource = "bundle"
+
+// ShadowedFile records a workspace file that was replaced by an overlay
+type ShadowedFile struct {
+	Path    string
+	Overlay string
+	Source  string
+}
+
+func overlayPlaybooks(overlays []string) []string {
+	var plays []string
+
+	for _, o := range overlays {
+		entries, err := os.ReadDir(o)
+		if err != nil {
+			Logger.Debug("unable to read overlay '%s': %v", o, err)
+			continue
+		}
+
+		for _, e := range entries {
+			if e.IsDir() || !strings.HasSuffix(e.Name(), ".yaml") || slices.Contains(plays, e.Name()) {
+				continue
+			}
+
+			plays = append(plays, e.Name())
+		}
+	}
+
+	return plays
+}
+
+func availablePlaybooks() []string {
+	plays := strings.Split(PlaybookList, ",")
+
+	for _, p := range overlayPlaybooks(Config.Overlays) {
+		if !slices.Contains(plays, p) {
+			plays = append(plays, p)
+		}
+	}
+
+	return plays
+}
+
+func checkOverlay(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	info, err := os.Stat(abs)
+	if err != nil {
+		return "", err
+	}
+
+	if !info.IsDir() {
+		return "", fmt.Errorf("overlay '%s' is not a directory", path)
+	}
+
+	return abs, nil
+}
+
+func applyOverlays(overlays []string, targetDir string) ([]ShadowedFile, error) {
+	var shadowed []ShadowedFile
+
+	sources := map[string]string{}
+
+	for _, o := range overlays {
+		Logger.Debug("applying overlay '%s' to '%s'", o, targetDir)
+
+		err := filepath.WalkDir(o, func(pth string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			rel, err := filepath.Rel(o, pth)
+			if err != nil {
+				return err
+			}
+
+			if rel == "." {
+				return nil
+			}
+
+			dest := filepath.Join(targetDir, rel)
+
+			if d.IsDir() {
+				return os.MkdirAll(dest, 0o750)
+			}
+
+			if !d.Type().IsRegular() && d.Type()&fs.ModeSymlink == 0 {
+				Logger.Debug("skipping '%s' in overlay '%s'", rel, o)
+				return nil
+			}
+
+			content, err := os.ReadFile(pth)
+			if err != nil {
+				return err
+			}
+
+			if info, err := os.Lstat(dest); err == nil {
+				if info.IsDir() {
+					return fmt.Errorf("overlay '%s' provides a file for directory '%s'", o, rel)
+				}
+
+				src, ok := sources[rel]
+				if !ok {
+					src = bundleSource
+				}
+
+				shadowed = append(shadowed, ShadowedFile{Path: rel, Overlay: o, Source: src})
+
+				if err := os.Remove(dest); err != nil {
+					return err
+				}
+			}
+
+			sources[rel] = o
+			extractToFile(dest, content, 0o440)
+
+			return nil
+		})
+		if err != nil {
+			return shadowed, fmt.Errorf("unable to apply overlay '%s': %w", o, err)
+		}
+	}
+
+	return shadowed, nil
+}
+
+func printShadowedFiles(shadowed []ShadowedFile) {
+	for _, s := range shadowed {
+		src := "the bundle"
+		if s.Source != bundleSource {
+			src = fmt.Sprintf("overlay '%s'", s.Source)
+		}
+
+		fmt.Printf("Overlay '%s' shadows '%s' from %s\n", s.Overlay, s.Path, src)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func createOverlay(t *testing.T, files map[string]string) string {
+	dir, err := os.MkdirTemp(os.TempDir(), "overlay")
+	if err != nil {
+		t.Fatalf("unable to create directory: %v", err)
+	}
+
+	for name, body := range files {
+		pth := filepath.Join(dir, name)
+
+		if err := os.MkdirAll(filepath.Dir(pth), 0o750); err != nil {
+			t.Fatalf("unable to create directory: %v", err)
+		}
+
+		if err := os.WriteFile(pth, []byte(body), 0o640); err != nil {
+			t.Fatalf("unable to write '%s': %v", pth, err)
+		}
+	}
+
+	return dir
+}
+
+func TestOverlay(t *testing.T) {
+	workspace, err := os.MkdirTemp(tmpDir, "overlay-workspace")
+	if err != nil {
+		t.Fatalf("unable to create directory: %v", err)
+	}
+
+	extractBundle(bundle, workspace)
+
+	first := createOverlay(t, map[string]string{
+		"ping.yaml":                         "---\n# first\n...\n",
+		"custom.yaml":                       "---\n...\n",
+		"group_vars/all.yaml":               "---\npmm_version: '2'\n...\n",
+		"roles/custom/tasks/main.yaml":      "---\n...\n",
+		"roles/pmm/defaults/main.yaml":      "---\n...\n",
+		"roles/pmm-client/tasks/extra.yaml": "---\n...\n",
+	})
+	defer os.RemoveAll(first)
+
+	second := createOverlay(t, map[string]string{
+		"ping.yaml": "---\n# second\n...\n",
+	})
+	defer os.RemoveAll(second)
+
+	shadowed, err := applyOverlays([]string{first, second}, workspace)
+	if err != nil {
+		t.Fatalf("failed to applyOverlays: %v", err)
+	}
+
+	expected := []ShadowedFile{
+		{Path: "ping.yaml", Overlay: first, Source: bundleSource},
+		{Path: filepath.Join("roles", "pmm", "defaults", "main.yaml"), Overlay: first, Source: bundleSource},
+		{Path: "ping.yaml", Overlay: second, Source: first},
+	}
+
+	for _, e := range expected {
+		if !slices.Contains(shadowed, e) {
+			t.Fatalf("expected %v in %v", e, shadowed)
+		}
+	}
+
+	if len(shadowed) != len(expected) {
+		t.Fatalf("expected %d shadowed files, got %v", len(expected), shadowed)
+	}
+
+	if c, err := os.ReadFile(filepath.Join(workspace, "ping.yaml")); err != nil || string(c) != "---\n# second\n...\n" {
+		t.Fatalf("expected ping.yaml from the second overlay, got %q (%v)", c, err)
+	}
+
+	for _, p := range []string{"custom.yaml", "group_vars/all.yaml", "roles/custom/tasks/main.yaml", "roles/pmm/tasks/main.yaml"} {
+		if _, err := os.Stat(filepath.Join(workspace, p)); err != nil {
+			t.Fatalf("expected '%s' in the workspace: %v", p, err)
+		}
+	}
+
+	if plays := overlayPlaybooks([]string{first, second}); !slices.Equal(plays, []string{"custom.yaml", "ping.yaml"}) {
+		t.Fatalf("expected overlay playbooks, got %v", plays)
+	}
+
+	Config.Overlays = []string{first}
+	defer func() {
+		Config.Overlays = []string{}
+	}()
+
+	if !checkPlaybook("custom.yaml") {
+		t.Fatalf("expected custom.yaml to be an available playbook")
+	}
+
+	if _, err := checkOverlay(filepath.Join(first, "ping.yaml")); err == nil {
+		t.Fatalf("expected an error for a file used as an overlay")
+	}
+}
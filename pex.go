@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+// PexVariant describes an embedded Ansible PEX
+type PexVariant struct {
+	Arch   string
+	Name   string
+	Python string
+}
+
+// PythonInterpreter describes a local Python that can run a PEX
+type PythonInterpreter struct {
+	Path    string
+	Version string
+}
+
+var (
+	// PythonSearchPaths are globs used to locate interpreters outside of PATH,
+	// such as those provided by Software Collections
+	PythonSearchPaths = []string{
+		"/opt/rh/rh-python3*/root/usr/bin/python3.*",
+		"/opt/rh/rh-python3*/root/bin/python3.*",
+	}
+
+	pexVariantName = regexp.MustCompile(`^ansible-py(\d+\.\d+)-(\w+)\.pex\.gz$`)
+	pythonName     = regexp.MustCompile(`^python\d+\.\d+$`)
+)
+
+func parsePexVariantName(name string) (PexVariant, bool) {
+	m := pexVariantName.FindStringSubmatch(name)
+	if m == nil {
+		return PexVariant{}, false
+	}
+
+	return PexVariant{Arch: m[2], Name: name, Python: m[1]}, true
+}
+
+func pythonVersion(path string) (string, error) {
+	out, err := exec.Command(path, "-c", `import sys; print("%d.%d" % sys.version_info[:2])`).Output()
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+func probePythonInterpreters(variants []PexVariant) []PythonInterpreter {
+	var interpreters []PythonInterpreter
+
+	candidates := []string{}
+
+	for _, v := range variants {
+		if p, err := exec.LookPath("python" + v.Python); err == nil {
+			candidates = append(candidates, p)
+		}
+	}
+
+	for _, g := range PythonSearchPaths {
+		matches, _ := filepath.Glob(g)
+		for _, m := range matches {
+			if pythonName.MatchString(filepath.Base(m)) {
+				candidates = append(candidates, m)
+			}
+		}
+	}
+
+	if p, err := exec.LookPath("python3"); err == nil {
+		candidates = append(candidates, p)
+	}
+
+	seen := []string{}
+
+	for _, c := range candidates {
+		if slices.Contains(seen, c) {
+			continue
+		}
+
+		seen = append(seen, c)
+
+		v, err := pythonVersion(c)
+		if err != nil {
+			Logger.Debug("unable to determine the version of '%s': %v", c, err)
+			continue
+		}
+
+		Logger.Debug("found Python %s at '%s'", v, c)
+		interpreters = append(interpreters, PythonInterpreter{Path: c, Version: v})
+	}
+
+	return interpreters
+}
+
+func selectPexVariant(variants []PexVariant, interpreters []PythonInterpreter, arch string) (PexVariant, PythonInterpreter, error) {
+	for _, i := range interpreters {
+		for _, v := range variants {
+			if v.Arch == arch && v.Python == i.Version {
+				return v, i, nil
+			}
+		}
+	}
+
+	available := []string{}
+	for _, v := range variants {
+		available = append(available, fmt.Sprintf("python%s/%s", v.Python, v.Arch))
+	}
+
+	return PexVariant{}, PythonInterpreter{}, fmt.Errorf("no compatible Python found for the embedded PEX (%s)", strings.Join(available, ", "))
+}
+
+func selectAnsiblePex() error {
+	variants := pexVariants()
+
+	// A single PEX is left to its shebang, so there is nothing to probe
+	if len(variants) == 1 {
+		var err error
+
+		pex, err = readPexVariant(variants[0])
+
+		return err
+	}
+
+	v, i, err := selectPexVariant(variants, probePythonInterpreters(variants), runtime.GOARCH)
+	if err != nil {
+		return err
+	}
+
+	if pex, err = readPexVariant(v); err != nil {
+		return fmt.Errorf("unable to read PEX for python%s/%s: %w", v.Python, v.Arch, err)
+	}
+
+	Logger.Debug("using python%s/%s with '%s'", v.Python, v.Arch, i.Path)
+	AnsiblePython = i.Path
+	os.Setenv("PEX_PYTHON", i.Path)
+
+	return nil
+}
@@ -0,0 +1,50 @@
+//go:build fat
+
+package main
+
+import (
+	"compress/gzip"
+	"embed"
+	"io"
+	"path"
+)
+
+//go:embed build/pex
+var embeddedPex embed.FS
+
+func pexVariants() []PexVariant {
+	var variants []PexVariant
+
+	entries, err := embeddedPex.ReadDir("build/pex")
+	if err != nil {
+		Logger.Fatal("unable to read the embedded PEX variants: %v", err)
+	}
+
+	for _, e := range entries {
+		if v, ok := parsePexVariantName(e.Name()); ok {
+			variants = append(variants, v)
+		}
+	}
+
+	return variants
+}
+
+func pexFlavour() string {
+	return "fat"
+}
+
+func readPexVariant(v PexVariant) ([]byte, error) {
+	f, err := embeddedPex.Open(path.Join("build/pex", v.Name))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	return io.ReadAll(gz)
+}
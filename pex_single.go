@@ -0,0 +1,23 @@
+//go:build !fat
+
+package main
+
+import (
+	_ "embed"
+	"runtime"
+)
+
+//go:embed build/ansible
+var embeddedPex []byte
+
+func pexVariants() []PexVariant {
+	return []PexVariant{{Arch: runtime.GOARCH, Name: "ansible", Python: PythonVersion}}
+}
+
+func readPexVariant(v PexVariant) ([]byte, error) {
+	return embeddedPex, nil
+}
+
+func pexFlavour() string {
+	return PythonVersion
+}
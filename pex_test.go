@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestPexVariants(t *testing.T) {
+	names := map[string]bool{
+		"ansible-py3.9-amd64.pex.gz":  true,
+		"ansible-py3.11-arm64.pex.gz": true,
+		"ansible-py3.9-amd64.pex":     false,
+		"ansible3.9":                  false,
+	}
+
+	for name, valid := range names {
+		if _, ok := parsePexVariantName(name); ok != valid {
+			t.Fatalf("expected %v for '%s', got %v", valid, name, ok)
+		}
+	}
+
+	v, _ := parsePexVariantName("ansible-py3.11-arm64.pex.gz")
+	if v.Python != "3.11" || v.Arch != "arm64" {
+		t.Fatalf("unexpected variant: %v", v)
+	}
+
+	variants := []PexVariant{
+		{Arch: "amd64", Name: "ansible-py3.6-amd64.pex.gz", Python: "3.6"},
+		{Arch: "amd64", Name: "ansible-py3.8-amd64.pex.gz", Python: "3.8"},
+		{Arch: "arm64", Name: "ansible-py3.9-arm64.pex.gz", Python: "3.9"},
+	}
+	interpreters := []PythonInterpreter{
+		{Path: "/usr/bin/python3.9", Version: "3.9"},
+		{Path: "/opt/rh/rh-python38/root/usr/bin/python3.8", Version: "3.8"},
+		{Path: "/usr/bin/python3.6", Version: "3.6"},
+	}
+
+	v, i, err := selectPexVariant(variants, interpreters, "amd64")
+	if err != nil {
+		t.Fatalf("failed to selectPexVariant: %v", err)
+	}
+
+	if v.Python != "3.8" || i.Path != "/opt/rh/rh-python38/root/usr/bin/python3.8" {
+		t.Fatalf("expected the first compatible interpreter, got %v with %v", v, i)
+	}
+
+	if _, _, err := selectPexVariant(variants, interpreters[2:], "arm64"); err == nil {
+		t.Fatalf("expected an error without a compatible interpreter")
+	}
+}
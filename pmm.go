@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	pmmAPIAgentsList     = "/v1/inventory/Agents/List"
+	pmmAPINodesList      = "/v1/inventory/Nodes/List"
+	pmmAPINodesRemove    = "/v1/inventory/Nodes/Remove"
+	pmmAPIReadyz         = "/v1/readyz"
+	pmmAPIServicesList   = "/v1/inventory/Services/List"
+	pmmAPIServicesRemove = "/v1/inventory/Services/Remove"
+	pmmAPISettings       = "/v1/Settings/Get"
+	pmmAPIVersion        = "/v1/version"
+	pmmAgentTypePMM      = "pmm_agent"
+	pmmServerNodeID      = "pmm-server"
+	pmmTokenUsername     = "api_key"
+
+	defaultPMMRequestTimeout = 10
+)
+
+// PMMClient makes requests to the API of PMM Server
+type PMMClient struct {
+	BaseURL  string
+	Client   *http.Client
+	Password string
+	Username string
+}
+
+// PMMAgent is an agent registered with PMM Server, i.e. a pmm-agent on a node or an exporter
+// that it runs for a service
+type PMMAgent struct {
+	AgentID      string `json:"agent_id"`
+	AgentType    string `json:"-"`
+	Connected    bool   `json:"connected"`
+	Disabled     bool   `json:"disabled"`
+	NodeID       string `json:"node_id"`
+	PMMAgentID   string `json:"pmm_agent_id"`
+	RunsOnNodeID string `json:"runs_on_node_id"`
+	ServiceID    string `json:"service_id"`
+	Status       string `json:"status"`
+}
+
+// PMMNode is a node registered with PMM Server
+type PMMNode struct {
+	Address      string            `json:"address"`
+	CustomLabels map[string]string `json:"custom_labels"`
+	Distro       string            `json:"distro"`
+	NodeID       string            `json:"node_id"`
+	NodeName     string            `json:"node_name"`
+	NodeType     string            `json:"-"`
+	Region       string            `json:"region"`
+}
+
+// PMMService is a service registered with PMM Server
+type PMMService struct {
+	Address     string `json:"address"`
+	Cluster     string `json:"cluster"`
+	Environment string `json:"environment"`
+	NodeID      string `json:"node_id"`
+	Port        int    `json:"port"`
+	ServiceID   string `json:"service_id"`
+	ServiceName string `json:"service_name"`
+	ServiceType string `json:"-"`
+	Socket      string `json:"socket"`
+}
+
+// PMMSettings are the server settings that are of interest when checking a deployment
+type PMMSettings struct {
+	DataRetention    string `json:"data_retention"`
+	PMMPublicAddress string `json:"pmm_public_address"`
+	TelemetryEnabled bool   `json:"telemetry_enabled"`
+	UpdatesDisabled  bool   `json:"updates_disabled"`
+}
+
+// PMMVersion is the version of PMM Server
+type PMMVersion struct {
+	Server struct {
+		FullVersion string `json:"full_version"`
+		Version     string `json:"version"`
+	} `json:"server"`
+	Version string `json:"version"`
+}
+
+// AgentStatus is the status without the prefix that is used by later versions, e.g. RUNNING
+func (a PMMAgent) AgentStatus() string {
+	return strings.TrimPrefix(a.Status, "AGENT_STATUS_")
+}
+
+// String is the version of the server, falling back to the version of the API
+func (v PMMVersion) String() string {
+	if v.Server.Version != "" {
+		return v.Server.Version
+	}
+
+	return v.Version
+}
+
+// newPMMClient uses the credentials from the URI when present, otherwise the credentials
+// are either "username:password" or a token, as per pmm_admin_credentials
+func newPMMClient(uri string, credentials string, insecure bool) (*PMMClient, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("expected an http or https URL for PMM Server, got '%s'", uri)
+	}
+
+	if credentials == "" {
+		credentials = os.Getenv("GASCAN_PMM_CREDENTIALS")
+	}
+
+	if u.User != nil {
+		credentials = u.User.Username()
+		if p, ok := u.User.Password(); ok {
+			credentials = u.User.Username() + ":" + p
+		}
+
+		u.User = nil
+	}
+
+	c := &PMMClient{
+		BaseURL: strings.TrimSuffix(u.String(), "/"),
+		Client: &http.Client{
+			Timeout: defaultPMMRequestTimeout * time.Second,
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: insecure},
+			},
+		},
+	}
+
+	if user, pass, ok := strings.Cut(credentials, ":"); ok {
+		c.Username, c.Password = user, pass
+	} else if credentials != "" {
+		c.Username, c.Password = pmmTokenUsername, credentials
+	}
+
+	return c, nil
+}
+
+func (c *PMMClient) request(method string, path string, payload interface{}, out interface{}) error {
+	body := []byte{}
+
+	if payload != nil {
+		b, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+
+		body = b
+	}
+
+	var reader io.Reader
+	if method != http.MethodGet {
+		reader = bytes.NewBuffer(body)
+	}
+
+	req, err := http.NewRequest(method, c.BaseURL+path, reader)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	if c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+
+	Logger.Debug("requesting %s %s", method, req.URL)
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("request to '%s' failed: %s", path, resp.Status)
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("unexpected response from '%s': %w", path, err)
+	}
+
+	return nil
+}
+
+// ListNodes returns the nodes of each type, e.g. generic or remote_rds
+func (c *PMMClient) ListNodes() ([]PMMNode, error) {
+	resp := map[string][]PMMNode{}
+	nodes := []PMMNode{}
+
+	if err := c.request(http.MethodPost, pmmAPINodesList, map[string]string{}, &resp); err != nil {
+		return nil, err
+	}
+
+	for _, t := range sortedKeys(resp) {
+		for _, n := range resp[t] {
+			n.NodeType = t
+			nodes = append(nodes, n)
+		}
+	}
+
+	return nodes, nil
+}
+
+// ListServices returns the services of each type, e.g. mysql or haproxy
+func (c *PMMClient) ListServices() ([]PMMService, error) {
+	resp := map[string][]PMMService{}
+	services := []PMMService{}
+
+	if err := c.request(http.MethodPost, pmmAPIServicesList, map[string]string{}, &resp); err != nil {
+		return nil, err
+	}
+
+	for _, t := range sortedKeys(resp) {
+		for _, s := range resp[t] {
+			s.ServiceType = t
+			services = append(services, s)
+		}
+	}
+
+	return services, nil
+}
+
+// Ready checks that the server is ready to accept requests, which does not need credentials
+func (c *PMMClient) Ready() error {
+	return c.request(http.MethodGet, pmmAPIReadyz, nil, nil)
+}
+
+// Version returns the version of the server
+func (c *PMMClient) Version() (PMMVersion, error) {
+	v := PMMVersion{}
+
+	return v, c.request(http.MethodGet, pmmAPIVersion, nil, &v)
+}
+
+// Settings returns the settings of the server
+func (c *PMMClient) Settings() (PMMSettings, error) {
+	resp := struct {
+		Settings PMMSettings `json:"settings"`
+	}{}
+
+	return resp.Settings, c.request(http.MethodPost, pmmAPISettings, map[string]string{}, &resp)
+}
+
+// ListAgents returns the agents of each type, e.g. pmm_agent or mysqld_exporter
+func (c *PMMClient) ListAgents() ([]PMMAgent, error) {
+	resp := map[string][]PMMAgent{}
+	agents := []PMMAgent{}
+
+	if err := c.request(http.MethodPost, pmmAPIAgentsList, map[string]string{}, &resp); err != nil {
+		return nil, err
+	}
+
+	for _, t := range sortedKeys(resp) {
+		for _, a := range resp[t] {
+			a.AgentType = t
+			agents = append(agents, a)
+		}
+	}
+
+	return agents, nil
+}
+
+// RemoveNode unregisters a node, along with its agents and services
+func (c *PMMClient) RemoveNode(nodeID string) error {
+	return c.request(http.MethodPost, pmmAPINodesRemove, map[string]interface{}{"node_id": nodeID, "force": true}, nil)
+}
+
+// RemoveService unregisters a service, along with its agents
+func (c *PMMClient) RemoveService(serviceID string) error {
+	return c.request(http.MethodPost, pmmAPIServicesRemove, map[string]interface{}{"service_id": serviceID, "force": true}, nil)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const (
+	pmmDummyNodes    = `{"generic": [{"node_id": "pmm-server", "node_name": "pmm-server", "address": "127.0.0.1"}, {"node_id": "n1", "node_name": "db1", "address": "10.0.0.1"}], "remote_rds": [{"node_id": "n2", "node_name": "rds1", "address": "rds1.abc.eu-west-1.rds.amazonaws.com", "region": "eu-west-1"}]}`
+	pmmDummyAgents   = `{"pmm_agent": [{"agent_id": "pa0", "runs_on_node_id": "pmm-server", "connected": true}, {"agent_id": "pa1", "runs_on_node_id": "n1", "connected": true}], "mysqld_exporter": [{"agent_id": "a1", "pmm_agent_id": "pa1", "service_id": "s1", "status": "RUNNING"}, {"agent_id": "a3", "pmm_agent_id": "pa0", "service_id": "s3", "status": "AGENT_STATUS_WAITING"}], "postgres_exporter": [{"agent_id": "a0", "pmm_agent_id": "pa0", "service_id": "s0", "status": "RUNNING"}], "proxysql_exporter": [{"agent_id": "a2", "pmm_agent_id": "pa1", "service_id": "s2", "status": "DONE", "disabled": true}]}`
+	pmmDummyServices = `{"mysql": [{"service_id": "s1", "service_name": "db1-mysql", "node_id": "n1", "address": "127.0.0.1", "port": 3306}, {"service_id": "s3", "service_name": "rds1-mysql", "node_id": "n2", "address": "rds1.abc.eu-west-1.rds.amazonaws.com", "port": 3306}], "postgresql": [{"service_id": "s0", "service_name": "pmm-server-postgresql", "node_id": "pmm-server"}], "proxysql": [{"service_id": "s2", "service_name": "db1-proxysql", "node_id": "n1", "port": 6032}], "external": [{"service_id": "s4", "service_name": "exporter", "node_id": "n1"}]}`
+)
+
+func newPMMDummyServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == pmmAPIReadyz {
+			w.Write([]byte("{}"))
+			return
+		}
+
+		if user, pass, ok := r.BasicAuth(); !ok || user != "admin" || pass != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.Path {
+		case pmmAPIAgentsList:
+			w.Write([]byte(pmmDummyAgents))
+		case pmmAPISettings:
+			w.Write([]byte(`{"settings": {"data_retention": "2592000s", "telemetry_enabled": true}}`))
+		case pmmAPIVersion:
+			w.Write([]byte(`{"version": "2.41.0", "server": {"version": "2.41.0", "full_version": "2.41.0-1.2402"}}`))
+		case pmmAPINodesList:
+			w.Write([]byte(pmmDummyNodes))
+		case pmmAPINodesRemove, pmmAPIServicesRemove:
+			req := map[string]interface{}{}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req["force"] != true {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			id := req["node_id"]
+			if r.URL.Path == pmmAPIServicesRemove {
+				id = req["service_id"]
+			}
+
+			if !strings.Contains(pmmDummyNodes+pmmDummyServices, fmt.Sprintf(`_id": "%v"`, id)) {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+
+			w.Write([]byte("{}"))
+		case pmmAPIServicesList:
+			w.Write([]byte(pmmDummyServices))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestPMMClient(t *testing.T) {
+	srv := newPMMDummyServer(t)
+	defer srv.Close()
+
+	for credentials, expected := range map[string][2]string{
+		"admin:s3cret": {"admin", "s3cret"},
+		"token":        {pmmTokenUsername, "token"},
+	} {
+		c, err := newPMMClient(srv.URL+"/", credentials, false)
+		if err != nil || c.Username != expected[0] || c.Password != expected[1] || c.BaseURL != srv.URL {
+			t.Fatalf("expected %v for %s, got %+v (%v)", expected, credentials, c, err)
+		}
+	}
+
+	if _, err := newPMMClient("ftp://monitor", "", false); err == nil {
+		t.Fatalf("expected an error for an unsupported scheme")
+	}
+
+	c, _ := newPMMClient("http://admin:wrong@"+srv.Listener.Addr().String(), "", false)
+	if _, err := c.ListNodes(); err == nil {
+		t.Fatalf("expected an error for the wrong credentials")
+	}
+
+	c, _ = newPMMClient("http://admin:s3cret@"+srv.Listener.Addr().String(), "", false)
+
+	nodes, err := c.ListNodes()
+	if err != nil || len(nodes) != 3 || nodes[2].NodeType != "remote_rds" {
+		t.Fatalf("expected 3 nodes, got %+v (%v)", nodes, err)
+	}
+
+	services, err := c.ListServices()
+	if err != nil || len(services) != 5 || services[0].ServiceType != "external" {
+		data, _ := json.Marshal(services)
+		t.Fatalf("expected 5 services, got %s (%v)", data, err)
+	}
+
+	agents, err := c.ListAgents()
+	if err != nil || len(agents) != 6 || agents[0].AgentType != "mysqld_exporter" || agents[1].AgentStatus() != "WAITING" {
+		t.Fatalf("expected 6 agents, got %+v (%v)", agents, err)
+	}
+
+	if v, err := c.Version(); err != nil || v.String() != "2.41.0" {
+		t.Fatalf("expected the version of the server, got %+v (%v)", v, err)
+	}
+}
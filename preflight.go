@@ -0,0 +1,402 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	defaultPreflightConcurrency = 16
+	defaultPreflightTimeout     = 5 * time.Second
+)
+
+// preflightServicePorts are the default ports for the services in each group, used unless
+// the port is set in the payload for pmm-admin, e.g. pmm_payload_add_mysql.port
+var preflightServicePorts = map[string]int{
+	"mongodb":    27017,
+	"mysql":      3306,
+	"postgresql": 5432,
+	"proxysql":   6032,
+}
+
+// sshCommand is used for the optional SSH check
+var sshCommand = "ssh"
+
+// PreflightTarget is a host from the inventory, along with how to reach it
+type PreflightTarget struct {
+	Address  string
+	Host     string
+	JumpHost string
+	Local    bool
+	Ports    map[string]int
+	SSHArgs  []string
+	SSHPort  int
+}
+
+// PreflightCheck is the result of a single check for a host
+type PreflightCheck struct {
+	Err     error
+	Host    string
+	Latency time.Duration
+	Name    string
+	Skipped string
+	Target  string
+}
+
+// Failed checks for an error, rather than a check that was skipped
+func (c PreflightCheck) Failed() bool {
+	return c.Err != nil
+}
+
+func (c PreflightCheck) Result() string {
+	switch {
+	case c.Err != nil:
+		return "FAILED: " + preflightReason(c.Err)
+	case c.Skipped != "":
+		return "skipped: " + c.Skipped
+	default:
+		return "ok"
+	}
+}
+
+// preflightReason shortens network errors to the cause, e.g. "connection refused"
+func preflightReason(err error) string {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		if dnsErr.IsNotFound {
+			return "no such host"
+		}
+
+		if dnsErr.IsTimeout {
+			return "lookup timed out"
+		}
+
+		return dnsErr.Err
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		if opErr.Timeout() {
+			return "timed out"
+		}
+
+		var sysErr *os.SyscallError
+		if errors.As(opErr.Err, &sysErr) {
+			return sysErr.Err.Error()
+		}
+
+		return opErr.Err.Error()
+	}
+
+	return err.Error()
+}
+
+// sshArgs converts the connection variables to arguments for ssh
+func sshArgs(vars map[string]interface{}) []string {
+	args := []string{}
+
+	if u := stringVar(vars, "ansible_user", "ansible_ssh_user"); u != "" {
+		args = append(args, "-l", u)
+	}
+
+	if k := stringVar(vars, "ansible_ssh_private_key_file", "ansible_private_key_file"); k != "" {
+		if strings.HasPrefix(k, "~/") {
+			k = filepath.Join(os.Getenv("HOME"), k[2:])
+		}
+
+		args = append(args, "-i", k)
+	}
+
+	for _, name := range []string{"ansible_ssh_common_args", "ansible_ssh_extra_args"} {
+		if words, err := splitShellWords(stringVar(vars, name)); err == nil {
+			args = append(args, words...)
+		}
+	}
+
+	return args
+}
+
+// preflightTargets finds the address and ports to check for each host
+func preflightTargets(inv *Inventory, jumpHost string) []PreflightTarget {
+	targets := []PreflightTarget{}
+
+	for _, h := range inv.HostNames() {
+		vars := inv.HostVars(h)
+		t := PreflightTarget{
+			Address:  stringVar(vars, "ansible_host", "ansible_ssh_host"),
+			Host:     h,
+			JumpHost: jumpHostFor(h, vars, jumpHost),
+			Local:    stringVar(vars, "ansible_connection") == "local",
+			Ports:    map[string]int{},
+			SSHArgs:  sshArgs(vars),
+			SSHPort:  defaultSSHPort,
+		}
+
+		if t.Address == "" {
+			t.Address = h
+		}
+
+		// Jump hosts that are not in the SSH arguments for Ansible are added for the ssh check
+		if t.JumpHost != "" && proxyJump(vars) == "" {
+			t.SSHArgs = append(t.SSHArgs, "-J", t.JumpHost)
+		}
+
+		if p, err := strconv.Atoi(stringVar(vars, "ansible_port", "ansible_ssh_port")); err == nil {
+			t.SSHPort = p
+		}
+
+		groups := inv.HostGroups(h)
+		for svc, port := range preflightServicePorts {
+			if !slices.Contains(groups, svc) {
+				continue
+			}
+
+			payload, _ := vars["pmm_payload_add_"+svc].(map[string]interface{})
+			if p, err := strconv.Atoi(stringVar(payload, "port")); err == nil {
+				port = p
+			}
+
+			t.Ports[svc] = port
+		}
+
+		targets = append(targets, t)
+	}
+
+	return targets
+}
+
+// Preflight checks whether the hosts in the inventory can be reached
+type Preflight struct {
+	Concurrency int
+	Dialer      *net.Dialer
+	KnownHosts  string
+	Resolver    *net.Resolver
+	SSH         bool
+	Timeout     time.Duration
+}
+
+func (p Preflight) dial(ctx context.Context, host string, name string, address string, port int) PreflightCheck {
+	target := net.JoinHostPort(address, strconv.Itoa(port))
+	c := PreflightCheck{Host: host, Name: name, Target: target}
+
+	ctx, cancel := context.WithTimeout(ctx, p.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	conn, err := p.Dialer.DialContext(ctx, "tcp", target)
+	c.Latency = time.Since(start)
+
+	if err != nil {
+		c.Err = err
+		return c
+	}
+
+	conn.Close()
+
+	return c
+}
+
+// ssh authenticates in batch mode, so that a missing key or an unknown host key is reported,
+// rather than prompting for input
+func (p Preflight) ssh(ctx context.Context, t PreflightTarget) PreflightCheck {
+	c := PreflightCheck{Host: t.Host, Name: "ssh", Target: t.Address}
+
+	ctx, cancel := context.WithTimeout(ctx, 2*p.Timeout)
+	defer cancel()
+
+	args := []string{
+		"-o", "BatchMode=yes",
+		"-o", fmt.Sprintf("ConnectTimeout=%d", max(1, int(p.Timeout.Seconds()))),
+		"-p", strconv.Itoa(t.SSHPort),
+	}
+	if p.KnownHosts != "" {
+		args = append(args, hostKeySSHArgs(p.KnownHosts)...)
+	}
+
+	args = append(append(args, t.SSHArgs...), t.Address, "true")
+
+	Logger.Debug("running %s %s", sshCommand, strings.Join(args, " "))
+
+	stderr := bytes.Buffer{}
+	cmd := exec.CommandContext(ctx, sshCommand, args...)
+	cmd.Stderr = &stderr
+
+	start := time.Now()
+	err := cmd.Run()
+	c.Latency = time.Since(start)
+
+	if err != nil {
+		lines := strings.Split(strings.TrimSpace(stderr.String()), "\n")
+		if reason := strings.TrimSpace(lines[len(lines)-1]); reason != "" {
+			err = errors.New(reason)
+		}
+
+		c.Err = err
+	}
+
+	return c
+}
+
+// Check runs the checks for a host in order, skipping the rest once the address is unknown;
+// the ssh check also needs the ssh port to be reachable
+func (p Preflight) Check(ctx context.Context, t PreflightTarget) []PreflightCheck {
+	if t.Local {
+		return []PreflightCheck{{Host: t.Host, Name: "connection", Target: "local", Skipped: "ansible_connection is local"}}
+	}
+
+	services := sortedKeys(t.Ports)
+
+	if t.JumpHost != "" {
+		// The address is only resolved and reached from the jump host, so ssh is the only check
+		checks := []PreflightCheck{{Host: t.Host, Name: "dns", Target: t.Address, Skipped: "connects via " + t.JumpHost}}
+
+		for _, name := range append([]string{"ssh"}, services...) {
+			port := t.SSHPort
+			if name != "ssh" {
+				port = t.Ports[name]
+			}
+
+			checks = append(checks, PreflightCheck{
+				Host:    t.Host,
+				Name:    "tcp/" + name,
+				Target:  net.JoinHostPort(t.Address, strconv.Itoa(port)),
+				Skipped: "connects via " + t.JumpHost,
+			})
+		}
+
+		if p.SSH {
+			checks = append(checks, p.ssh(ctx, t))
+		}
+
+		return checks
+	}
+
+	dns := PreflightCheck{Host: t.Host, Name: "dns", Target: t.Address}
+	lookup, cancel := context.WithTimeout(ctx, p.Timeout)
+	start := time.Now()
+	_, dns.Err = p.Resolver.LookupHost(lookup, t.Address)
+	dns.Latency = time.Since(start)
+	cancel()
+
+	checks := []PreflightCheck{dns}
+	if dns.Err != nil {
+		return checks
+	}
+
+	checks = append(checks, p.dial(ctx, t.Host, "tcp/ssh", t.Address, t.SSHPort))
+
+	for _, svc := range services {
+		checks = append(checks, p.dial(ctx, t.Host, "tcp/"+svc, t.Address, t.Ports[svc]))
+	}
+
+	if p.SSH && checks[1].Err == nil {
+		checks = append(checks, p.ssh(ctx, t))
+	}
+
+	return checks
+}
+
+// Run checks the hosts concurrently, returning the results in the order of the targets
+func (p Preflight) Run(ctx context.Context, targets []PreflightTarget) []PreflightCheck {
+	results := make([][]PreflightCheck, len(targets))
+	limit := make(chan struct{}, max(1, p.Concurrency))
+	wg := sync.WaitGroup{}
+
+	for i, t := range targets {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			limit <- struct{}{}
+			defer func() { <-limit }()
+
+			results[i] = p.Check(ctx, t)
+		}()
+	}
+
+	wg.Wait()
+
+	return slices.Concat(results...)
+}
+
+func writePreflightResults(w io.Writer, checks []PreflightCheck) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "HOST\tCHECK\tTARGET\tLATENCY\tRESULT")
+
+	for _, c := range checks {
+		latency := "-"
+		if c.Skipped == "" {
+			latency = c.Latency.Round(100 * time.Microsecond).String()
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", c.Host, c.Name, c.Target, latency, c.Result())
+	}
+
+	tw.Flush()
+}
+
+func preflightCommand(args []string) int {
+	fs := subcommandFlags("preflight", "[--inventory PATHS] [--jump-host HOST] [--ssh] [--concurrency N] [--timeout DURATION]")
+	concurrency := fs.Int("concurrency", defaultPreflightConcurrency, "Maximum number of hosts to check at once")
+	inventory := fs.String("inventory", "", "Comma-separated inventory sources, otherwise as per Ansible [ANSIBLE_INVENTORY, ~/.ansible.cfg]")
+	jumpHost := fs.String("jump-host", os.Getenv("GASCAN_FLAG_JUMP_HOST"), jumpHostUsage)
+	ssh := fs.Bool("ssh", false, "Authenticate with ssh in batch mode, using the configured user and key")
+	timeout := fs.Duration("timeout", defaultPreflightTimeout, "Timeout for each check")
+
+	if len(parseSubcommandFlags(fs, args)) > 0 {
+		fs.Usage()
+		return 1
+	}
+
+	sources := inventorySources(*inventory, filepath.Join(os.Getenv("HOME"), ".ansible.cfg"))
+	if sources == "" {
+		Logger.Error("an inventory is required")
+		return 1
+	}
+
+	inv, err := loadInventory(sources)
+	if err != nil {
+		Logger.Error("unable to load the inventory: %v", err)
+		return 1
+	}
+
+	p := Preflight{
+		Concurrency: *concurrency,
+		Dialer:      &net.Dialer{},
+		KnownHosts:  knownHostsPath(),
+		Resolver:    net.DefaultResolver,
+		SSH:         *ssh,
+		Timeout:     *timeout,
+	}
+
+	checks := p.Run(context.Background(), preflightTargets(inv, *jumpHost))
+	writePreflightResults(os.Stdout, checks)
+
+	failed := 0
+	for _, c := range checks {
+		if c.Failed() {
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		Logger.Error("%d check(s) failed", failed)
+		return 1
+	}
+
+	return 0
+}
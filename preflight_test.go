@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPreflight(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+	defer ln.Close()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			conn.Close()
+		}
+	}()
+
+	// A port that was open and is now closed, so that the connection is refused
+	closed, _ := net.Listen("tcp", "127.0.0.1:0")
+	closedPort := closed.Addr().(*net.TCPAddr).Port
+	closed.Close()
+
+	port := ln.Addr().(*net.TCPAddr).Port
+	dir := writeInventoryFiles(t, map[string]string{"hosts.yaml": fmt.Sprintf(`---
+all:
+  children:
+    monitors:
+      hosts:
+        monitor:
+          ansible_connection: local
+    mysql:
+      hosts:
+        db1:
+          ansible_host: 127.0.0.1
+          ansible_port: %[1]d
+          ansible_user: admin
+          pmm_payload_add_mysql:
+            port: %[2]d
+        db2:
+          ansible_host: missing.invalid
+        db3:
+          ansible_host: 10.0.0.3
+          ansible_ssh_common_args: -o ProxyJump=bastion
+...
+`, port, closedPort)})
+
+	inv, err := loadInventory(filepath.Join(dir, "hosts.yaml"))
+	if err != nil {
+		t.Fatalf("failed to loadInventory: %v", err)
+	}
+
+	targets := preflightTargets(inv, "")
+	if len(targets) != 4 || targets[0].Host != "db1" || targets[0].SSHPort != port || targets[0].Ports["mysql"] != closedPort {
+		t.Fatalf("unexpected targets: %+v", targets)
+	}
+
+	if targets[1].Ports["mysql"] != 3306 || targets[2].JumpHost != "bastion" || !targets[3].Local {
+		t.Fatalf("unexpected targets: %+v", targets)
+	}
+
+	defer func(cmd string) { sshCommand = cmd }(sshCommand)
+
+	sshCommand = filepath.Join(t.TempDir(), "ssh")
+	if err := os.WriteFile(sshCommand, []byte("#!/bin/sh\necho 'warning: ignored' >&2\necho 'admin@127.0.0.1: Permission denied (publickey).' >&2\nexit 255\n"), 0o700); err != nil {
+		t.Fatalf("unable to write '%s': %v", sshCommand, err)
+	}
+
+	p := Preflight{Concurrency: 2, Dialer: &net.Dialer{}, Resolver: net.DefaultResolver, SSH: true, Timeout: time.Second}
+	checks := p.Run(context.Background(), targets)
+
+	out := bytes.Buffer{}
+	writePreflightResults(&out, checks)
+
+	expected := map[string]string{
+		"db1 tcp/ssh":        "ok",
+		"db1 tcp/mysql":      "FAILED: connection refused",
+		"db1 ssh":            "FAILED: admin@127.0.0.1: Permission denied (publickey).",
+		"db2 dns":            "FAILED: ",
+		"db3 tcp/ssh":        "skipped: connects via bastion",
+		"monitor connection": "skipped: ansible_connection is local",
+	}
+
+	for name, result := range expected {
+		found := false
+
+		for _, c := range checks {
+			if c.Host+" "+c.Name == name {
+				found = true
+
+				if !strings.HasPrefix(c.Result(), result) {
+					t.Fatalf("expected %q for %s, got %q", result, name, c.Result())
+				}
+			}
+		}
+
+		if !found {
+			t.Fatalf("expected a check for %s in:\n%s", name, out.String())
+		}
+	}
+
+	for _, c := range checks {
+		if c.Host == "db2" && c.Name != "dns" {
+			t.Fatalf("expected the other checks to be skipped after the lookup failed, got %s", c.Name)
+		}
+	}
+}
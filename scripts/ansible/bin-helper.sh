@@ -19,4 +19,12 @@ case "${PEX_SCRIPT}" in
         *) echo "ERROR: unsupported item '${PEX_SCRIPT}'"; exit 1
 esac
 
+# Set by gascan when the PEX needs an interpreter that may not be on the PATH, e.g. from SCL
+ANSIBLE_PYTHON=""
+
+if [ -n "${ANSIBLE_PYTHON}" ]; then
+	export PEX_PYTHON="${ANSIBLE_PYTHON}"
+	exec "${ANSIBLE_PYTHON}" "${HOME}"/bin/ansible.pex "${@}"
+fi
+
 "${HOME}"/bin/ansible.pex "${@}"
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	sshConfigHeader = "# Generated by gascan ssh-config generate, changes here are replaced on the next run\n"
+	sshConfigUsage  = `ACTION [--inventory PATHS] [--jump-host HOST] [--output FILE] [--check]
+
+Actions:
+  generate            Write the Host entries for the inventory, to be used with Include in ~/.ssh/config
+`
+)
+
+// SSHHost is the SSH client config for a host from the inventory
+type SSHHost struct {
+	HostName     string
+	IdentityFile string
+	Name         string
+	Port         string
+	ProxyJump    string
+	User         string
+}
+
+// Options lists the options that are set, in the order they are written
+func (h SSHHost) Options() [][2]string {
+	options := [][2]string{}
+
+	for _, o := range [][2]string{
+		{"HostName", h.HostName},
+		{"Port", h.Port},
+		{"User", h.User},
+		{"IdentityFile", h.IdentityFile},
+		{"ProxyJump", h.ProxyJump},
+	} {
+		if o[1] != "" && o[1] != redactedValue {
+			options = append(options, o)
+		}
+	}
+
+	return options
+}
+
+// sshConfigPath is the file that is generated by default, in the directory used by Include
+func sshConfigPath() string {
+	return filepath.Join(os.Getenv("HOME"), ".ssh", "config.d", "gascan")
+}
+
+// sshHosts lists the hosts that Ansible connects to with SSH, using the same address, user,
+// port, key and jump host
+func sshHosts(inv *Inventory, hostVars map[string]map[string]interface{}, jumpHost string) []SSHHost {
+	hosts := []SSHHost{}
+
+	for _, h := range inv.HostNames() {
+		vars := hostVars[h]
+
+		if c := stringVar(vars, "ansible_connection"); c != "" && c != "ssh" && c != "paramiko" {
+			continue
+		}
+
+		hosts = append(hosts, SSHHost{
+			HostName:     stringVar(vars, "ansible_host", "ansible_ssh_host"),
+			IdentityFile: stringVar(vars, "ansible_ssh_private_key_file", "ansible_private_key_file"),
+			Name:         h,
+			Port:         stringVar(vars, "ansible_port", "ansible_ssh_port"),
+			ProxyJump:    jumpHostFor(h, vars, jumpHost),
+			User:         stringVar(vars, "ansible_user", "ansible_ssh_user"),
+		})
+	}
+
+	return hosts
+}
+
+// writeSSHHosts writes a Host entry for each host, separated by blank lines
+func writeSSHHosts(w io.Writer, hosts []SSHHost) error {
+	out := strings.Builder{}
+
+	for i, h := range hosts {
+		if i > 0 {
+			out.WriteString("\n")
+		}
+
+		fmt.Fprintf(&out, "Host %s\n", h.Name)
+
+		for _, o := range h.Options() {
+			fmt.Fprintf(&out, "  %s %s\n", o[0], o[1])
+		}
+	}
+
+	_, err := io.WriteString(w, out.String())
+
+	return err
+}
+
+// generateSSHConfig renders the config for the inventory, which is the same on every run for
+// the same inventory so that drift can be detected
+func generateSSHConfig(inv *Inventory, jumpHost string) string {
+	hostVars := map[string]map[string]interface{}{}
+	for _, h := range inv.HostNames() {
+		hostVars[h] = filterSecrets(inv.HostVars(h), "redact")
+	}
+
+	out := strings.Builder{}
+	out.WriteString(sshConfigHeader + "\n")
+
+	// Writing to a strings.Builder does not fail
+	_ = writeSSHHosts(&out, sshHosts(inv, hostVars, jumpHost))
+
+	return out.String()
+}
+
+// sshConfigIncluded checks whether ~/.ssh/config has an Include that could match the file
+func sshConfigIncluded(userConfig string, path string) bool {
+	data, err := os.ReadFile(userConfig)
+	if err != nil {
+		return false
+	}
+
+	sshDir := filepath.Dir(userConfig)
+
+	for _, ln := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(ln)
+		if len(fields) < 2 || !strings.EqualFold(fields[0], "include") {
+			continue
+		}
+
+		for _, pattern := range fields[1:] {
+			pattern = expandHome(pattern)
+			if !filepath.IsAbs(pattern) {
+				pattern = filepath.Join(sshDir, pattern)
+			}
+
+			if ok, _ := filepath.Match(pattern, path); ok {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// updateSSHConfig writes the config when it differs from the file, returning the changes
+func updateSSHConfig(path string, content string, check bool) (string, error) {
+	current, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+
+	diff := unifiedDiff(path, path+" (generated)", string(current), content)
+	if diff == "" || check {
+		return diff, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return "", err
+	}
+
+	return diff, writeFileAtomic(path, []byte(content), 0o600)
+}
+
+func sshConfigGenerateCommand(args []string) int {
+	fs := subcommandFlags("ssh-config generate", "[--inventory PATHS] [--jump-host HOST] [--output FILE] [--check]")
+	check := fs.Bool("check", false, "Show the changes and exit with 1 when the file differs from the inventory, without writing it")
+	inventory := fs.String("inventory", "", "Comma-separated inventory sources, otherwise as per Ansible [ANSIBLE_INVENTORY, ~/.ansible.cfg]")
+	jumpHost := fs.String("jump-host", os.Getenv("GASCAN_FLAG_JUMP_HOST"), jumpHostUsage)
+	output := fs.String("output", sshConfigPath(), "The file to write")
+
+	if len(parseSubcommandFlags(fs, args)) > 0 {
+		fs.Usage()
+		return 1
+	}
+
+	sources := inventorySources(*inventory, filepath.Join(os.Getenv("HOME"), ".ansible.cfg"))
+	if sources == "" {
+		Logger.Error("an inventory is required")
+		return 1
+	}
+
+	inv, err := loadInventory(sources)
+	if err != nil {
+		Logger.Error("unable to load the inventory: %v", err)
+		return 1
+	}
+
+	path := expandHome(*output)
+
+	diff, err := updateSSHConfig(path, generateSSHConfig(inv, *jumpHost), *check)
+	if err != nil {
+		Logger.Error("unable to update '%s': %v", path, err)
+		return 1
+	}
+
+	switch {
+	case diff == "":
+		fmt.Printf("'%s' is up to date\n", path)
+	case *check:
+		fmt.Print(diff)
+		Logger.Error("'%s' differs from the inventory", path)
+		return 1
+	default:
+		fmt.Printf("Updated '%s'\n", path)
+	}
+
+	userConfig := filepath.Join(os.Getenv("HOME"), ".ssh", "config")
+	if !sshConfigIncluded(userConfig, path) {
+		fmt.Printf("Add the following to the top of '%s' to use it:\nInclude %s\n", userConfig, path)
+	}
+
+	return 0
+}
+
+func sshConfigCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "Usage of ssh-config:\n  ssh-config %s", sshConfigUsage)
+		return 1
+	}
+
+	switch args[0] {
+	case "generate":
+		return sshConfigGenerateCommand(args[1:])
+	default:
+		Logger.Error("unknown action '%s'", args[0])
+		fmt.Fprintf(os.Stderr, "Usage of ssh-config:\n  ssh-config %s", sshConfigUsage)
+		return 1
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGenerateSSHConfig(t *testing.T) {
+	dir := writeInventoryFiles(t, map[string]string{"hosts.yaml": inventoryExportDummy})
+
+	inv, err := loadInventory(filepath.Join(dir, "hosts.yaml"))
+	if err != nil {
+		t.Fatalf("failed to loadInventory: %v", err)
+	}
+
+	content := generateSSHConfig(inv, "")
+	if !strings.HasPrefix(content, sshConfigHeader+"\nHost db1\n  HostName 10.0.0.1\n  Port 2222\n  ProxyJump bastion\n") {
+		t.Fatalf("unexpected config:\n%s", content)
+	}
+
+	path := filepath.Join(t.TempDir(), "config.d", "gascan")
+
+	if diff, err := updateSSHConfig(path, content, true); err != nil || diff == "" {
+		t.Fatalf("expected drift for a missing file, got %q (%v)", diff, err)
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		t.Fatalf("expected --check to leave the file alone")
+	}
+
+	if diff, err := updateSSHConfig(path, content, false); err != nil || diff == "" {
+		t.Fatalf("expected the file to be written, got %q (%v)", diff, err)
+	}
+
+	// Regenerating is a no-op
+	if diff, err := updateSSHConfig(path, generateSSHConfig(inv, ""), true); err != nil || diff != "" {
+		t.Fatalf("expected no drift, got %q (%v)", diff, err)
+	}
+
+	if err := os.WriteFile(path, []byte(strings.Replace(content, "Port 2222", "Port 22", 1)), 0o600); err != nil {
+		t.Fatalf("unable to write '%s': %v", path, err)
+	}
+
+	if diff, _ := updateSSHConfig(path, content, true); !strings.Contains(diff, "-  Port 22\n+  Port 2222\n") {
+		t.Fatalf("expected the drift to be shown, got:\n%s", diff)
+	}
+
+	userConfig := filepath.Join(filepath.Dir(filepath.Dir(path)), "config")
+	if sshConfigIncluded(userConfig, path) {
+		t.Fatalf("expected the file not to be included without a config")
+	}
+
+	if err := os.WriteFile(userConfig, []byte("Include config.d/*\n\nHost *\n  ServerAliveInterval 30\n"), 0o600); err != nil {
+		t.Fatalf("unable to write '%s': %v", userConfig, err)
+	}
+
+	if !sshConfigIncluded(userConfig, path) {
+		t.Fatalf("expected the file to be included")
+	}
+}
@@ -0,0 +1,436 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// PMMServer is how PMM Server is reached from here, as deployed from the inventory
+type PMMServer struct {
+	Address     string
+	Credentials string
+	Host        string
+	Insecure    bool
+	JumpHost    string
+	Port        int
+	Schema      string
+	Version     string
+}
+
+// ServiceStatus is the state of the agents for a service that is registered with PMM Server
+type ServiceStatus struct {
+	Agents    []PMMAgent
+	Connected string
+	Node      string
+	Service   PMMService
+}
+
+// PMMStatus is the state of PMM Server along with the agents for each service
+type PMMStatus struct {
+	Error    error
+	Server   PMMServer
+	Services []ServiceStatus
+	Settings *PMMSettings
+	Version  string
+}
+
+// literalVar is as per stringVar, skipping the values that are templated for Ansible
+func literalVar(vars map[string]interface{}, names ...string) string {
+	for _, n := range names {
+		if v := stringVar(vars, n); v != "" && !strings.Contains(v, "{{") {
+			return v
+		}
+	}
+
+	return ""
+}
+
+// monitorHost chooses the monitor, preferring the alias when there are several
+func monitorHost(inv *Inventory, alias string) (string, error) {
+	monitors := inv.GroupHosts("monitors")
+
+	switch {
+	case len(monitors) == 0:
+		return "", errors.New("the monitors group needs a host")
+	case slices.Contains(monitors, alias):
+		return alias, nil
+	default:
+		return monitors[0], nil
+	}
+}
+
+// inventoryPMMServer finds the address, credentials and version of PMM Server using the variables
+// for the monitor, along with the defaults from the pmm role; vault values are decrypted using
+// ANSIBLE_VAULT_PASSWORD_FILE
+func inventoryPMMServer(inv *Inventory, alias string, jumpHost string) (PMMServer, error) {
+	host, err := monitorHost(inv, alias)
+	if err != nil {
+		return PMMServer{}, err
+	}
+
+	vars := inv.HostVars(host)
+	s := PMMServer{
+		Address:  literalVar(vars, "pmm_server_host_public", "ansible_host", "ansible_ssh_host"),
+		Host:     host,
+		JumpHost: jumpHostFor(host, vars, jumpHost),
+		Port:     defaultPMMServerPort,
+		Schema:   literalVar(vars, "pmm_server_schema"),
+		Version:  literalVar(vars, "pmm_version"),
+	}
+
+	if stringVar(vars, "ansible_connection") == "local" {
+		s.JumpHost = ""
+		if s.Address == "" {
+			s.Address = "127.0.0.1"
+		}
+	} else if s.Address == "" {
+		s.Address = host
+	}
+
+	if s.Schema == "" {
+		s.Schema = "https"
+	}
+
+	if p, err := strconv.Atoi(literalVar(vars, "pmm_server_port", "pmm_container_port")); err == nil {
+		s.Port = p
+	}
+
+	if b, err := strconv.ParseBool(literalVar(vars, "pmm_server_insecure")); err == nil {
+		s.Insecure = b
+	} else {
+		s.Insecure = !slices.Contains([]string{"localhost", "127.0.0.1", "::1"}, s.Address)
+	}
+
+	switch v := vars["pmm_admin_credentials"].(type) {
+	case VaultValue:
+		if s.Credentials, err = v.Decrypt(inventoryVaultPassword()); err != nil {
+			return s, fmt.Errorf("unable to decrypt pmm_admin_credentials for %s: %w", host, err)
+		}
+	case string:
+		if !strings.Contains(v, "{{") {
+			s.Credentials = v
+		}
+	}
+
+	return s, nil
+}
+
+// URL is the address of the server
+func (s PMMServer) URL() string {
+	return fmt.Sprintf("%s://%s", s.Schema, net.JoinHostPort(s.Address, strconv.Itoa(s.Port)))
+}
+
+// Client connects to the server, via a tunnel when it is reached using a jump host, returning a
+// function to close the tunnel
+func (s PMMServer) Client(credentials string, insecure bool) (*PMMClient, func(), error) {
+	if credentials == "" {
+		credentials = s.Credentials
+	}
+
+	uri, closeTunnel := s.URL(), func() {}
+
+	if s.JumpHost != "" {
+		c, port, err := openTunnel(s.JumpHost, s.Address, s.Port)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		uri, closeTunnel = fmt.Sprintf("%s://127.0.0.1:%d", s.Schema, port), c
+	}
+
+	c, err := newPMMClient(uri, credentials, insecure || s.Insecure)
+	if err != nil {
+		closeTunnel()
+		return nil, nil, err
+	}
+
+	return c, closeTunnel, nil
+}
+
+// VersionMatches compares the version of the server with pmm_version, which can be a prefix,
+// e.g. 2 or 2.41; versions that are not numeric, such as dev-latest, always match
+func (s PMMServer) VersionMatches(version string) bool {
+	expected := strings.TrimPrefix(s.Version, "v")
+	if expected == "" || strings.Trim(expected, "0123456789.") != "" {
+		return true
+	}
+
+	version = strings.TrimPrefix(version, "v")
+
+	return version == expected || strings.HasPrefix(version, expected+".")
+}
+
+// Healthy checks that pmm-agent is connected and every agent that is enabled is running
+func (s ServiceStatus) Healthy() bool {
+	return s.Problem() == ""
+}
+
+// Problem describes why the service is unhealthy, which is empty when it is healthy
+func (s ServiceStatus) Problem() string {
+	if s.Connected != "connected" {
+		return "pmm-agent is " + s.Connected
+	}
+
+	for _, a := range s.Agents {
+		if !a.Disabled && a.AgentStatus() != "RUNNING" {
+			return a.AgentType + " is " + a.AgentStatus()
+		}
+	}
+
+	return ""
+}
+
+// serviceStatuses matches the agents to each service, using the pmm-agent that runs its exporters
+// or, when there are none, the pmm-agent on its node
+func serviceStatuses(nodes []PMMNode, services []PMMService, agents []PMMAgent) []ServiceStatus {
+	names := map[string]string{}
+	for _, n := range nodes {
+		names[n.NodeID] = n.NodeName
+	}
+
+	pmmAgents := map[string]PMMAgent{}
+	for _, a := range agents {
+		if a.AgentType == pmmAgentTypePMM {
+			pmmAgents[a.AgentID] = a
+		}
+	}
+
+	statuses := []ServiceStatus{}
+
+	for _, s := range services {
+		st := ServiceStatus{Agents: []PMMAgent{}, Node: names[s.NodeID], Service: s}
+		if st.Node == "" {
+			st.Node = s.NodeID
+		}
+
+		runners := []string{}
+		for _, a := range agents {
+			if a.ServiceID == s.ServiceID && a.AgentType != pmmAgentTypePMM {
+				st.Agents = append(st.Agents, a)
+				runners = append(runners, a.PMMAgentID)
+			}
+		}
+
+		if len(runners) == 0 {
+			for _, a := range pmmAgents {
+				if a.RunsOnNodeID == s.NodeID {
+					runners = append(runners, a.AgentID)
+				}
+			}
+		}
+
+		st.Connected = "missing"
+		for _, id := range runners {
+			a, ok := pmmAgents[id]
+			if !ok || !a.Connected {
+				st.Connected = "disconnected"
+				break
+			}
+
+			st.Connected = "connected"
+		}
+
+		statuses = append(statuses, st)
+	}
+
+	slices.SortFunc(statuses, func(a ServiceStatus, b ServiceStatus) int {
+		return strings.Compare(a.Service.ServiceName, b.Service.ServiceName)
+	})
+
+	return statuses
+}
+
+// checkPMMStatus gathers the state of the server, stopping at the first request that fails
+func checkPMMStatus(c *PMMClient, server PMMServer) PMMStatus {
+	st := PMMStatus{Server: server, Services: []ServiceStatus{}}
+
+	if st.Error = c.Ready(); st.Error != nil {
+		return st
+	}
+
+	v, err := c.Version()
+	if st.Error = err; err != nil {
+		return st
+	}
+
+	st.Version = v.String()
+
+	settings, err := c.Settings()
+	if st.Error = err; err != nil {
+		return st
+	}
+
+	st.Settings = &settings
+
+	nodes, err := c.ListNodes()
+	if st.Error = err; err != nil {
+		return st
+	}
+
+	services, err := c.ListServices()
+	if st.Error = err; err != nil {
+		return st
+	}
+
+	agents, err := c.ListAgents()
+	if st.Error = err; err != nil {
+		return st
+	}
+
+	st.Services = serviceStatuses(nodes, services, agents)
+
+	return st
+}
+
+// Problems counts the issues that need attention, i.e. the server is unavailable, its version
+// differs from the inventory or a service is unhealthy
+func (st PMMStatus) Problems() int {
+	if st.Error != nil {
+		return 1
+	}
+
+	problems := 0
+	if !st.Server.VersionMatches(st.Version) {
+		problems++
+	}
+
+	for _, s := range st.Services {
+		if !s.Healthy() {
+			problems++
+		}
+	}
+
+	return problems
+}
+
+// writePMMStatus shows the health and version of the server, followed by the agents for each service
+func writePMMStatus(w io.Writer, st PMMStatus) {
+	server := st.Server.URL()
+	if st.Server.JumpHost != "" {
+		server += " via " + st.Server.JumpHost
+	}
+
+	fmt.Fprintf(w, "Server:   %s (%s)\n", server, st.Server.Host)
+
+	if st.Error != nil && st.Version == "" {
+		fmt.Fprintf(w, "Health:   FAILED: %v\n", st.Error)
+		return
+	}
+
+	fmt.Fprintf(w, "Health:   ready\n")
+
+	switch {
+	case st.Server.Version == "":
+		fmt.Fprintf(w, "Version:  %s, pmm_version is not set in the inventory\n", st.Version)
+	case st.Server.VersionMatches(st.Version):
+		fmt.Fprintf(w, "Version:  %s, as per pmm_version %s\n", st.Version, st.Server.Version)
+	default:
+		fmt.Fprintf(w, "Version:  %s, MISMATCH: pmm_version is %s\n", st.Version, st.Server.Version)
+	}
+
+	if st.Settings != nil {
+		retention := st.Settings.DataRetention
+		if d, err := time.ParseDuration(retention); err == nil && d%(24*time.Hour) == 0 {
+			retention = fmt.Sprintf("%dd", d/(24*time.Hour))
+		}
+
+		updates, telemetry := "enabled", "disabled"
+		if st.Settings.UpdatesDisabled {
+			updates = "disabled"
+		}
+
+		if st.Settings.TelemetryEnabled {
+			telemetry = "enabled"
+		}
+
+		fmt.Fprintf(w, "Settings: retention %s, updates %s, telemetry %s\n", retention, updates, telemetry)
+	}
+
+	if st.Error != nil {
+		fmt.Fprintf(w, "Services: FAILED: %v\n", st.Error)
+		return
+	}
+
+	fmt.Fprintln(w)
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SERVICE\tTYPE\tNODE\tPMM-AGENT\tAGENTS")
+
+	for _, s := range st.Services {
+		agents := []string{}
+		for _, a := range s.Agents {
+			status := a.AgentStatus()
+			if a.Disabled {
+				status = "disabled"
+			}
+
+			agents = append(agents, a.AgentType+" "+status)
+		}
+
+		if len(agents) == 0 {
+			agents = append(agents, "-")
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", s.Service.ServiceName, s.Service.ServiceType, s.Node, s.Connected, strings.Join(agents, ", "))
+	}
+
+	tw.Flush()
+}
+
+func statusCommand(args []string) int {
+	fs := subcommandFlags("status", "[--inventory PATHS] [--monitor NAME] [--jump-host HOST] [--credentials CREDENTIALS] [--insecure]")
+	credentials := fs.String("credentials", "", "Credentials for PMM Server, i.e. username:password or a token, otherwise pmm_admin_credentials from the inventory [GASCAN_PMM_CREDENTIALS]")
+	insecure := fs.Bool("insecure", false, "Skip verification of the certificate for PMM Server, otherwise as per pmm_server_insecure")
+	inventory := fs.String("inventory", "", "Comma-separated inventory sources, otherwise as per Ansible [ANSIBLE_INVENTORY, ~/.ansible.cfg]")
+	jumpHost := fs.String("jump-host", os.Getenv("GASCAN_FLAG_JUMP_HOST"), jumpHostUsage)
+	monitor := fs.String("monitor", "monitor", "Monitor alias")
+
+	if len(parseSubcommandFlags(fs, args)) > 0 {
+		fs.Usage()
+		return 1
+	}
+
+	sources := inventorySources(*inventory, filepath.Join(os.Getenv("HOME"), ".ansible.cfg"))
+	if sources == "" {
+		Logger.Error("an inventory is required")
+		return 1
+	}
+
+	inv, err := loadInventory(sources)
+	if err != nil {
+		Logger.Error("unable to load the inventory: %v", err)
+		return 1
+	}
+
+	server, err := inventoryPMMServer(inv, *monitor, *jumpHost)
+	if err != nil {
+		Logger.Error("unable to find PMM Server: %v", err)
+		return 1
+	}
+
+	c, closeTunnel, err := server.Client(*credentials, *insecure)
+	if err != nil {
+		Logger.Error("unable to connect to PMM Server: %v", err)
+		return 1
+	}
+	defer closeTunnel()
+
+	st := checkPMMStatus(c, server)
+	writePMMStatus(os.Stdout, st)
+
+	if problems := st.Problems(); problems > 0 {
+		Logger.Error("%d problem(s) need attention", problems)
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPMMStatus(t *testing.T) {
+	srv := newPMMDummyServer(t)
+	defer srv.Close()
+
+	dir := t.TempDir()
+	passwordFile := filepath.Join(dir, "vault-password")
+
+	if err := os.WriteFile(passwordFile, []byte("s3cret\n"), 0o600); err != nil {
+		t.Fatalf("unable to write '%s': %v", passwordFile, err)
+	}
+
+	t.Setenv("ANSIBLE_VAULT_PASSWORD_FILE", passwordFile)
+	t.Setenv("GASCAN_PMM_CREDENTIALS", "")
+
+	encrypted, err := vaultEncrypt([]byte("admin:s3cret"), []byte("s3cret"))
+	if err != nil {
+		t.Fatalf("failed to vaultEncrypt: %v", err)
+	}
+
+	_, port, _ := strings.Cut(srv.Listener.Addr().String(), ":")
+	inventory := fmt.Sprintf(`---
+all:
+  children:
+    monitors:
+      hosts:
+        monitor:
+          ansible_host: 127.0.0.1
+          pmm_admin_credentials: !vault |
+            %s
+          pmm_server_port: %s
+          pmm_server_schema: http
+          pmm_version: "2.40"
+...
+`, strings.ReplaceAll(strings.TrimSpace(string(encrypted)), "\n", "\n            "), port)
+
+	inv, err := loadInventory(filepath.Join(writeInventoryFiles(t, map[string]string{"hosts.yaml": inventory}), "hosts.yaml"))
+	if err != nil {
+		t.Fatalf("failed to loadInventory: %v", err)
+	}
+
+	server, err := inventoryPMMServer(inv, "monitor", "")
+	if err != nil || server.Credentials != "admin:s3cret" || server.URL() != srv.URL || server.Insecure {
+		t.Fatalf("expected the server from the inventory, got %+v (%v)", server, err)
+	}
+
+	c, closeTunnel, err := server.Client("", false)
+	if err != nil {
+		t.Fatalf("failed to create the client: %v", err)
+	}
+	defer closeTunnel()
+
+	st := checkPMMStatus(c, server)
+	if st.Error != nil || len(st.Services) != 5 {
+		t.Fatalf("expected the status of 5 services, got %+v", st)
+	}
+
+	// The version differs from pmm_version and the exporter for rds1-mysql is waiting
+	if p := st.Problems(); p != 2 {
+		t.Fatalf("expected 2 problems, got %d", p)
+	}
+
+	out := strings.Builder{}
+	writePMMStatus(&out, st)
+
+	for _, e := range []string{
+		"Health:   ready\n",
+		"Version:  2.41.0, MISMATCH: pmm_version is 2.40\n",
+		"Settings: retention 30d, updates enabled, telemetry enabled\n",
+		"db1-proxysql           proxysql    db1         connected  proxysql_exporter disabled\n",
+		"exporter               external    db1         connected  -\n",
+		"rds1-mysql             mysql       rds1        connected  mysqld_exporter WAITING\n",
+	} {
+		if !strings.Contains(out.String(), e) {
+			t.Fatalf("expected %q in:\n%s", e, out.String())
+		}
+	}
+
+	server.Version = "2"
+	if st.Server = server; st.Problems() != 1 {
+		t.Fatalf("expected pmm_version to match as a prefix")
+	}
+
+	// Without credentials, the server is reported as failing after the health check
+	c, _ = newPMMClient(srv.URL, "admin:wrong", false)
+	if st = checkPMMStatus(c, server); st.Error == nil || st.Problems() != 1 {
+		t.Fatalf("expected an error for the wrong credentials, got %+v", st)
+	}
+}
+
+func TestPMMServerVersionMatches(t *testing.T) {
+	for expected, versions := range map[string][2]string{
+		"":           {"2.41.0", ""},
+		"2":          {"2.41.0", "3.0.0"},
+		"2.41":       {"v2.41.1", "2.4.1"},
+		"2.41.0":     {"2.41.0", "2.41.1"},
+		"dev-latest": {"3.1.0", ""},
+	} {
+		s := PMMServer{Version: expected}
+
+		if !s.VersionMatches(versions[0]) {
+			t.Fatalf("expected %s to match %s", versions[0], expected)
+		}
+
+		if versions[1] != "" && s.VersionMatches(versions[1]) {
+			t.Fatalf("expected %s not to match %s", versions[1], expected)
+		}
+	}
+}
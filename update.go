@@ -0,0 +1,239 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const (
+	updateIndexFile    = "index.json"
+	updatePreviousName = ".previous"
+)
+
+// UpdatePublicKey is the base64-encoded ed25519 key used to verify releases, set at build time
+var UpdatePublicKey string
+
+// ReleaseIndex lists the builds that are available from a mirror
+type ReleaseIndex struct {
+	Latest   string    `json:"latest"`
+	Releases []Release `json:"releases"`
+}
+
+// Release describes a single build in a ReleaseIndex
+type Release struct {
+	Arch          string `json:"arch"`
+	BundleVersion string `json:"bundle_version"`
+	File          string `json:"file"`
+	OS            string `json:"os"`
+	Python        string `json:"python"`
+	SHA256        string `json:"sha256"`
+	Signature     string `json:"signature"`
+	Version       string `json:"version"`
+}
+
+func fetchUpdateFile(base string, name string) ([]byte, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "", "file":
+		return os.ReadFile(filepath.Join(u.Path, name))
+	case "http", "https":
+		u.Path = path.Join(u.Path, name)
+		client := http.Client{Timeout: 5 * time.Minute}
+
+		resp, err := client.Get(u.String())
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unexpected status for '%s': %s", u.String(), resp.Status)
+		}
+
+		return io.ReadAll(resp.Body)
+	default:
+		return nil, fmt.Errorf("unsupported scheme '%s'", u.Scheme)
+	}
+}
+
+func selectRelease(index ReleaseIndex, version string, goos string, goarch string, python string) (Release, error) {
+	if version == "" {
+		version = index.Latest
+	}
+
+	for _, r := range index.Releases {
+		if version != "" && r.Version != version {
+			continue
+		}
+
+		if r.OS == goos && r.Arch == goarch && r.Python == python {
+			return r, nil
+		}
+	}
+
+	return Release{}, fmt.Errorf("no release found for version '%s' on %s/%s with python %s", version, goos, goarch, python)
+}
+
+func verifyRelease(r Release, content []byte, signature []byte, publicKey string) error {
+	sum := sha256.Sum256(content)
+	if !strings.EqualFold(hex.EncodeToString(sum[:]), r.SHA256) {
+		return fmt.Errorf("checksum mismatch for '%s'", r.File)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(publicKey))
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid public key for verification")
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+	if err != nil {
+		return fmt.Errorf("unable to decode signature for '%s': %w", r.File, err)
+	}
+
+	if !ed25519.Verify(ed25519.PublicKey(key), content, sig) {
+		return fmt.Errorf("signature verification failed for '%s'", r.File)
+	}
+
+	return nil
+}
+
+func replaceExecutable(exe string, content []byte) error {
+	current, err := os.ReadFile(exe)
+	if err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(exe+updatePreviousName, current, 0o755); err != nil {
+		return fmt.Errorf("unable to keep the previous version: %w", err)
+	}
+
+	Logger.Debug("replacing '%s'", exe)
+
+	return writeFileAtomic(exe, content, 0o755)
+}
+
+func rollbackExecutable(exe string) error {
+	previous := exe + updatePreviousName
+
+	if _, err := os.Stat(previous); err != nil {
+		return fmt.Errorf("no previous version found at '%s'", previous)
+	}
+
+	return os.Rename(previous, exe)
+}
+
+func currentExecutable() (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.EvalSymlinks(exe)
+}
+
+func printVersionDelta(r Release) {
+	fmt.Printf("Version: %s -> %s\n", Version, r.Version)
+	fmt.Printf("Bundle Version: %s -> %s\n", BundleVersion, r.BundleVersion)
+}
+
+func selfUpdateCommand(args []string) int {
+	fs := subcommandFlags("self-update", "--from URL [--version VERSION] [--check] | --rollback")
+	from := fs.String("from", "", "Mirror to update from, e.g. file:///mnt/share/gascan/ or https://mirror.local/gascan/")
+	check := fs.Bool("check", false, "Only show the version delta")
+	rollback := fs.Bool("rollback", false, "Restore the previous version")
+	version := fs.String("version", "", "Update to a specific version instead of the latest")
+	parseSubcommandFlags(fs, args)
+
+	exe, err := currentExecutable()
+	if err != nil {
+		Logger.Error("unable to locate the running executable: %v", err)
+		return 1
+	}
+
+	if *rollback {
+		if err := rollbackExecutable(exe); err != nil {
+			Logger.Error("unable to roll back: %v", err)
+			return 1
+		}
+
+		fmt.Println("Restored the previous version of", exe)
+		return 0
+	}
+
+	if *from == "" {
+		fs.Usage()
+		return 1
+	}
+
+	data, err := fetchUpdateFile(*from, updateIndexFile)
+	if err != nil {
+		Logger.Error("unable to fetch the release index: %v", err)
+		return 1
+	}
+
+	var index ReleaseIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		Logger.Error("unable to parse the release index: %v", err)
+		return 1
+	}
+
+	r, err := selectRelease(index, *version, runtime.GOOS, runtime.GOARCH, pexFlavour())
+	if err != nil {
+		Logger.Error("%v", err)
+		return 1
+	}
+
+	printVersionDelta(r)
+
+	if *check {
+		return 0
+	}
+
+	if r.Version == Version && r.BundleVersion == BundleVersion {
+		fmt.Println("Already up-to-date")
+		return 0
+	}
+
+	content, err := fetchUpdateFile(*from, r.File)
+	if err != nil {
+		Logger.Error("unable to fetch '%s': %v", r.File, err)
+		return 1
+	}
+
+	signature, err := fetchUpdateFile(*from, r.Signature)
+	if err != nil {
+		Logger.Error("unable to fetch the signature '%s': %v", r.Signature, err)
+		return 1
+	}
+
+	if err := verifyRelease(r, content, signature, UpdatePublicKey); err != nil {
+		Logger.Error("unable to verify the release: %v", err)
+		return 1
+	}
+
+	if err := replaceExecutable(exe, content); err != nil {
+		Logger.Error("unable to replace '%s': %v", exe, err)
+		return 1
+	}
+
+	fmt.Printf("Updated %s, the previous version is available via --rollback\n", exe)
+
+	return 0
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSelfUpdate(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("unable to generate a key: %v", err)
+	}
+
+	mirror, err := os.MkdirTemp(os.TempDir(), "mirror")
+	if err != nil {
+		t.Fatalf("unable to create directory: %v", err)
+	}
+	defer os.RemoveAll(mirror)
+
+	content := []byte("#!/bin/sh\necho new\n")
+	sum := sha256.Sum256(content)
+	index := ReleaseIndex{
+		Latest: "v2",
+		Releases: []Release{
+			{Arch: "amd64", BundleVersion: "b1", File: "gascan-v1", OS: "linux", Python: "3.9", Version: "v1"},
+			{Arch: "arm64", BundleVersion: "b2", File: "gascan-arm64", OS: "linux", Python: "3.9", Version: "v2"},
+			{Arch: "amd64", BundleVersion: "b2", File: "gascan-py3.9", OS: "linux", Python: "3.9", SHA256: hex.EncodeToString(sum[:]), Signature: "gascan-py3.9.sig", Version: "v2"},
+		},
+	}
+
+	data, _ := json.Marshal(index)
+	files := map[string][]byte{
+		updateIndexFile:    data,
+		"gascan-py3.9":     content,
+		"gascan-py3.9.sig": []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(priv, content))),
+	}
+
+	for name, c := range files {
+		if err := os.WriteFile(filepath.Join(mirror, name), c, 0o640); err != nil {
+			t.Fatalf("unable to write '%s': %v", name, err)
+		}
+	}
+
+	srv := httptest.NewServer(http.FileServer(http.Dir(mirror)))
+	defer srv.Close()
+
+	for _, base := range []string{"file://" + mirror, srv.URL + "/"} {
+		b, err := fetchUpdateFile(base, updateIndexFile)
+		if err != nil || string(b) != string(data) {
+			t.Fatalf("failed to fetch the index from '%s': %v", base, err)
+		}
+	}
+
+	r, err := selectRelease(index, "", "linux", "amd64", "3.9")
+	if err != nil || r.File != "gascan-py3.9" {
+		t.Fatalf("expected gascan-py3.9, got %v (%v)", r, err)
+	}
+
+	if r, err := selectRelease(index, "v1", "linux", "amd64", "3.9"); err != nil || r.File != "gascan-v1" {
+		t.Fatalf("expected gascan-v1, got %v (%v)", r, err)
+	}
+
+	if _, err := selectRelease(index, "", "linux", "amd64", "3.11"); err == nil {
+		t.Fatalf("expected an error for an unavailable Python version")
+	}
+
+	key := base64.StdEncoding.EncodeToString(pub)
+	if err := verifyRelease(r, content, files["gascan-py3.9.sig"], key); err != nil {
+		t.Fatalf("failed to verifyRelease: %v", err)
+	}
+
+	if err := verifyRelease(r, append(content, '#'), files["gascan-py3.9.sig"], key); err == nil {
+		t.Fatalf("expected a checksum error")
+	}
+
+	otherPub, _, _ := ed25519.GenerateKey(nil)
+	if err := verifyRelease(r, content, files["gascan-py3.9.sig"], base64.StdEncoding.EncodeToString(otherPub)); err == nil {
+		t.Fatalf("expected a signature error")
+	}
+
+	exe := filepath.Join(mirror, "gascan")
+	if err := os.WriteFile(exe, []byte("old"), 0o755); err != nil {
+		t.Fatalf("unable to write '%s': %v", exe, err)
+	}
+
+	if err := replaceExecutable(exe, content); err != nil {
+		t.Fatalf("failed to replaceExecutable: %v", err)
+	}
+
+	if c, _ := os.ReadFile(exe); string(c) != string(content) {
+		t.Fatalf("expected the new content, got %q", c)
+	}
+
+	if err := rollbackExecutable(exe); err != nil {
+		t.Fatalf("failed to rollbackExecutable: %v", err)
+	}
+
+	if c, _ := os.ReadFile(exe); string(c) != "old" {
+		t.Fatalf("expected the previous content, got %q", c)
+	}
+
+	if err := rollbackExecutable(exe); err == nil {
+		t.Fatalf("expected an error without a previous version")
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	vaultHeader     = "$ANSIBLE_VAULT"
+	vaultIterations = 10000
+	vaultKeyLength  = 32
+	vaultLineLength = 80
+	vaultSaltLength = 32
+)
+
+func isVaultEncrypted(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte(vaultHeader+";"))
+}
+
+func readVaultPassword(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimSpace(data), nil
+}
+
+// pbkdf2 derives a key as per RFC 8018 using HMAC-SHA256
+func pbkdf2(password []byte, salt []byte, iterations int, length int) []byte {
+	prf := hmac.New(sha256.New, password)
+	blocks := (length + prf.Size() - 1) / prf.Size()
+	key := make([]byte, 0, blocks*prf.Size())
+	buf := make([]byte, 4)
+
+	for block := 1; block <= blocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(buf, uint32(block))
+		prf.Write(buf)
+
+		u := prf.Sum(nil)
+		t := make([]byte, len(u))
+		copy(t, u)
+
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+
+		key = append(key, t...)
+	}
+
+	return key[:length]
+}
+
+func vaultKeys(password []byte, salt []byte) ([]byte, []byte, []byte) {
+	derived := pbkdf2(password, salt, vaultIterations, 2*vaultKeyLength+aes.BlockSize)
+
+	return derived[:vaultKeyLength], derived[vaultKeyLength : 2*vaultKeyLength], derived[2*vaultKeyLength:]
+}
+
+func vaultCTR(key []byte, iv []byte, data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, len(data))
+	cipher.NewCTR(block, iv).XORKeyStream(out, data)
+
+	return out, nil
+}
+
+func vaultEncrypt(plaintext []byte, password []byte) ([]byte, error) {
+	salt := make([]byte, vaultSaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	cipherKey, hmacKey, iv := vaultKeys(password, salt)
+
+	padding := aes.BlockSize - len(plaintext)%aes.BlockSize
+	padded := append(append([]byte{}, plaintext...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+
+	ciphertext, err := vaultCTR(cipherKey, iv, padded)
+	if err != nil {
+		return nil, err
+	}
+
+	mac := hmac.New(sha256.New, hmacKey)
+	mac.Write(ciphertext)
+
+	body := hex.EncodeToString([]byte(strings.Join([]string{
+		hex.EncodeToString(salt),
+		hex.EncodeToString(mac.Sum(nil)),
+		hex.EncodeToString(ciphertext),
+	}, "\n")))
+
+	out := bytes.NewBufferString(vaultHeader + ";1.1;AES256\n")
+	for len(body) > 0 {
+		n := min(vaultLineLength, len(body))
+		out.WriteString(body[:n] + "\n")
+		body = body[n:]
+	}
+
+	return out.Bytes(), nil
+}
+
+func vaultDecrypt(data []byte, password []byte) ([]byte, error) {
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	header := strings.Split(strings.TrimSpace(lines[0]), ";")
+
+	if len(header) < 3 || header[0] != vaultHeader {
+		return nil, fmt.Errorf("not a vault")
+	}
+
+	if header[2] != "AES256" {
+		return nil, fmt.Errorf("unsupported vault cipher '%s'", header[2])
+	}
+
+	body := ""
+	for _, ln := range lines[1:] {
+		body += strings.TrimSpace(ln)
+	}
+
+	decoded, err := hex.DecodeString(body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode vault: %w", err)
+	}
+
+	parts := strings.Split(string(decoded), "\n")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("unexpected vault format")
+	}
+
+	fields := make([][]byte, len(parts))
+	for i, p := range parts {
+		if fields[i], err = hex.DecodeString(p); err != nil {
+			return nil, fmt.Errorf("unable to decode vault: %w", err)
+		}
+	}
+
+	salt, expected, ciphertext := fields[0], fields[1], fields[2]
+	cipherKey, hmacKey, iv := vaultKeys(password, salt)
+
+	mac := hmac.New(sha256.New, hmacKey)
+	mac.Write(ciphertext)
+
+	if !hmac.Equal(mac.Sum(nil), expected) {
+		return nil, fmt.Errorf("vault HMAC mismatch, the password may be incorrect")
+	}
+
+	padded, err := vaultCTR(cipherKey, iv, ciphertext)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(padded) == 0 {
+		return padded, nil
+	}
+
+	padding := int(padded[len(padded)-1])
+	if padding == 0 || padding > aes.BlockSize || padding > len(padded) {
+		return nil, fmt.Errorf("invalid vault padding")
+	}
+
+	return padded[:len(padded)-padding], nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+)
+
+const vaultDummyContent = `$ANSIBLE_VAULT;1.1;AES256
+36303738356539376238383736383934313131346334383631363465363739363366626133336663
+6132376432323066623363663637313038303165396132620a373366383933643562636362326539
+61613432313337313363633362393862613362356562353933313733623133653534376263353336
+3563633531626364340a353239326366393263643138386236363839343134633432356337376632
+62643064323161396437363334666232356135366231646664346338666438323632
+`
+
+func TestVault(t *testing.T) {
+	expected := "{\"all\": {\"hosts\": [\"db1\"]}}\n"
+
+	if !isVaultEncrypted([]byte(vaultDummyContent)) {
+		t.Fatalf("expected vaultDummyContent to be detected as a vault")
+	}
+
+	plaintext, err := vaultDecrypt([]byte(vaultDummyContent), []byte("s3cret"))
+	if err != nil {
+		t.Fatalf("failed to vaultDecrypt: %v", err)
+	}
+
+	if string(plaintext) != expected {
+		t.Fatalf("expected %q, got %q", expected, plaintext)
+	}
+
+	if _, err := vaultDecrypt([]byte(vaultDummyContent), []byte("wrong")); err == nil {
+		t.Fatalf("expected an error for the wrong password")
+	}
+
+	for _, content := range []string{"", "a", "exactly 16 bytes", expected} {
+		encrypted, err := vaultEncrypt([]byte(content), []byte("s3cret"))
+		if err != nil {
+			t.Fatalf("failed to vaultEncrypt: %v", err)
+		}
+
+		decrypted, err := vaultDecrypt(encrypted, []byte("s3cret"))
+		if err != nil || string(decrypted) != content {
+			t.Fatalf("expected %q, got %q (%v)", content, decrypted, err)
+		}
+	}
+
+	if isVaultEncrypted([]byte(expected)) {
+		t.Fatalf("expected plain text to not be detected as a vault")
+	}
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+)
+
+// verifyPlaybooks are the playbooks that register the clients, which are verified after deploying
+var verifyPlaybooks = []string{"pmm-client.yaml", "pmm-full.yaml"}
+
+// verifyServiceGroups are the groups that pmm-client.yaml adds a service for, using the
+// pmm_payload_add_<group>.service_name or <host>-<group>
+var verifyServiceGroups = []string{"haproxy", "mongodb", "mysql", "postgresql", "proxysql"}
+
+// ExpectedService is a service that the inventory registers with PMM Server, where the name is
+// empty when it is chosen by PMM, e.g. the instance for RDS
+type ExpectedService struct {
+	Host string
+	Name string
+	Node string
+	Type string
+}
+
+// VerifyIssue is a difference between the inventory and the registrations with PMM Server
+type VerifyIssue struct {
+	Detail  string
+	Host    string
+	ID      string
+	Kind    string
+	Name    string
+	Problem string
+}
+
+// Verification is the result of comparing the inventory with PMM Server
+type Verification struct {
+	Issues   []VerifyIssue
+	Nodes    int
+	Services int
+}
+
+// expectedServices lists the services for each client in the inventory, as per pmm-client.yaml,
+// with the cloud hosts using the engine from their group
+func expectedServices(inv *Inventory) []ExpectedService {
+	expected := []ExpectedService{}
+
+	for _, h := range inv.GroupHosts("pmm_clients") {
+		vars := inv.HostVars(h)
+		groups := inv.HostGroups(h)
+
+		if cloud := slices.IndexFunc(inventoryTopology["cloud"], func(g string) bool { return slices.Contains(groups, g) }); cloud >= 0 {
+			payload, _ := vars["pmm_payload_add_"+inventoryTopology["cloud"][cloud]].(map[string]interface{})
+			engine := "mysql"
+			if slices.Contains(groups, "postgresql") {
+				engine = "postgresql"
+			}
+
+			expected = append(expected, ExpectedService{Host: h, Name: literalVar(payload, "service_name"), Node: h, Type: engine})
+			continue
+		}
+
+		for _, t := range verifyServiceGroups {
+			if !slices.Contains(groups, t) {
+				continue
+			}
+
+			payload, _ := vars["pmm_payload_add_"+t].(map[string]interface{})
+			name := literalVar(payload, "service_name")
+			if name == "" {
+				name = h + "-" + t
+			}
+
+			expected = append(expected, ExpectedService{Host: h, Name: name, Node: h, Type: t})
+		}
+	}
+
+	return expected
+}
+
+// verifyInventory compares the clients in the inventory with the nodes and services that are
+// registered, ignoring those for PMM Server itself
+func verifyInventory(inv *Inventory, nodes []PMMNode, services []PMMService, agents []PMMAgent) Verification {
+	v := Verification{Issues: []VerifyIssue{}}
+
+	clients := inv.GroupHosts("pmm_clients")
+	nodeIDs := map[string]string{}
+
+	for _, n := range nodes {
+		switch {
+		case n.NodeID == pmmServerNodeID:
+		case slices.Contains(clients, n.NodeName):
+			nodeIDs[n.NodeName] = n.NodeID
+		default:
+			v.Issues = append(v.Issues, VerifyIssue{Detail: n.NodeType + " node", ID: n.NodeID, Kind: "node", Name: n.NodeName, Problem: "unexpected"})
+		}
+	}
+
+	for _, h := range clients {
+		if _, ok := nodeIDs[h]; ok {
+			v.Nodes++
+			continue
+		}
+
+		v.Issues = append(v.Issues, VerifyIssue{Detail: "no node named " + h, Host: h, Kind: "node", Name: h, Problem: "missing"})
+	}
+
+	statuses := map[string]ServiceStatus{}
+	for _, st := range serviceStatuses(nodes, services, agents) {
+		statuses[st.Service.ServiceID] = st
+	}
+
+	matched := map[string]bool{}
+
+	for _, e := range expectedServices(inv) {
+		idx := slices.IndexFunc(services, func(s PMMService) bool {
+			if e.Name == "" {
+				return !matched[s.ServiceID] && s.NodeID != "" && s.NodeID == nodeIDs[e.Node] && s.ServiceType == e.Type
+			}
+
+			return s.ServiceName == e.Name
+		})
+
+		name := e.Name
+		if name == "" {
+			name = e.Type + " on " + e.Node
+		}
+
+		if idx < 0 {
+			v.Issues = append(v.Issues, VerifyIssue{Detail: "no " + e.Type + " service", Host: e.Host, Kind: "service", Name: name, Problem: "missing"})
+			continue
+		}
+
+		s := services[idx]
+		matched[s.ServiceID] = true
+
+		switch {
+		case s.ServiceType != e.Type:
+			v.Issues = append(v.Issues, VerifyIssue{Detail: fmt.Sprintf("expected %s, registered as %s", e.Type, s.ServiceType), Host: e.Host, ID: s.ServiceID, Kind: "service", Name: s.ServiceName, Problem: "mismatched"})
+		case !statuses[s.ServiceID].Healthy():
+			v.Issues = append(v.Issues, VerifyIssue{Detail: statuses[s.ServiceID].Problem(), Host: e.Host, ID: s.ServiceID, Kind: "service", Name: s.ServiceName, Problem: "unhealthy"})
+		default:
+			v.Services++
+		}
+	}
+
+	for _, s := range services {
+		if matched[s.ServiceID] || s.NodeID == pmmServerNodeID {
+			continue
+		}
+
+		v.Issues = append(v.Issues, VerifyIssue{Detail: s.ServiceType + " service", ID: s.ServiceID, Kind: "service", Name: s.ServiceName, Problem: "unexpected"})
+	}
+
+	slices.SortStableFunc(v.Issues, func(a VerifyIssue, b VerifyIssue) int {
+		return strings.Compare(a.Problem, b.Problem)
+	})
+
+	return v
+}
+
+// Unexpected lists the nodes and services that are registered without being in the inventory
+func (v Verification) Unexpected() []VerifyIssue {
+	unexpected := []VerifyIssue{}
+
+	for _, i := range v.Issues {
+		if i.Problem == "unexpected" {
+			unexpected = append(unexpected, i)
+		}
+	}
+
+	return unexpected
+}
+
+func writeVerification(w io.Writer, v Verification) {
+	fmt.Fprintf(w, "%d node(s) and %d service(s) match the inventory\n", v.Nodes, v.Services)
+
+	if len(v.Issues) == 0 {
+		return
+	}
+
+	fmt.Fprintln(w)
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "PROBLEM\tKIND\tNAME\tHOST\tDETAIL")
+
+	for _, i := range v.Issues {
+		host := i.Host
+		if host == "" {
+			host = "-"
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", i.Problem, i.Kind, i.Name, host, i.Detail)
+	}
+
+	tw.Flush()
+}
+
+// unregisterUnexpected removes the services and then the nodes that are not in the inventory,
+// continuing past any that fail
+func unregisterUnexpected(c *PMMClient, unexpected []VerifyIssue) error {
+	errs := []error{}
+
+	for _, kind := range []string{"service", "node"} {
+		for _, i := range unexpected {
+			if i.Kind != kind {
+				continue
+			}
+
+			remove := c.RemoveService
+			if kind == "node" {
+				remove = c.RemoveNode
+			}
+
+			if err := remove(i.ID); err != nil {
+				errs = append(errs, fmt.Errorf("unable to unregister %s '%s': %w", kind, i.Name, err))
+				continue
+			}
+
+			fmt.Printf("Unregistered %s '%s'\n", kind, i.Name)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// verifyPMM fetches the registrations from PMM Server and compares them with the inventory
+func verifyPMM(c *PMMClient, inv *Inventory) (Verification, error) {
+	if err := c.Ready(); err != nil {
+		return Verification{}, fmt.Errorf("PMM Server is not ready: %w", err)
+	}
+
+	nodes, err := c.ListNodes()
+	if err != nil {
+		return Verification{}, err
+	}
+
+	services, err := c.ListServices()
+	if err != nil {
+		return Verification{}, err
+	}
+
+	agents, err := c.ListAgents()
+	if err != nil {
+		return Verification{}, err
+	}
+
+	return verifyInventory(inv, nodes, services, agents), nil
+}
+
+// verifyDeployment runs after deploying the clients, where the issues are returned as an error
+// and failing to reach PMM Server is only a warning
+func verifyDeployment(inv *Inventory, monitor string, jumpHost string) error {
+	if inv == nil {
+		Logger.Debug("skipping the verification of the deployment")
+		return nil
+	}
+
+	server, err := inventoryPMMServer(inv, monitor, jumpHost)
+	if err != nil {
+		Logger.Warning("unable to verify the deployment: %v", err)
+		return nil
+	}
+
+	c, closeTunnel, err := server.Client("", false)
+	if err != nil {
+		Logger.Warning("unable to verify the deployment: %v", err)
+		return nil
+	}
+	defer closeTunnel()
+
+	v, err := verifyPMM(c, inv)
+	if err != nil {
+		Logger.Warning("unable to verify the deployment, use gascan verify to retry: %v", err)
+		return nil
+	}
+
+	writeVerification(os.Stdout, v)
+
+	if len(v.Issues) > 0 {
+		return fmt.Errorf("PMM Server differs from the inventory with %d issue(s)", len(v.Issues))
+	}
+
+	return nil
+}
+
+func verifyCommand(args []string) int {
+	fs := subcommandFlags("verify", "[--inventory PATHS] [--monitor NAME] [--jump-host HOST] [--credentials CREDENTIALS] [--insecure] [--unregister-unexpected [--yes]]")
+	credentials := fs.String("credentials", "", "Credentials for PMM Server, i.e. username:password or a token, otherwise pmm_admin_credentials from the inventory [GASCAN_PMM_CREDENTIALS]")
+	insecure := fs.Bool("insecure", false, "Skip verification of the certificate for PMM Server, otherwise as per pmm_server_insecure")
+	inventory := fs.String("inventory", "", "Comma-separated inventory sources, otherwise as per Ansible [ANSIBLE_INVENTORY, ~/.ansible.cfg]")
+	jumpHost := fs.String("jump-host", os.Getenv("GASCAN_FLAG_JUMP_HOST"), jumpHostUsage)
+	monitor := fs.String("monitor", "monitor", "Monitor alias")
+	unregister := fs.Bool("unregister-unexpected", false, "Unregister the nodes and services that are not in the inventory, after confirming")
+	yes := fs.Bool("yes", false, "Unregister without asking for confirmation")
+
+	if len(parseSubcommandFlags(fs, args)) > 0 {
+		fs.Usage()
+		return 1
+	}
+
+	sources := inventorySources(*inventory, filepath.Join(os.Getenv("HOME"), ".ansible.cfg"))
+	if sources == "" {
+		Logger.Error("an inventory is required")
+		return 1
+	}
+
+	inv, err := loadInventory(sources)
+	if err != nil {
+		Logger.Error("unable to load the inventory: %v", err)
+		return 1
+	}
+
+	server, err := inventoryPMMServer(inv, *monitor, *jumpHost)
+	if err != nil {
+		Logger.Error("unable to find PMM Server: %v", err)
+		return 1
+	}
+
+	c, closeTunnel, err := server.Client(*credentials, *insecure)
+	if err != nil {
+		Logger.Error("unable to connect to PMM Server: %v", err)
+		return 1
+	}
+	defer closeTunnel()
+
+	v, err := verifyPMM(c, inv)
+	if err != nil {
+		Logger.Error("unable to verify the inventory: %v", err)
+		return 1
+	}
+
+	writeVerification(os.Stdout, v)
+
+	unexpected := v.Unexpected()
+	if *unregister && len(unexpected) > 0 {
+		if !*yes && !confirm(fmt.Sprintf("Unregister %d unexpected node(s) and service(s) from PMM Server?", len(unexpected)), bufio.NewReader(os.Stdin)) {
+			Logger.Error("%d issue(s) were found", len(v.Issues))
+			return 1
+		}
+
+		if err := unregisterUnexpected(c, unexpected); err != nil {
+			Logger.Error("%v", err)
+			return 1
+		}
+
+		if len(unexpected) == len(v.Issues) {
+			return 0
+		}
+	}
+
+	if len(v.Issues) > 0 {
+		Logger.Error("%d issue(s) were found", len(v.Issues))
+		return 1
+	}
+
+	return 0
+}
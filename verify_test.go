@@ -0,0 +1,86 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const verifyInventoryDummy = `---
+all:
+  children:
+    monitors:
+      hosts:
+        monitor:
+    pmm_clients:
+      children:
+        dbservers:
+          children:
+            cloud:
+              children:
+                rds:
+                  hosts:
+                    rds1:
+            mysql:
+              hosts:
+                db1:
+                db3:
+                  pmm_payload_add_mysql:
+                    service_name: db3-primary
+...
+`
+
+func TestVerifyInventory(t *testing.T) {
+	srv := newPMMDummyServer(t)
+	defer srv.Close()
+
+	inv, err := loadInventory(filepath.Join(writeInventoryFiles(t, map[string]string{"hosts.yaml": verifyInventoryDummy}), "hosts.yaml"))
+	if err != nil {
+		t.Fatalf("failed to loadInventory: %v", err)
+	}
+
+	expected := expectedServices(inv)
+	if len(expected) != 3 || expected[1].Name != "db3-primary" || expected[2] != (ExpectedService{Host: "rds1", Node: "rds1", Type: "mysql"}) {
+		t.Fatalf("unexpected services for the inventory: %+v", expected)
+	}
+
+	c, _ := newPMMClient(srv.URL, "admin:s3cret", false)
+
+	v, err := verifyPMM(c, inv)
+	if err != nil {
+		t.Fatalf("failed to verifyPMM: %v", err)
+	}
+
+	if v.Nodes != 2 || v.Services != 1 || len(v.Issues) != 5 {
+		t.Fatalf("expected 2 nodes, 1 service and 5 issues, got %+v", v)
+	}
+
+	out := strings.Builder{}
+	writeVerification(&out, v)
+
+	for _, e := range []string{
+		"2 node(s) and 1 service(s) match the inventory\n",
+		"missing     node     db3           db3   no node named db3\n",
+		"missing     service  db3-primary   db3   no mysql service\n",
+		"unexpected  service  db1-proxysql  -     proxysql service\n",
+		"unexpected  service  exporter      -     external service\n",
+		"unhealthy   service  rds1-mysql    rds1  mysqld_exporter is WAITING\n",
+	} {
+		if !strings.Contains(out.String(), e) {
+			t.Fatalf("expected %q in:\n%s", e, out.String())
+		}
+	}
+
+	unexpected := v.Unexpected()
+	if len(unexpected) != 2 {
+		t.Fatalf("expected 2 unexpected services, got %+v", unexpected)
+	}
+
+	if err := unregisterUnexpected(c, unexpected); err != nil {
+		t.Fatalf("failed to unregisterUnexpected: %v", err)
+	}
+
+	if err := unregisterUnexpected(c, []VerifyIssue{{ID: "n9", Kind: "node", Name: "db9"}}); err == nil || !strings.Contains(err.Error(), "'db9'") {
+		t.Fatalf("expected an error for an unknown node, got %v", err)
+	}
+}